@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AuditEntry is one line of the audit log.
+type AuditEntry struct {
+	Time       time.Time `json:"time"`
+	Action     string    `json:"action"` // input, upload, file_save, rename, kill_window, new_session, ...
+	Identity   string    `json:"identity,omitempty"`
+	RemoteAddr string    `json:"remoteAddr,omitempty"`
+	ClientID   string    `json:"clientId,omitempty"`
+	Target     string    `json:"target,omitempty"`
+	Detail     string    `json:"detail,omitempty"` // typed bytes, file path, new name, ...
+}
+
+// AuditLog is an append-only JSON-lines log of input and administrative
+// actions. When the active file exceeds maxSize it is rotated to path.1,
+// path.1 to path.2, and so on, keeping at most maxFiles rotated files.
+//
+// A nil *AuditLog is valid and records nothing.
+type AuditLog struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	logger   *slog.Logger
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func NewAuditLog(path string, maxSize int64, maxFiles int, logger *slog.Logger) (*AuditLog, error) {
+	a := &AuditLog{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+		logger:   logger,
+	}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *AuditLog) open() error {
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("stat audit log: %w", err)
+	}
+	a.f = f
+	a.size = info.Size()
+	return nil
+}
+
+// rotateLocked shifts path.N-1 -> path.N ... path -> path.1 and reopens path.
+func (a *AuditLog) rotateLocked() error {
+	a.f.Close()
+	a.f = nil
+	for i := a.maxFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", a.path, i), fmt.Sprintf("%s.%d", a.path, i+1))
+	}
+	if a.maxFiles > 0 {
+		os.Rename(a.path, a.path+".1")
+	} else {
+		os.Remove(a.path)
+	}
+	return a.open()
+}
+
+// Record appends an entry. Failures are logged but never block the caller.
+func (a *AuditLog) Record(e AuditEntry) {
+	if a == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.f == nil {
+		if err := a.open(); err != nil {
+			a.logger.Error("audit log unavailable", "error", err)
+			return
+		}
+	}
+	if a.maxSize > 0 && a.size > 0 && a.size+int64(len(line)) > a.maxSize {
+		if err := a.rotateLocked(); err != nil {
+			a.logger.Error("audit log rotation failed", "error", err)
+			return
+		}
+	}
+	n, err := a.f.Write(line)
+	a.size += int64(n)
+	if err != nil {
+		a.logger.Error("audit log write failed", "error", err)
+	}
+}
+
+// RecordRequest appends an entry attributed to the principal and remote
+// address of an HTTP request.
+func (a *AuditLog) RecordRequest(r *http.Request, action, target, detail string) {
+	if a == nil {
+		return
+	}
+	p, _ := PrincipalFrom(r.Context())
+	a.Record(AuditEntry{
+		Action:     action,
+		Identity:   p.Name,
+		RemoteAddr: r.RemoteAddr,
+		Target:     target,
+		Detail:     detail,
+	})
+}
+
+// Query returns entries within [since, until] for target (empty matches all),
+// oldest first, keeping only the most recent limit entries.
+//
+// Query does not hold the write lock, so a rotation racing with a query may
+// cause entries to be skipped or seen twice; input is never stalled by it.
+func (a *AuditLog) Query(since, until time.Time, target string, limit int) ([]AuditEntry, error) {
+	files := []string{}
+	for i := a.maxFiles; i >= 1; i-- {
+		files = append(files, fmt.Sprintf("%s.%d", a.path, i))
+	}
+	files = append(files, a.path)
+
+	result := []AuditEntry{}
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		sc := bufio.NewScanner(f)
+		sc.Buffer(make([]byte, 64*1024), 4*1024*1024)
+		for sc.Scan() {
+			var e AuditEntry
+			if json.Unmarshal(sc.Bytes(), &e) != nil {
+				continue
+			}
+			if (!since.IsZero() && e.Time.Before(since)) || (!until.IsZero() && e.Time.After(until)) {
+				continue
+			}
+			if target != "" && e.Target != target {
+				continue
+			}
+			result = append(result, e)
+			if limit > 0 && len(result) > 2*limit {
+				result = append(result[:0], result[len(result)-limit:]...)
+			}
+		}
+		f.Close()
+	}
+	if limit > 0 && len(result) > limit {
+		result = result[len(result)-limit:]
+	}
+	return result, nil
+}
+
+// Close flushes and closes the active log file.
+func (a *AuditLog) Close() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.f != nil {
+		a.f.Close()
+		a.f = nil
+	}
+}
+
+// HandleQuery serves GET /api/audit?since=&until=&target=&limit=.
+// Times may be RFC 3339 or Unix seconds.
+func (a *AuditLog) HandleQuery(w http.ResponseWriter, r *http.Request) {
+	if a == nil {
+		http.Error(w, "audit log disabled", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	since, err := parseAuditTime(q.Get("since"))
+	if err != nil {
+		http.Error(w, "invalid since", http.StatusBadRequest)
+		return
+	}
+	until, err := parseAuditTime(q.Get("until"))
+	if err != nil {
+		http.Error(w, "invalid until", http.StatusBadRequest)
+		return
+	}
+	limit := 500
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := a.Query(since, until, q.Get("target"), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"entries": entries})
+}
+
+func parseAuditTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditLogRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	a, err := NewAuditLog(path, 512, 2, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	for i := 0; i < 20; i++ {
+		a.Record(AuditEntry{Action: "input", Identity: "alice", Target: "claude:0.0", Detail: fmt.Sprintf("ls %d\r", i)})
+	}
+
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Fatalf("expected rotated file: %v", err)
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("expected at most 2 rotated files, got %v", err)
+	}
+	if info, _ := os.Stat(path); info.Size() > 512 {
+		t.Fatalf("active file exceeds max size: %d", info.Size())
+	}
+
+	// The newest entries survive rotation, oldest first.
+	entries, err := a.Query(time.Time{}, time.Time{}, "", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[2].Detail != "ls 19\r" || entries[0].Detail != "ls 17\r" {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+}
+
+func TestAuditLogQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	a, err := NewAuditLog(path, 0, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	a.Record(AuditEntry{Time: base, Action: "input", Target: "a:0.0", Detail: "one"})
+	a.Record(AuditEntry{Time: base.Add(time.Minute), Action: "input", Target: "b:0.0", Detail: "two"})
+	a.Record(AuditEntry{Time: base.Add(2 * time.Minute), Action: "kill_window", Target: "a:0.0"})
+
+	req := httptest.NewRequest("GET", "/api/audit?target=a:0.0&since="+base.Add(30*time.Second).Format(time.RFC3339), nil)
+	rec := httptest.NewRecorder()
+	a.HandleQuery(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var resp struct {
+		Entries []AuditEntry `json:"entries"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if len(resp.Entries) != 1 || resp.Entries[0].Action != "kill_window" {
+		t.Fatalf("unexpected entries: %+v", resp.Entries)
+	}
+
+	rec = httptest.NewRecorder()
+	a.HandleQuery(rec, httptest.NewRequest("GET", "/api/audit?since=yesterday", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for bad since, got %d", rec.Code)
+	}
+
+	// A nil log records nothing and reports the endpoint as disabled.
+	var disabled *AuditLog
+	disabled.Record(AuditEntry{Action: "input"})
+	rec = httptest.NewRecorder()
+	disabled.HandleQuery(rec, httptest.NewRequest("GET", "/api/audit", nil))
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "disabled") {
+		t.Fatalf("expected 404 for disabled log, got %d", rec.Code)
+	}
+}
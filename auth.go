@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const sessionCookieName = "c3_session"
+
+// Role determines what a principal may do.
+type Role string
+
+const (
+	RoleControl Role = "control" // may type into panes and manage sessions
+	RoleView    Role = "view"    // may only watch output
+)
+
+// AuthToken is a named API token. Only the SHA-256 hash of the token is
+// kept in the config; the plaintext is presented by the client.
+type AuthToken struct {
+	Name string
+	Hash string // hex-encoded SHA-256 of the token
+	Role Role
+}
+
+// ParseAuthToken parses a "name:sha256hex[:role]" token spec. The role
+// defaults to control.
+func ParseAuthToken(spec string) (AuthToken, error) {
+	parts := strings.Split(strings.TrimSpace(spec), ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+		return AuthToken{}, fmt.Errorf("invalid auth token %q: want name:sha256hex[:view]", spec)
+	}
+	hash := strings.ToLower(parts[1])
+	if b, err := hex.DecodeString(hash); err != nil || len(b) != sha256.Size {
+		return AuthToken{}, fmt.Errorf("invalid auth token %q: hash must be 64 hex characters", spec)
+	}
+	role := RoleControl
+	if len(parts) == 3 {
+		role = Role(parts[2])
+		if role != RoleControl && role != RoleView {
+			return AuthToken{}, fmt.Errorf("invalid auth token %q: role must be control or view", spec)
+		}
+	}
+	return AuthToken{Name: parts[0], Hash: hash, Role: role}, nil
+}
+
+// Principal identifies the caller behind a request.
+type Principal struct {
+	Name    string
+	Role    Role
+	Target  string // if set, access is limited to this tmux target
+	ShareID string // set when authenticated through a share link
+}
+
+// CanControl reports whether the principal may send input or change state.
+func (p Principal) CanControl() bool {
+	return p.Role == RoleControl
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying p.
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFrom returns the principal stored in ctx by the auth middleware.
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// Authenticator guards every route with a shared secret or hashed API tokens.
+//
+// Browsers log in once via POST /api/login and then carry an HttpOnly,
+// HMAC-signed session cookie. Scripts send "Authorization: Bearer <token>"
+// on every request, including WebSocket upgrades.
+type Authenticator struct {
+	secret     string
+	viewSecret string
+	tokens     []AuthToken
+	key        []byte // HMAC key for session cookies
+	ttl        time.Duration
+	shares     *ShareManager
+	logger     *slog.Logger
+}
+
+func NewAuthenticator(cfg *Config, shares *ShareManager, logger *slog.Logger) *Authenticator {
+	a := &Authenticator{
+		secret:     cfg.AuthSecret,
+		viewSecret: cfg.AuthViewSecret,
+		tokens:     cfg.AuthTokens,
+		ttl:        cfg.SessionTTL,
+		shares:     shares,
+		logger:     logger,
+	}
+	if a.ttl <= 0 {
+		a.ttl = 30 * 24 * time.Hour
+	}
+
+	// Derive the cookie key from the configured credentials so that sessions
+	// survive restarts but are invalidated when the credentials change.
+	if a.Enabled() {
+		h := sha256.New()
+		h.Write([]byte("c3 session key\x00"))
+		h.Write([]byte(a.secret))
+		h.Write([]byte("\x00" + a.viewSecret))
+		for _, t := range a.tokens {
+			h.Write([]byte("\x00" + t.Name + ":" + t.Hash + ":" + string(t.Role)))
+		}
+		a.key = h.Sum(nil)
+	} else {
+		a.key = make([]byte, 32)
+		rand.Read(a.key)
+	}
+	return a
+}
+
+// Enabled reports whether any credentials are configured.
+func (a *Authenticator) Enabled() bool {
+	return a.secret != "" || a.viewSecret != "" || len(a.tokens) > 0
+}
+
+// checkCredential validates a presented secret or token and returns the
+// principal it identifies.
+func (a *Authenticator) checkCredential(cred string) (Principal, bool) {
+	if cred == "" {
+		return Principal{}, false
+	}
+	if a.secret != "" && subtle.ConstantTimeCompare([]byte(cred), []byte(a.secret)) == 1 {
+		return Principal{Name: "shared-secret", Role: RoleControl}, true
+	}
+	if a.viewSecret != "" && subtle.ConstantTimeCompare([]byte(cred), []byte(a.viewSecret)) == 1 {
+		return Principal{Name: "viewer", Role: RoleView}, true
+	}
+	sum := sha256.Sum256([]byte(cred))
+	hash := hex.EncodeToString(sum[:])
+	for _, t := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(t.Hash)) == 1 {
+			return Principal{Name: t.Name, Role: t.Role}, true
+		}
+	}
+	return Principal{}, false
+}
+
+// Authenticate checks the bearer token, session cookie, and share cookie on r.
+func (a *Authenticator) Authenticate(r *http.Request) (Principal, bool) {
+	if !a.Enabled() {
+		return Principal{Name: "anonymous", Role: RoleControl}, true
+	}
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		scheme, cred, _ := strings.Cut(auth, " ")
+		if strings.EqualFold(scheme, "Bearer") {
+			return a.checkCredential(strings.TrimSpace(cred))
+		}
+	}
+	if c, err := r.Cookie(sessionCookieName); err == nil {
+		if p, ok := a.verifySession(c.Value); ok {
+			return p, true
+		}
+	}
+	if c, err := r.Cookie(shareCookieName); err == nil && a.shares != nil {
+		if s, ok := a.shares.Verify(c.Value); ok {
+			return Principal{Name: "share:" + s.ID, Role: s.Role, Target: s.Target, ShareID: s.ID}, true
+		}
+	}
+	return Principal{}, false
+}
+
+// signSession returns a cookie value of the form name.role.expiry.signature.
+func (a *Authenticator) signSession(p Principal, expiry time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(p.Name)) + "." + string(p.Role) + "." + strconv.FormatInt(expiry.Unix(), 10)
+	return payload + "." + a.sign(payload)
+}
+
+func (a *Authenticator) verifySession(value string) (Principal, bool) {
+	i := strings.LastIndexByte(value, '.')
+	if i < 0 {
+		return Principal{}, false
+	}
+	payload, sig := value[:i], value[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(a.sign(payload))) {
+		return Principal{}, false
+	}
+	parts := strings.Split(payload, ".")
+	if len(parts) != 3 {
+		return Principal{}, false
+	}
+	encName, role, expStr := parts[0], Role(parts[1]), parts[2]
+	exp, err := strconv.ParseInt(expStr, 10, 64)
+	if err != nil || time.Now().Unix() >= exp {
+		return Principal{}, false
+	}
+	name, err := base64.RawURLEncoding.DecodeString(encName)
+	if err != nil {
+		return Principal{}, false
+	}
+	return Principal{Name: string(name), Role: role}, true
+}
+
+func (a *Authenticator) sign(payload string) string {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Middleware rejects unauthenticated requests before they reach next.
+// Browser page loads are redirected to the login page; API and WebSocket
+// requests get a 401.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" || r.URL.Path == "/api/login" || strings.HasPrefix(r.URL.Path, "/share/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		p, ok := a.Authenticate(r)
+		if !ok {
+			if r.Method == http.MethodGet && !isAPIRequest(r) && strings.Contains(r.Header.Get("Accept"), "text/html") {
+				http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+				return
+			}
+			a.logger.Warn("unauthenticated request", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="c3"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !p.Allows(r) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), p)))
+	})
+}
+
+func isAPIRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/") ||
+		strings.HasSuffix(r.URL.Path, "/ws") ||
+		r.Header.Get("Upgrade") != ""
+}
+
+// HandleLogin exchanges a secret or token for a session cookie. It accepts
+// either a form post from the login page or a JSON body {"token": "..."}.
+func (a *Authenticator) HandleLogin(w http.ResponseWriter, r *http.Request) {
+	var cred, next string
+	isForm := !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+	if !isForm {
+		var body struct {
+			Token string `json:"token"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		cred = body.Token
+	} else {
+		cred = r.PostFormValue("token")
+		next = r.PostFormValue("next")
+	}
+
+	p, ok := a.checkCredential(cred)
+	if !a.Enabled() {
+		p, ok = Principal{Name: "anonymous", Role: RoleControl}, true
+	}
+	if !ok {
+		a.logger.Warn("login failed", "remote_addr", r.RemoteAddr)
+		if isForm {
+			http.Redirect(w, r, "/login?failed=1&next="+url.QueryEscape(next), http.StatusSeeOther)
+			return
+		}
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	expiry := time.Now().Add(a.ttl)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    a.signSession(p, expiry),
+		Path:     "/",
+		Expires:  expiry,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	a.logger.Info("login succeeded", "identity", p.Name, "role", p.Role, "remote_addr", r.RemoteAddr)
+
+	if isForm {
+		http.Redirect(w, r, safeRedirect(next), http.StatusSeeOther)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"ok": "true", "identity": p.Name, "role": string(p.Role)})
+}
+
+// RequireControl refuses requests from view-only principals with 403.
+func RequireControl(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if p, ok := PrincipalFrom(r.Context()); ok && !p.CanControl() {
+			http.Error(w, "read-only access", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// HandleLogout clears the session cookie.
+func (a *Authenticator) HandleLogout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"ok": "true"})
+}
+
+// safeRedirect only allows local, absolute paths as redirect targets.
+func safeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="UTF-8" />
+  <meta name="viewport" content="width=device-width, initial-scale=1.0" />
+  <title>c3 — sign in</title>
+  <style>
+    body { background: #1e1e1e; color: #ddd; font-family: -apple-system, system-ui, sans-serif;
+           display: flex; align-items: center; justify-content: center; height: 100vh; margin: 0; }
+    form { display: flex; flex-direction: column; gap: 12px; width: 280px; }
+    input { padding: 10px; font-size: 16px; border: 1px solid #444; border-radius: 6px; background: #2a2a2a; color: #eee; }
+    button { padding: 10px; font-size: 16px; border: 0; border-radius: 6px; background: #3b82f6; color: #fff; }
+    .error { color: #f87171; font-size: 14px; }
+  </style>
+</head>
+<body>
+  <form method="POST" action="/api/login">
+    <strong>c3</strong>
+    {{if .Failed}}<div class="error">Invalid token</div>{{end}}
+    <input type="password" name="token" placeholder="Access token" autofocus autocomplete="current-password" />
+    <input type="hidden" name="next" value="{{.Next}}" />
+    <button type="submit">Sign in</button>
+  </form>
+</body>
+</html>
+`))
+
+// HandleLoginPage serves a minimal login form.
+func (a *Authenticator) HandleLoginPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	loginPage.Execute(w, map[string]any{
+		"Failed": r.URL.Query().Get("failed") != "",
+		"Next":   safeRedirect(r.URL.Query().Get("next")),
+	})
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testAuthenticator(t *testing.T) *Authenticator {
+	t.Helper()
+	alice := sha256.Sum256([]byte("alice-token"))
+	bob := sha256.Sum256([]byte("bob-token"))
+	cfg := &Config{
+		AuthSecret:     "s3cret",
+		AuthViewSecret: "watch-only",
+		AuthTokens: []AuthToken{
+			{Name: "alice", Hash: hex.EncodeToString(alice[:]), Role: RoleControl},
+			{Name: "bob", Hash: hex.EncodeToString(bob[:]), Role: RoleView},
+		},
+		SessionTTL: time.Hour,
+	}
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	return NewAuthenticator(cfg, NewShareManager(logger), logger)
+}
+
+func TestParseAuthToken(t *testing.T) {
+	sum := sha256.Sum256([]byte("x"))
+	hash := hex.EncodeToString(sum[:])
+
+	tok, err := ParseAuthToken("bob:" + strings.ToUpper(hash))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tok.Name != "bob" || tok.Hash != hash || tok.Role != RoleControl {
+		t.Fatalf("unexpected token: %+v", tok)
+	}
+
+	tok, err = ParseAuthToken("carol:" + hash + ":view")
+	if err != nil || tok.Role != RoleView {
+		t.Fatalf("expected view token, got %+v (%v)", tok, err)
+	}
+
+	for _, bad := range []string{"", "bob", ":" + hash, "bob:abcd", "bob:" + strings.Repeat("z", 64), "bob:" + hash + ":admin"} {
+		if _, err := ParseAuthToken(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	a := testAuthenticator(t)
+	var got Principal
+	h := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = PrincipalFrom(r.Context())
+	}))
+
+	// No credentials: API requests get 401.
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/sessions", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rec.Code)
+	}
+
+	// No credentials: WebSocket upgrades are rejected before reaching the handler.
+	req := httptest.NewRequest("GET", "/s/claude:0.0/ws", nil)
+	req.Header.Set("Upgrade", "websocket")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for ws, got %d", rec.Code)
+	}
+
+	// No credentials: page loads redirect to the login page.
+	req = httptest.NewRequest("GET", "/s/claude:0.0/", nil)
+	req.Header.Set("Accept", "text/html")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusSeeOther || !strings.HasPrefix(rec.Header().Get("Location"), "/login?next=") {
+		t.Fatalf("expected redirect to login, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	// Bearer token.
+	req = httptest.NewRequest("GET", "/api/sessions", nil)
+	req.Header.Set("Authorization", "Bearer alice-token")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || got.Name != "alice" || !got.CanControl() {
+		t.Fatalf("expected alice to be authenticated, got %d %+v", rec.Code, got)
+	}
+
+	// View-only token.
+	req = httptest.NewRequest("GET", "/api/sessions", nil)
+	req.Header.Set("Authorization", "Bearer bob-token")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || got.Name != "bob" || got.CanControl() {
+		t.Fatalf("expected bob to be a viewer, got %d %+v", rec.Code, got)
+	}
+
+	// Wrong bearer token.
+	req = httptest.NewRequest("GET", "/api/sessions", nil)
+	req.Header.Set("Authorization", "Bearer nope")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for bad token, got %d", rec.Code)
+	}
+}
+
+func TestAuthLoginCookie(t *testing.T) {
+	a := testAuthenticator(t)
+	h := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := PrincipalFrom(r.Context())
+		w.Write([]byte(p.Name))
+	}))
+
+	// Form login with the shared secret redirects to next and sets a cookie.
+	form := url.Values{"token": {"s3cret"}, "next": {"/s/claude:0.0/"}}
+	req := httptest.NewRequest("POST", "/api/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	a.HandleLogin(rec, req)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/s/claude:0.0/" {
+		t.Fatalf("expected redirect to next, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != sessionCookieName || !cookies[0].HttpOnly {
+		t.Fatalf("expected HttpOnly session cookie, got %+v", cookies)
+	}
+
+	req = httptest.NewRequest("GET", "/api/sessions", nil)
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != "shared-secret" {
+		t.Fatalf("expected cookie to authenticate, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	// A tampered cookie is rejected.
+	req = httptest.NewRequest("GET", "/api/sessions", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: cookies[0].Value + "x"})
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for tampered cookie, got %d", rec.Code)
+	}
+
+	// JSON login with a bad token fails.
+	req = httptest.NewRequest("POST", "/api/login", strings.NewReader(`{"token":"wrong"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec = httptest.NewRecorder()
+	a.HandleLogin(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for bad login, got %d", rec.Code)
+	}
+
+	// Open redirects are refused.
+	if got := safeRedirect("//evil.example"); got != "/" {
+		t.Fatalf("expected open redirect to be refused, got %q", got)
+	}
+}
+
+func TestRequireControl(t *testing.T) {
+	a := testAuthenticator(t)
+	h := a.Middleware(RequireControl(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, tc := range []struct {
+		cred string
+		want int
+	}{
+		{"s3cret", http.StatusOK},
+		{"alice-token", http.StatusOK},
+		{"watch-only", http.StatusForbidden},
+		{"bob-token", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest("POST", "/api/kill-window", nil)
+		req.Header.Set("Authorization", "Bearer "+tc.cred)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.cred, tc.want, rec.Code)
+		}
+	}
+
+	// The viewer role survives the login cookie round trip.
+	req := httptest.NewRequest("POST", "/api/login", strings.NewReader(`{"token":"watch-only"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	a.HandleLogin(rec, req)
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected session cookie, got %+v", cookies)
+	}
+	req = httptest.NewRequest("POST", "/api/kill-window", nil)
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected viewer cookie to be refused, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// OutputStats is the per-session entry of /api/metrics. The rates cover the
+// last complete second.
+type OutputStats struct {
+	Chunks       int64 `json:"chunks"` // reads from the pane
+	Frames       int64 `json:"frames"` // batches broadcast to clients
+	Bytes        int64 `json:"bytes"`
+	FramesPerSec int64 `json:"framesPerSec"`
+	BytesPerSec  int64 `json:"bytesPerSec"`
+}
+
+// OutputBatcher coalesces a session's pane output before it is broadcast,
+// so a burst of small reads (a spinner, a TUI redraw) reaches clients as one
+// frame. A batch is flushed once window has passed since its first chunk or
+// it reaches maxBytes. A zero window flushes every chunk immediately.
+type OutputBatcher struct {
+	mu       sync.Mutex
+	window   time.Duration
+	maxBytes int
+	flush    func(offset int64, data []byte)
+	timer    *time.Timer
+	offset   int64 // ring offset of pending[0]
+	pending  []byte
+	stats    OutputStats
+
+	// Counts for the current second, rolled into stats' rates.
+	second      time.Time
+	secFrames   int64
+	secBytes    int64
+	statsSecond time.Time // second the rates in stats were measured over
+}
+
+func NewOutputBatcher(window time.Duration, maxBytes int, flush func(offset int64, data []byte)) *OutputBatcher {
+	return &OutputBatcher{
+		window:   window,
+		maxBytes: maxBytes,
+		flush:    flush,
+	}
+}
+
+// Add queues a chunk of output that begins at ring offset offset.
+func (b *OutputBatcher) Add(offset int64, data []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.stats.Chunks++
+	if len(b.pending) > 0 && offset != b.offset+int64(len(b.pending)) {
+		b.flushLocked() // not contiguous, e.g. after a reattach
+	}
+	if len(b.pending) == 0 {
+		b.offset = offset
+	}
+	b.pending = append(b.pending, data...)
+
+	if b.window <= 0 || len(b.pending) >= b.maxBytes {
+		b.flushLocked()
+		return
+	}
+	if b.timer == nil {
+		b.timer = time.AfterFunc(b.window, b.Flush)
+	}
+}
+
+// Flush broadcasts any pending output now and cancels the timer.
+func (b *OutputBatcher) Flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.flushLocked()
+}
+
+func (b *OutputBatcher) flushLocked() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.pending) == 0 {
+		return
+	}
+	data := b.pending
+	b.pending = nil // clients may still hold data in their queues
+	b.flush(b.offset, data)
+
+	b.stats.Frames++
+	b.stats.Bytes += int64(len(data))
+	b.rollLocked(time.Now())
+	b.secFrames++
+	b.secBytes += int64(len(data))
+}
+
+// rollLocked starts a new one-second bucket if now is past the current one,
+// publishing the finished bucket's counts as the rates.
+func (b *OutputBatcher) rollLocked(now time.Time) {
+	sec := now.Truncate(time.Second)
+	if sec.Equal(b.second) {
+		return
+	}
+	b.stats.FramesPerSec, b.stats.BytesPerSec = b.secFrames, b.secBytes
+	b.statsSecond = b.second
+	b.second, b.secFrames, b.secBytes = sec, 0, 0
+}
+
+// Stats returns the batcher's counters and rates.
+func (b *OutputBatcher) Stats() OutputStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollLocked(time.Now())
+	stats := b.stats
+	if !b.statsSecond.Equal(b.second.Add(-time.Second)) {
+		// The last bucket is older than the previous second: idle since.
+		stats.FramesPerSec, stats.BytesPerSec = 0, 0
+	}
+	return stats
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type flushRecorder struct {
+	mu      sync.Mutex
+	offsets []int64
+	data    []string
+}
+
+func (r *flushRecorder) flush(offset int64, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.offsets = append(r.offsets, offset)
+	r.data = append(r.data, string(data))
+}
+
+func (r *flushRecorder) get() ([]int64, []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int64(nil), r.offsets...), append([]string(nil), r.data...)
+}
+
+func TestOutputBatcherCoalesces(t *testing.T) {
+	var rec flushRecorder
+	b := NewOutputBatcher(50*time.Millisecond, 1024, rec.flush)
+
+	b.Add(0, []byte("ab"))
+	b.Add(2, []byte("cd"))
+	b.Add(4, []byte("ef"))
+	if offsets, _ := rec.get(); len(offsets) != 0 {
+		t.Fatalf("flushed before the window elapsed: %v", offsets)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	offsets, data := rec.get()
+	if len(data) != 1 || offsets[0] != 0 || data[0] != "abcdef" {
+		t.Fatalf("expected one batch at 0, got %v %q", offsets, data)
+	}
+
+	stats := b.Stats()
+	if stats.Chunks != 3 || stats.Frames != 1 || stats.Bytes != 6 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestOutputBatcherFlushesEarly(t *testing.T) {
+	var rec flushRecorder
+	b := NewOutputBatcher(time.Hour, 4, rec.flush)
+
+	// Reaching the byte budget flushes without waiting.
+	b.Add(0, []byte("abc"))
+	b.Add(3, []byte("de"))
+	// A gap in offsets flushes what came before it.
+	b.Add(10, []byte("x"))
+	b.Add(20, []byte("y"))
+	b.Flush()
+
+	offsets, data := rec.get()
+	wantOffsets := []int64{0, 10, 20}
+	wantData := []string{"abcde", "x", "y"}
+	if len(data) != len(wantData) {
+		t.Fatalf("expected %q, got %q", wantData, data)
+	}
+	for i := range wantData {
+		if offsets[i] != wantOffsets[i] || data[i] != wantData[i] {
+			t.Fatalf("batch %d: got %q at %d, want %q at %d", i, data[i], offsets[i], wantData[i], wantOffsets[i])
+		}
+	}
+}
+
+func TestOutputBatcherDisabled(t *testing.T) {
+	var rec flushRecorder
+	b := NewOutputBatcher(0, 1024, rec.flush)
+
+	b.Add(0, []byte("a"))
+	b.Add(1, []byte("b"))
+	if _, data := rec.get(); len(data) != 2 {
+		t.Fatalf("expected every chunk flushed immediately, got %q", data)
+	}
+}
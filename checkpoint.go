@@ -0,0 +1,129 @@
+package main
+
+import (
+	"time"
+)
+
+// maxCheckpoints and maxCheckpointBytes bound the checkpoints kept per ring
+// buffer, by count and by the total size of their states, which include the
+// emulator's scrollback. Past either, the checkpoint closest to its
+// neighbours is dropped so the rest stay evenly spread over the buffer. The
+// newest is always kept.
+const (
+	maxCheckpoints     = 64
+	maxCheckpointBytes = 32 * 1024 * 1024
+)
+
+// Checkpoint is a serialized terminal state together with the ring offset it
+// corresponds to. Replaying State followed by the ring from Offset reproduces
+// the terminal without reading anything older.
+type Checkpoint struct {
+	Offset int64
+	Time   time.Time
+	State  []byte
+}
+
+// AddCheckpoint records cp and discards checkpoints whose offsets can no
+// longer be read back from memory or disk.
+func (rb *RingBuffer) AddCheckpoint(cp Checkpoint) {
+	oldest := rb.readableOffset()
+
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	cps := rb.checkpoints[:0]
+	for _, c := range rb.checkpoints {
+		if c.Offset >= oldest && c.Offset < cp.Offset {
+			cps = append(cps, c)
+		}
+	}
+	cps = append(cps, cp)
+	size := 0
+	for _, c := range cps {
+		size += len(c.State)
+	}
+	for len(cps) > maxCheckpoints || len(cps) > 1 && size > maxCheckpointBytes {
+		drop := 0
+		if len(cps) > 2 {
+			drop = 1
+			for i := 2; i < len(cps)-1; i++ {
+				if cps[i+1].Offset-cps[i-1].Offset < cps[drop+1].Offset-cps[drop-1].Offset {
+					drop = i
+				}
+			}
+		}
+		size -= len(cps[drop].State)
+		cps = append(cps[:drop], cps[drop+1:]...)
+	}
+	rb.checkpoints = cps
+}
+
+// CheckpointBefore returns the latest checkpoint taken at or before t whose
+// offset is still readable.
+func (rb *RingBuffer) CheckpointBefore(t time.Time) (Checkpoint, bool) {
+	oldest := rb.readableOffset()
+
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	for i := len(rb.checkpoints) - 1; i >= 0; i-- {
+		cp := rb.checkpoints[i]
+		if cp.Offset < oldest {
+			break
+		}
+		if !cp.Time.After(t) {
+			return cp, true
+		}
+	}
+	return Checkpoint{}, false
+}
+
+// readableOffset returns the oldest offset ReadFrom can serve.
+func (rb *RingBuffer) readableOffset() int64 {
+	rb.mu.Lock()
+	oldest := rb.oldestOffset()
+	rb.mu.Unlock()
+	if rb.store != nil {
+		if diskOldest, _ := rb.store.Range(); diskOldest < oldest {
+			return diskOldest
+		}
+	}
+	return oldest
+}
+
+// checkpointer takes a checkpoint of a session's terminal emulator whenever
+// interval has passed or bytes have been written since the last one. A zero
+// interval or byte count disables that trigger.
+type checkpointer struct {
+	vt       *VTerm
+	ring     *RingBuffer
+	interval time.Duration
+	bytes    int64
+
+	lastTime   time.Time
+	lastOffset int64
+}
+
+func newCheckpointer(vt *VTerm, ring *RingBuffer, interval time.Duration, bytes int64) *checkpointer {
+	return &checkpointer{
+		vt:         vt,
+		ring:       ring,
+		interval:   interval,
+		bytes:      bytes,
+		lastTime:   time.Now(),
+		lastOffset: ring.WritePos(),
+	}
+}
+
+// observe is called after output up to end has been fed to the emulator.
+func (cp *checkpointer) observe(end int64) {
+	now := time.Now()
+	due := cp.interval > 0 && now.Sub(cp.lastTime) >= cp.interval
+	due = due || cp.bytes > 0 && end-cp.lastOffset >= cp.bytes
+	if !due {
+		return
+	}
+	state, offset := cp.vt.Snapshot()
+	cp.ring.AddCheckpoint(Checkpoint{Offset: offset, Time: now, State: state})
+	cp.lastTime, cp.lastOffset = now, offset
+}
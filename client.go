@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
@@ -15,30 +14,81 @@ import (
 
 var clientCounter atomic.Int64
 
+// ClientStats is the per-client entry of /api/metrics.
+type ClientStats struct {
+	ID         string `json:"id"`
+	Identity   string `json:"identity,omitempty"`
+	Role       Role   `json:"role"`
+	RemoteAddr string `json:"remoteAddr"`
+	Binary     bool   `json:"binary"`
+	RawBytes   int64  `json:"rawBytes"`  // message bytes sent before compression
+	WireBytes  int64  `json:"wireBytes"` // bytes written to the socket
+	Throttled  bool   `json:"throttled,omitempty"`
+}
+
+// ClientInfo describes who is behind a WebSocket connection.
+type ClientInfo struct {
+	Role       Role
+	Identity   string
+	RemoteAddr string
+}
+
 // Client represents a single WebSocket client connection.
 type Client struct {
 	id      string
 	conn    *websocket.Conn
+	stats   *ConnStats
 	hub     *Hub
-	pty     *PTYManager
+	pty     PaneIO
 	ring    *RingBuffer
+	vt      *VTerm
+	sizer   *Sizer
+	info    ClientInfo
+	audit   *AuditLog
 	cfg     *Config
-	sendCh  chan []byte
+	sendCh  chan wsFrame
 	logger  *slog.Logger
 	dropped int
+	binary  bool // negotiated binary framing; set from the hello before registration
+
+	// lagging is set by the hub when the send queue overflows under the
+	// fast-forward policy; broadcasts skip the client until resyncCh has
+	// been handled by the write pump.
+	lagging  atomic.Bool
+	resyncCh chan struct{}
+
+	// limit is the output rate cap from the hello, or nil. throttled is set
+	// by the hub when a broadcast exceeds it; broadcasts then skip the
+	// client and throttleLoop sends it screen updates instead.
+	limit     *rateLimit
+	throttled atomic.Bool
+
+	// liveFrom is the ring offset live output starts at; broadcasts before
+	// it were already sent in the replay or snapshot. Set before registration.
+	liveFrom int64
+
+	// pane is the tmux pane ID when the client is one pane of a window
+	// connection; every message it writes is wrapped for that pane.
+	pane string
 }
 
-func NewClient(conn *websocket.Conn, hub *Hub, pty *PTYManager, ring *RingBuffer, cfg *Config, logger *slog.Logger) *Client {
+func NewClient(conn *websocket.Conn, stats *ConnStats, hub *Hub, pty PaneIO, ring *RingBuffer, vt *VTerm, sizer *Sizer, info ClientInfo, audit *AuditLog, cfg *Config, logger *slog.Logger) *Client {
 	id := fmt.Sprintf("c%d", clientCounter.Add(1))
 	return &Client{
-		id:     id,
-		conn:   conn,
-		hub:    hub,
-		pty:    pty,
-		ring:   ring,
-		cfg:    cfg,
-		sendCh: make(chan []byte, cfg.ClientQueueSize),
-		logger: logger.With("client_id", id),
+		id:       id,
+		conn:     conn,
+		stats:    stats,
+		hub:      hub,
+		pty:      pty,
+		ring:     ring,
+		vt:       vt,
+		sizer:    sizer,
+		info:     info,
+		audit:    audit,
+		cfg:      cfg,
+		sendCh:   make(chan wsFrame, cfg.ClientQueueSize),
+		resyncCh: make(chan struct{}, 1),
+		logger:   logger.With("client_id", id, "role", info.Role, "identity", info.Identity),
 	}
 }
 
@@ -56,6 +106,7 @@ func (c *Client) Run(ctx context.Context) {
 func (c *Client) readPump(ctx context.Context) {
 	defer func() {
 		c.hub.Unregister(c)
+		c.sizer.Leave(c.id)
 		c.conn.CloseNow()
 	}()
 
@@ -77,43 +128,34 @@ func (c *Client) readPump(ctx context.Context) {
 		c.sendError(ctx, "first message must be hello")
 		return
 	}
-
-	// Perform replay.
-	if err := c.replay(ctx, hello); err != nil {
-		c.logger.Error("replay failed", "error", err)
+	if err := c.start(ctx, hello); err != nil {
+		c.logger.Error("client start failed", "error", err)
 		return
 	}
 
-	// Register for live fan-out after replay completes.
-	c.hub.Register(c)
-
-	// Send current status.
-	c.sendStatus(ctx)
-
-	// Inject Ctrl-L to force the TUI to do a full redraw. The capture-pane
-	// snapshot shows the right visual content but doesn't set up terminal state
-	// (scroll regions, alternate screen, bracket paste, etc.). The Ctrl-L redraw
-	// comes through pipe-pane → hub → this client, setting up all state correctly.
-	// Since dimensions match, the redraw is visually identical to the snapshot.
-	if hello.ReplayMode != "full" {
-		go func() {
-			time.Sleep(200 * time.Millisecond)
-			c.pty.WriteInput([]byte("\x0c"))
-		}()
-	}
-
 	// Read loop for input/resize messages.
-	// Note: we intentionally do NOT forward resize to the PTY.
-	// The web client adapts to the pane's dimensions (sent in status),
-	// rather than resizing the pane to match the browser. This prevents
-	// TUI rendering corruption from mid-animation resize races.
+	// Under the default pane resize policy, resizes are ignored: the web
+	// client adapts to the pane's dimensions (sent in status) rather than
+	// resizing the pane to match the browser, which avoids TUI rendering
+	// corruption from mid-animation resize races. Other policies let the
+	// Sizer arbitrate between the clients' sizes.
 	for {
-		_, raw, err := c.conn.Read(ctx)
+		typ, raw, err := c.conn.Read(ctx)
 		if err != nil {
-			c.logger.Info("client disconnected", "error", err)
+			c.logger.Info("client disconnected", "error", err, "bytes_raw", c.stats.Raw(), "bytes_wire", c.stats.Wire())
 			return
 		}
 
+		if typ == websocket.MessageBinary {
+			data, err := DecodeInputFrame(raw)
+			if err != nil {
+				c.logger.Warn("invalid binary frame", "error", err)
+				continue
+			}
+			c.handleInput(data)
+			continue
+		}
+
 		msg, err := ParseClientMessage(raw)
 		if err != nil {
 			c.logger.Warn("invalid message", "error", err)
@@ -127,27 +169,90 @@ func (c *Client) readPump(ctx context.Context) {
 				c.logger.Warn("invalid base64 input", "error", err)
 				continue
 			}
-			c.pty.WriteInput(data)
+			c.handleInput(data)
 		case *ResizeMsg:
-			// Ignored — the pane dimensions are authoritative.
-			// The client should match its terminal to the pane size.
-			_ = m
+			c.handleResize(m.Cols, m.Rows)
 		default:
 			c.logger.Warn("unexpected message type in read loop", "msg", msg)
 		}
 	}
 }
 
+// start applies the hello, sends the client what it needs to catch up and
+// registers it for live output.
+func (c *Client) start(ctx context.Context, hello *HelloMsg) error {
+	c.binary = hello.Binary
+	c.limit = newRateLimit(hello.MaxBytesPerSec, hello.MaxFramesPerSec)
+
+	// Resume from the client's offset if possible; otherwise perform replay.
+	resumed, err := c.resume(ctx, hello)
+	if err != nil {
+		return fmt.Errorf("resume: %w", err)
+	}
+	if !resumed {
+		from, err := c.replay(ctx, hello)
+		if err != nil {
+			return fmt.Errorf("replay: %w", err)
+		}
+
+		// Register for live fan-out from where the replay left off.
+		if err := c.hub.RegisterAt(c, c.ring, from); err != nil {
+			return fmt.Errorf("replay catch-up: %w", err)
+		}
+	}
+
+	// Send current status.
+	c.sendStatus(ctx)
+	if c.limit != nil {
+		go c.throttleLoop(ctx)
+	}
+	return nil
+}
+
+// handleInput forwards keystrokes from either framing mode to the pane.
+func (c *Client) handleInput(data []byte) {
+	if c.info.Role != RoleControl {
+		c.queueError("read-only connection: input rejected")
+		return
+	}
+	c.audit.Record(AuditEntry{
+		Action:     "input",
+		Identity:   c.info.Identity,
+		RemoteAddr: c.info.RemoteAddr,
+		ClientID:   c.id,
+		Target:     c.pty.Target(),
+		Detail:     string(data),
+	})
+	c.sizer.Touch(c.id)
+	c.pty.WriteInput(data)
+}
+
+// handleResize offers the client's terminal size to the pane's Sizer.
+// Read-only viewers never resize the pane.
+func (c *Client) handleResize(cols, rows int) {
+	if c.info.Role != RoleControl {
+		return
+	}
+	c.sizer.Request(c.id, cols, rows)
+}
+
 func (c *Client) writePump(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-c.resyncCh:
+			if err := c.fastForward(ctx); err != nil {
+				if ctx.Err() == nil {
+					c.logger.Error("fast-forward failed", "error", err)
+				}
+				return
+			}
 		case msg, ok := <-c.sendCh:
 			if !ok {
 				return
 			}
-			err := c.conn.Write(ctx, websocket.MessageText, msg)
+			err := c.write(ctx, msg.typ, msg.data)
 			if err != nil {
 				// Don't log errors when context is cancelled (normal disconnect)
 				if ctx.Err() == nil {
@@ -159,136 +264,305 @@ func (c *Client) writePump(ctx context.Context) {
 	}
 }
 
-func (c *Client) replay(ctx context.Context, hello *HelloMsg) error {
-	start := time.Now()
+// fastForward discards everything queued for a lagging client, tells it its
+// history was truncated and resyncs it from a terminal snapshot.
+func (c *Client) fastForward(ctx context.Context) error {
+	skipped := len(c.sendCh)
+	for len(c.sendCh) > 0 {
+		<-c.sendCh
+	}
 
-	// For fast connect (tail mode, the default): send a tmux capture-pane
-	// snapshot of the current screen, then stream live. This is instant
-	// and gives a clean render. The ring buffer tail is not sent because
-	// it starts mid-stream and xterm.js can't reconstruct state from it.
-	//
-	// For full replay: send the entire ring buffer. This takes longer but
-	// gives complete scrollback history.
-	if hello.ReplayMode != "full" {
-		target := c.pty.Target()
-		if target != "" {
-			// Get pane dimensions to separate scrollback from visible area
-			_, paneRows, _ := PaneDimensions(target)
-			if paneRows <= 0 {
-				paneRows = 66
-			}
+	status := c.status()
+	status.Truncated = true
+	if err := c.sendJSON(ctx, status); err != nil {
+		return fmt.Errorf("status write error: %w", err)
+	}
+	state, offset := c.vt.Snapshot()
+	if err := c.hub.Resync(c, c.ring, state, offset); err != nil {
+		return err
+	}
+	c.logger.Info("fast-forwarded slow client", "skipped_messages", skipped, "offset", offset)
+	return nil
+}
 
-			// Capture visible area + scrollback in one call
-			fullSnapshot, err := CapturePane(target, 2000)
-			if err != nil || len(fullSnapshot) == 0 {
-				return nil
-			}
+// throttleLoop sends a client that is over its rate limit screen updates
+// from the terminal emulator, each redrawing only the rows that changed, as
+// often as the limit allows. Once an interval passes without new output the
+// client is handed back to the live stream.
+func (c *Client) throttleLoop(ctx context.Context) {
+	ticker := time.NewTicker(c.limit.interval())
+	defer ticker.Stop()
 
-			lines := bytes.Split(fullSnapshot, []byte("\n"))
-			// Remove trailing empty line from split if present
-			if len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
-				lines = lines[:len(lines)-1]
+	var view *vtView // what the client was last sent; nil while streaming
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !c.throttled.Load() {
+			continue
+		}
+		if view == nil {
+			status := c.status()
+			status.Throttled = true
+			raw, _ := json.Marshal(status)
+			if !c.hub.Queue(c, textFrame(raw)) {
+				continue
 			}
+		}
 
-			var scrollbackLines, visibleLines [][]byte
-			if len(lines) > paneRows {
-				scrollbackLines = lines[:len(lines)-paneRows]
-				visibleLines = lines[len(lines)-paneRows:]
-			} else {
-				visibleLines = lines
+		data, next, offset := c.vt.ScreenUpdate(view)
+		if data == nil {
+			// The client's screen matches the terminal at offset.
+			if err := c.hub.Resync(c, c.ring, nil, offset); err != nil {
+				c.logger.Error("resuming stream failed", "error", err)
+				c.conn.CloseNow()
+				return
 			}
+			view = nil
+			continue
+		}
+		if view != nil && !c.limit.allow(len(data)) {
+			continue
+		}
+		if c.hub.Queue(c, (&outputFrames{offset: noOffset, data: data}).frame(c.binary)) {
+			view = next
+		}
+	}
+}
 
-			var buf []byte
+// resume streams the bytes a reconnecting client missed, straight from the
+// ring buffer, and registers it for live output. It reports false, after
+// telling the client to expect a snapshot, when the client's offset has been
+// overwritten or belongs to an earlier epoch.
+func (c *Client) resume(ctx context.Context, hello *HelloMsg) (bool, error) {
+	if hello.ReplayMode != "resume" {
+		return false, nil
+	}
+	start := time.Now()
 
-			// 1. Send scrollback lines (they'll scroll into xterm.js scrollback)
-			for _, line := range scrollbackLines {
-				buf = append(buf, line...)
-				buf = append(buf, '\r', '\n')
-			}
+	fallback := func(reason string) (bool, error) {
+		c.logger.Info("resume not possible, sending snapshot", "reason", reason, "offset", hello.Offset, "epoch", hello.Epoch)
+		return false, c.sendJSON(ctx, ReplayMsg{Type: "replay", Mode: "snapshot"})
+	}
 
-			// 2. Clear screen + cursor home, then write visible area
-			buf = append(buf, "\x1b[2J\x1b[H"...)
-			for i, line := range visibleLines {
-				buf = append(buf, line...)
-				if i < len(visibleLines)-1 {
-					buf = append(buf, '\r', '\n')
-				}
-			}
+	if hello.Epoch != c.pty.Epoch() {
+		return fallback("epoch changed")
+	}
+	if hello.Offset > c.ring.WritePos() {
+		return fallback("offset ahead of buffer")
+	}
+	if err := c.sendJSON(ctx, ReplayMsg{Type: "replay", Mode: "resume", Offset: hello.Offset}); err != nil {
+		return false, err
+	}
 
-			// 3. Restore cursor position
-			if col, row, err := CursorPosition(target); err == nil {
-				buf = append(buf, []byte(fmt.Sprintf("\x1b[%d;%dH", row+1, col+1))...)
+	// Stream the bulk directly; RegisterAt picks up whatever arrives meanwhile.
+	pos := hello.Offset
+	buf := make([]byte, 64*1024)
+	for {
+		n, next, err := c.ring.ReadFrom(pos, buf)
+		if err != nil {
+			if pos == hello.Offset {
+				return fallback("offset overwritten")
 			}
+			return false, fmt.Errorf("resume read: %w", err)
+		}
+		if n == 0 {
+			break
+		}
+		if err := c.sendOutputFrame(ctx, pos, buf[:n]); err != nil {
+			return false, fmt.Errorf("resume write error: %w", err)
+		}
+		pos = next
+	}
 
-			if err := c.sendOutputFrame(ctx, buf); err != nil {
-				return fmt.Errorf("snapshot write error: %w", err)
-			}
-			c.logger.Info("snapshot sent",
-				"bytes", len(buf),
-				"scrollback_lines", len(scrollbackLines),
-				"visible_lines", len(visibleLines),
-				"duration", time.Since(start))
+	if err := c.hub.RegisterAt(c, c.ring, pos); err != nil {
+		return false, fmt.Errorf("resume catch-up: %w", err)
+	}
+	c.logger.Info("resumed", "from", hello.Offset, "bytes", pos-hello.Offset, "duration", time.Since(start))
+	return true, nil
+}
+
+func (c *Client) replay(ctx context.Context, hello *HelloMsg) (int64, error) {
+	start := time.Now()
+
+	// For fast connect (tail mode, the default): send a snapshot of the
+	// server-side terminal emulator, then stream live from the offset it was
+	// taken at. This is instant and reproduces the exact terminal state. The
+	// ring buffer tail is not sent because it starts mid-stream and xterm.js
+	// can't reconstruct state from it.
+	//
+	// For full replay: send the entire ring buffer. This takes longer but
+	// gives complete scrollback history. If the client only needs history
+	// since a point in time, start from the last checkpoint before it
+	// instead, which bounds the replay however large the buffer is.
+	//
+	// Either way the returned offset is where live output continues.
+	if hello.ReplayMode != "full" {
+		return c.snapshot(ctx, start)
+	}
+	if hello.Since > 0 {
+		if cp, ok := c.ring.CheckpointBefore(time.UnixMilli(hello.Since)); ok {
+			return c.replayCheckpoint(ctx, cp, start)
 		}
-		return nil
 	}
 
-	var data []byte
-	switch hello.ReplayMode {
-	case "full":
-		data, _ = c.ring.Snapshot()
-	default: // "tail" or default
-		tailSize := hello.TailSize
-		if tailSize <= 0 {
-			tailSize = c.cfg.TailReplaySize
+	// Stream from the oldest byte on disk or in memory in chunks, so a large
+	// disk store is never read into memory at once. History that is pruned
+	// or cannot be read meanwhile is skipped rather than failing the replay.
+	from := c.ring.readableOffset()
+	c.logger.Info("replaying", "mode", hello.ReplayMode, "bytes", c.ring.WritePos()-from)
+	pos := from
+	buf := make([]byte, 64*1024)
+	for {
+		n, next, err := c.ring.ReadFrom(pos, buf)
+		if err != nil {
+			if next <= pos {
+				return 0, fmt.Errorf("replay read: %w", err)
+			}
+			c.logger.Warn("replay skipping unreadable history", "from", pos, "to", next, "error", err)
+			pos = next
+			continue
 		}
-		if tailSize > c.cfg.RingBufferSize {
-			tailSize = c.cfg.RingBufferSize
+		if n == 0 {
+			break
 		}
-		data, _ = c.ring.Tail(tailSize)
+		if err := c.sendOutputFrame(ctx, pos, buf[:n]); err != nil {
+			return 0, fmt.Errorf("replay write error: %w", err)
+		}
+		pos = next
+	}
+	c.logger.Info("replay complete", "bytes", pos-from, "duration", time.Since(start))
+	return pos, nil
+}
+
+// replayCheckpoint sends a checkpoint's terminal state followed by the ring
+// from the checkpoint's offset.
+func (c *Client) replayCheckpoint(ctx context.Context, cp Checkpoint, start time.Time) (int64, error) {
+	if err := c.sendJSON(ctx, ReplayMsg{Type: "replay", Mode: "checkpoint", Offset: cp.Offset}); err != nil {
+		return 0, err
+	}
+	if err := c.sendOutputFrame(ctx, noOffset, cp.State); err != nil {
+		return 0, fmt.Errorf("checkpoint write error: %w", err)
 	}
 
-	if len(data) > 0 {
-		c.logger.Info("replaying", "mode", hello.ReplayMode, "bytes", len(data))
+	pos := cp.Offset
+	buf := make([]byte, 64*1024)
+	for {
+		n, next, err := c.ring.ReadFrom(pos, buf)
+		if err != nil {
+			return 0, fmt.Errorf("checkpoint replay read: %w", err)
+		}
+		if n == 0 {
+			break
+		}
+		if err := c.sendOutputFrame(ctx, pos, buf[:n]); err != nil {
+			return 0, fmt.Errorf("replay write error: %w", err)
+		}
+		pos = next
+	}
+	c.logger.Info("replay complete", "checkpoint", cp.Offset, "checkpoint_time", cp.Time,
+		"bytes", int64(len(cp.State))+pos-cp.Offset, "duration", time.Since(start))
+	return pos, nil
+}
 
-		const chunkSize = 64 * 1024
-		for i := 0; i < len(data); i += chunkSize {
-			end := i + chunkSize
-			if end > len(data) {
-				end = len(data)
-			}
-			if err := c.sendOutputFrame(ctx, data[i:end]); err != nil {
-				return fmt.Errorf("replay write error: %w", err)
+// snapshot sends the pane status and then the terminal emulator's snapshot,
+// so the browser sizes its terminal before drawing. If the pane was resized
+// since the emulator last saw it, the emulator is first re-seeded from tmux.
+func (c *Client) snapshot(ctx context.Context, start time.Time) (int64, error) {
+	status := c.status()
+	if status.Cols > 0 && status.Rows > 0 {
+		if cols, rows := c.vt.Size(); cols != status.Cols || rows != status.Rows {
+			if err := seedTerminal(c.vt, c.ring, c.pty.Target()); err != nil {
+				c.logger.Warn("failed to re-seed terminal state", "error", err)
+				c.vt.Resize(status.Cols, status.Rows)
 			}
 		}
+	}
+	if err := c.sendJSON(ctx, status); err != nil {
+		return 0, fmt.Errorf("status write error: %w", err)
+	}
 
-		c.logger.Info("replay complete", "bytes", len(data), "duration", time.Since(start))
+	buf, offset := c.vt.Snapshot()
+	if err := c.sendOutputFrame(ctx, noOffset, buf); err != nil {
+		return 0, fmt.Errorf("snapshot write error: %w", err)
 	}
+	c.logger.Info("snapshot sent", "bytes", len(buf), "offset", offset, "duration", time.Since(start))
+	return offset, nil
+}
 
-	return nil
+// write sends one message and counts its uncompressed size. Messages of a
+// window connection's pane are wrapped for that pane first.
+func (c *Client) write(ctx context.Context, typ websocket.MessageType, data []byte) error {
+	if c.pane != "" {
+		typ, data = wrapPane(c.pane, typ, data)
+	}
+	c.stats.raw.Add(int64(len(data)))
+	return c.conn.Write(ctx, typ, data)
 }
 
-func (c *Client) sendOutputFrame(ctx context.Context, data []byte) error {
-	msg := OutputMsg{
-		Type: "output",
-		Data: base64.StdEncoding.EncodeToString(data),
+// Stats reports the client's identity and byte counters.
+func (c *Client) Stats() ClientStats {
+	return ClientStats{
+		ID:         c.id,
+		Identity:   c.info.Identity,
+		Role:       c.info.Role,
+		RemoteAddr: c.info.RemoteAddr,
+		Binary:     c.binary,
+		RawBytes:   c.stats.Raw(),
+		WireBytes:  c.stats.Wire(),
+		Throttled:  c.throttled.Load(),
 	}
+}
+
+// sendOutputFrame writes output directly, bypassing the send queue, in the
+// client's negotiated framing. offset is noOffset for snapshots.
+func (c *Client) sendOutputFrame(ctx context.Context, offset int64, data []byte) error {
+	msg := (&outputFrames{offset: offset, data: data}).frame(c.binary)
+	return c.write(ctx, msg.typ, msg.data)
+}
+
+func (c *Client) sendJSON(ctx context.Context, msg any) error {
 	raw, _ := json.Marshal(msg)
-	return c.conn.Write(ctx, websocket.MessageText, raw)
+	return c.write(ctx, websocket.MessageText, raw)
 }
 
 func (c *Client) sendError(ctx context.Context, message string) {
 	msg := ErrorMsg{Type: "error", Message: message}
 	raw, _ := json.Marshal(msg)
-	c.conn.Write(ctx, websocket.MessageText, raw)
+	c.write(ctx, websocket.MessageText, raw)
+}
+
+// queueError enqueues an error message behind any pending output.
+func (c *Client) queueError(message string) {
+	raw, _ := json.Marshal(ErrorMsg{Type: "error", Message: message})
+	select {
+	case c.sendCh <- textFrame(raw):
+	default:
+	}
 }
 
 func (c *Client) sendStatus(ctx context.Context) {
+	raw, _ := json.Marshal(c.status())
+	select {
+	case c.sendCh <- textFrame(raw):
+	default:
+	}
+}
+
+// status describes the pane, including its dimensions so the client can
+// match them.
+func (c *Client) status() StatusMsg {
 	msg := StatusMsg{
 		Type:      "status",
 		PaneState: "connected",
 		Epoch:     c.pty.Epoch(),
+		Role:      string(c.info.Role),
+	}
+	if policy := c.sizer.Policy(); policy != ResizePolicyPane {
+		msg.ResizePolicy = policy
 	}
-	// Include pane dimensions so the client can match them
 	target := c.pty.Target()
 	if target != "" {
 		if cols, rows, err := PaneDimensions(target); err == nil {
@@ -296,9 +570,5 @@ func (c *Client) sendStatus(ctx context.Context) {
 			msg.Rows = rows
 		}
 	}
-	raw, _ := json.Marshal(msg)
-	select {
-	case c.sendCh <- raw:
-	default:
-	}
+	return msg
 }
@@ -2,8 +2,12 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
@@ -14,6 +18,38 @@ type Config struct {
 	MaxUploadSize   int64
 	TailReplaySize  int
 	ClientQueueSize int
+	AuthSecret      string
+	AuthViewSecret  string
+	AuthTokens      []AuthToken
+	SessionTTL      time.Duration
+	AllowedOrigins  []string
+	AllowedRoots    []string
+	DeniedPaths     []string
+	TLSCert         string
+	TLSKey          string
+	TLSSelfSigned   bool
+	TLSDir          string
+	AuditLogPath    string
+	AuditMaxSize    int64
+	AuditMaxFiles   int
+	RingPersistDir  string
+	RingPersistMax  int64
+
+	CheckpointInterval time.Duration
+	CheckpointBytes    int64
+	OutputBatchWindow  time.Duration
+	OutputBatchBytes   int
+
+	WSCompression          string
+	WSCompressionThreshold int
+	SlowClientPolicy       string
+
+	TmuxBackend      string
+	PipePaneConflict string
+	ResizePolicy     string
+
+	SessionTemplatesPath string
+	SessionTemplates     map[string]SessionTemplate
 }
 
 func ParseConfig() (*Config, error) {
@@ -26,6 +62,33 @@ func ParseConfig() (*Config, error) {
 	flag.Int64Var(&cfg.MaxUploadSize, "max-upload-size", 20*1024*1024, "max upload file size in bytes")
 	flag.IntVar(&cfg.TailReplaySize, "tail-replay-size", 256*1024, "tail replay size in bytes for mobile")
 	flag.IntVar(&cfg.ClientQueueSize, "client-queue-size", 256, "max outbound messages per client")
+	flag.StringVar(&cfg.TmuxBackend, "tmux-backend", TmuxBackendControl, "how panes are read and written: control (tmux control mode) or pipe-pane")
+	flag.StringVar(&cfg.PipePaneConflict, "pipe-pane-conflict", PipeConflictRefuse, "pipe-pane backend, when a pane already has a pipe: refuse or tee")
+	flag.StringVar(&cfg.ResizePolicy, "resize-policy", ResizePolicyPane, "whose size a pane takes: pane (left alone), smallest, largest or recent client")
+	flag.StringVar(&cfg.SessionTemplatesPath, "session-templates", "", "JSON file of named session templates for /api/new-session")
+	flag.StringVar(&cfg.SlowClientPolicy, "slow-client-policy", SlowClientFastForward, "what to do when a client's queue fills: fast-forward or disconnect")
+	flag.StringVar(&cfg.AuthSecret, "auth-secret", "", "shared secret required to access c3 (empty disables auth)")
+	flag.StringVar(&cfg.AuthViewSecret, "auth-view-secret", "", "shared secret granting read-only access")
+	authTokens := flag.String("auth-tokens", "", "comma-separated name:sha256hex[:view] API tokens")
+	flag.DurationVar(&cfg.SessionTTL, "session-ttl", 30*24*time.Hour, "lifetime of browser login sessions")
+	allowedOrigins := flag.String("allowed-origins", "", "comma-separated extra origin host patterns (same host is always allowed)")
+	allowedRoots := flag.String("allowed-roots", "", "comma-separated directories the file browser may access (default: $HOME and the upload dir)")
+	deniedPaths := flag.String("denied-paths", ".ssh,.aws,.gnupg,.env,.env.*,.netrc", "comma-separated file name globs the file browser never serves")
+	flag.StringVar(&cfg.TLSCert, "tls-cert", "", "TLS certificate file (enables HTTPS)")
+	flag.StringVar(&cfg.TLSKey, "tls-key", "", "TLS private key file")
+	flag.BoolVar(&cfg.TLSSelfSigned, "tls-self-signed", false, "serve HTTPS with a self-signed certificate generated on first start")
+	flag.StringVar(&cfg.TLSDir, "tls-dir", defaultTLSDir(), "directory where the self-signed certificate is stored")
+	flag.StringVar(&cfg.AuditLogPath, "audit-log", "", "append-only JSON-lines audit log path (empty disables)")
+	flag.Int64Var(&cfg.AuditMaxSize, "audit-max-size", 10*1024*1024, "rotate the audit log when it exceeds this many bytes")
+	flag.IntVar(&cfg.AuditMaxFiles, "audit-max-files", 5, "number of rotated audit log files to keep")
+	flag.StringVar(&cfg.RingPersistDir, "ring-persist-dir", "", "directory for on-disk scrollback (empty keeps it in memory only)")
+	flag.Int64Var(&cfg.RingPersistMax, "ring-persist-max", 64*1024*1024, "on-disk scrollback kept per session in bytes")
+	flag.DurationVar(&cfg.CheckpointInterval, "checkpoint-interval", time.Minute, "record a terminal checkpoint at most this often while output flows (0 disables)")
+	flag.Int64Var(&cfg.CheckpointBytes, "checkpoint-bytes", 1024*1024, "record a terminal checkpoint after this many bytes of output (0 disables)")
+	flag.DurationVar(&cfg.OutputBatchWindow, "output-batch-window", 10*time.Millisecond, "coalesce pane output for up to this long before sending it to clients (0 disables)")
+	flag.IntVar(&cfg.OutputBatchBytes, "output-batch-bytes", 64*1024, "send coalesced output once a batch reaches this many bytes")
+	flag.StringVar(&cfg.WSCompression, "ws-compression", "no-context-takeover", "WebSocket permessage-deflate mode: disabled, context-takeover or no-context-takeover")
+	flag.IntVar(&cfg.WSCompressionThreshold, "ws-compression-threshold", 512, "minimum message size in bytes to compress")
 	flag.Parse()
 
 	// Environment variable overrides
@@ -59,7 +122,184 @@ func ParseConfig() (*Config, error) {
 		}
 	}
 
+	if v := os.Getenv("SLOW_CLIENT_POLICY"); v != "" {
+		cfg.SlowClientPolicy = v
+	}
+	if v := os.Getenv("TMUX_BACKEND"); v != "" {
+		cfg.TmuxBackend = v
+	}
+	if v := os.Getenv("PIPE_PANE_CONFLICT"); v != "" {
+		cfg.PipePaneConflict = v
+	}
+	if v := os.Getenv("RESIZE_POLICY"); v != "" {
+		cfg.ResizePolicy = v
+	}
+	if v := os.Getenv("SESSION_TEMPLATES"); v != "" {
+		cfg.SessionTemplatesPath = v
+	}
+
+	if v := os.Getenv("AUTH_SECRET"); v != "" {
+		cfg.AuthSecret = v
+	}
+	if v := os.Getenv("AUTH_VIEW_SECRET"); v != "" {
+		cfg.AuthViewSecret = v
+	}
+	if v := os.Getenv("AUTH_TOKENS"); v != "" {
+		*authTokens = v
+	}
+	if v := os.Getenv("SESSION_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.SessionTTL = d
+		}
+	}
+
+	if v := os.Getenv("ALLOWED_ORIGINS"); v != "" {
+		*allowedOrigins = v
+	}
+	cfg.AllowedOrigins = splitList(*allowedOrigins)
+	if v := os.Getenv("ALLOWED_ROOTS"); v != "" {
+		*allowedRoots = v
+	}
+	cfg.AllowedRoots = splitList(*allowedRoots)
+	if len(cfg.AllowedRoots) == 0 {
+		if home, err := os.UserHomeDir(); err == nil {
+			cfg.AllowedRoots = append(cfg.AllowedRoots, home)
+		}
+		cfg.AllowedRoots = append(cfg.AllowedRoots, cfg.UploadDir)
+	}
+	for i, root := range cfg.AllowedRoots {
+		if abs, err := filepath.Abs(root); err == nil {
+			cfg.AllowedRoots[i] = abs
+		}
+	}
+	if v, ok := os.LookupEnv("DENIED_PATHS"); ok {
+		*deniedPaths = v
+	}
+	cfg.DeniedPaths = splitList(*deniedPaths)
+
+	for _, spec := range splitList(*authTokens) {
+		tok, err := ParseAuthToken(spec)
+		if err != nil {
+			return nil, err
+		}
+		cfg.AuthTokens = append(cfg.AuthTokens, tok)
+	}
+
+	if v := os.Getenv("TLS_CERT"); v != "" {
+		cfg.TLSCert = v
+	}
+	if v := os.Getenv("TLS_KEY"); v != "" {
+		cfg.TLSKey = v
+	}
+	if v := os.Getenv("TLS_SELF_SIGNED"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.TLSSelfSigned = b
+		}
+	}
+	if v := os.Getenv("TLS_DIR"); v != "" {
+		cfg.TLSDir = v
+	}
+	if v := os.Getenv("AUDIT_LOG"); v != "" {
+		cfg.AuditLogPath = v
+	}
+	if v := os.Getenv("AUDIT_MAX_SIZE"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			cfg.AuditMaxSize = n
+		}
+	}
+	if v := os.Getenv("AUDIT_MAX_FILES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.AuditMaxFiles = n
+		}
+	}
+	if v := os.Getenv("RING_PERSIST_DIR"); v != "" {
+		cfg.RingPersistDir = v
+	}
+	if v := os.Getenv("RING_PERSIST_MAX"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			cfg.RingPersistMax = n
+		}
+	}
+	if v := os.Getenv("CHECKPOINT_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.CheckpointInterval = d
+		}
+	}
+	if v := os.Getenv("CHECKPOINT_BYTES"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			cfg.CheckpointBytes = n
+		}
+	}
+	if v := os.Getenv("OUTPUT_BATCH_WINDOW"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.OutputBatchWindow = d
+		}
+	}
+	if v := os.Getenv("OUTPUT_BATCH_BYTES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.OutputBatchBytes = n
+		}
+	}
+	if v := os.Getenv("WS_COMPRESSION"); v != "" {
+		cfg.WSCompression = v
+	}
+	if v := os.Getenv("WS_COMPRESSION_THRESHOLD"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.WSCompressionThreshold = n
+		}
+	}
+	if _, err := parseCompressionMode(cfg.WSCompression); err != nil {
+		return nil, err
+	}
+	if cfg.SlowClientPolicy != SlowClientFastForward && cfg.SlowClientPolicy != SlowClientDisconnect {
+		return nil, fmt.Errorf("invalid slow client policy %q (want fast-forward or disconnect)", cfg.SlowClientPolicy)
+	}
+	if cfg.TmuxBackend != TmuxBackendControl && cfg.TmuxBackend != TmuxBackendPipePane {
+		return nil, fmt.Errorf("invalid tmux backend %q (want control or pipe-pane)", cfg.TmuxBackend)
+	}
+	if cfg.PipePaneConflict != PipeConflictRefuse && cfg.PipePaneConflict != PipeConflictTee {
+		return nil, fmt.Errorf("invalid pipe-pane conflict policy %q (want refuse or tee)", cfg.PipePaneConflict)
+	}
+	switch cfg.ResizePolicy {
+	case ResizePolicyPane, ResizePolicySmallest, ResizePolicyLargest, ResizePolicyRecent:
+	default:
+		return nil, fmt.Errorf("invalid resize policy %q (want pane, smallest, largest or recent)", cfg.ResizePolicy)
+	}
+	if cfg.SessionTemplatesPath != "" {
+		templates, err := LoadSessionTemplates(cfg.SessionTemplatesPath)
+		if err != nil {
+			return nil, err
+		}
+		cfg.SessionTemplates = templates
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return nil, fmt.Errorf("--tls-cert and --tls-key must be set together")
+	}
+	if cfg.TLSCert != "" && cfg.TLSSelfSigned {
+		return nil, fmt.Errorf("--tls-self-signed cannot be combined with --tls-cert")
+	}
+
 	// TmuxTarget is optional — if empty, the session picker UI will be shown.
 
 	return cfg, nil
 }
+
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// defaultTLSDir returns ~/.config/c3 (or the platform equivalent).
+func defaultTLSDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "."
+	}
+	return filepath.Join(dir, "c3")
+}
@@ -0,0 +1,563 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Pane I/O backends, selected with --tmux-backend.
+const (
+	// TmuxBackendControl reads %output notifications and sends input over a
+	// tmux control-mode connection shared by every pane of a tmux session.
+	TmuxBackendControl = "control"
+	// TmuxBackendPipePane reads through pipe-pane and a FIFO and sends input
+	// with one send-keys process per write.
+	TmuxBackendPipePane = "pipe-pane"
+)
+
+// errControlClosed is returned for commands on a connection that has ended.
+var errControlClosed = errors.New("tmux control connection closed")
+
+// ControlConn is a tmux control-mode client (`tmux -C`) attached to one tmux
+// session. tmux only reports output for the windows of the session a control
+// client is attached to, so there is one connection per session rather than
+// per server; it carries the output of every pane in that session and runs
+// commands, including input, without forking.
+type ControlConn struct {
+	session  string
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	logger   *slog.Logger
+	notify   func(ControlEvent)
+	attached chan struct{}
+	done     chan struct{}
+
+	mu      sync.Mutex
+	pending []chan controlReply     // commands awaiting their %end, in order
+	panes   map[string]func([]byte) // output handlers by pane ID
+	refs    int
+	closed  bool
+}
+
+// ControlEvent is a control-mode notification other than %output, such as
+// %layout-change or %subscription-changed, received on a connection attached
+// to Session. Name omits the leading %.
+type ControlEvent struct {
+	Session string
+	Name    string
+	Args    string
+}
+
+type controlReply struct {
+	lines []string
+	err   error
+}
+
+// NewControlConn attaches a control client to session. The client does not
+// count towards window sizes. Notifications are passed to notify, if set, on
+// the connection's read goroutine.
+func NewControlConn(session string, notify func(ControlEvent), logger *slog.Logger) (*ControlConn, error) {
+	cmd := exec.Command("tmux", "-C", "attach-session", "-t", "="+session, "-f", "ignore-size")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("tmux -C: %w", err)
+	}
+	c := &ControlConn{
+		session:  session,
+		cmd:      cmd,
+		stdin:    stdin,
+		logger:   logger.With("tmux_session", session),
+		notify:   notify,
+		attached: make(chan struct{}),
+		done:     make(chan struct{}),
+		panes:    make(map[string]func([]byte)),
+	}
+	go c.readLoop(stdout)
+
+	select {
+	case <-c.attached:
+	case <-c.done:
+		return nil, fmt.Errorf("tmux control attach to %q failed", session)
+	case <-time.After(10 * time.Second):
+		c.Close()
+		return nil, fmt.Errorf("tmux control attach to %q: timed out", session)
+	}
+
+	// tmux does not notify when a pane is respawned on a new PTY, so
+	// subscribe to the PTYs and sizes of the session's panes; changes arrive
+	// as %subscription-changed within a second.
+	if _, err := c.Command("refresh-client", "-B", "c3-panes:%*:#{pane_tty} #{pane_width}x#{pane_height}"); err != nil {
+		c.logger.Warn("tmux pane subscription failed", "error", err)
+	}
+	c.logger.Info("tmux control connection opened")
+	return c, nil
+}
+
+// Done is closed when the connection has ended.
+func (c *ControlConn) Done() <-chan struct{} {
+	return c.done
+}
+
+// Command runs a tmux command over the connection and returns its output.
+func (c *ControlConn) Command(args ...string) ([]string, error) {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = controlQuote(a)
+	}
+	reply := make(chan controlReply, 1)
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, errControlClosed
+	}
+	// Queue and write under the lock so replies arrive in pending order.
+	c.pending = append(c.pending, reply)
+	_, err := io.WriteString(c.stdin, strings.Join(quoted, " ")+"\n")
+	c.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("tmux control write: %w", err)
+	}
+
+	select {
+	case r := <-reply:
+		return r.lines, r.err
+	case <-c.done:
+		return nil, errControlClosed
+	case <-time.After(10 * time.Second):
+		return nil, fmt.Errorf("tmux %s: timed out", args[0])
+	}
+}
+
+// Subscribe delivers the output of pane paneID (e.g. "%3") to fn, on the
+// connection's read goroutine.
+func (c *ControlConn) Subscribe(paneID string, fn func(data []byte)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.panes[paneID] = fn
+}
+
+// Unsubscribe stops delivering a pane's output.
+func (c *ControlConn) Unsubscribe(paneID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.panes, paneID)
+}
+
+// Close detaches the control client.
+func (c *ControlConn) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		c.stdin.Close() // tmux detaches the client at EOF
+	}
+}
+
+func (c *ControlConn) readLoop(stdout io.Reader) {
+	r := bufio.NewReaderSize(stdout, 64*1024)
+	var block []string // output of the command being replied to
+	var guard string   // "<time> <number> <flags>" of its %begin
+	inBlock, attached := false, false
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			break
+		}
+		line = strings.TrimSuffix(line, "\n")
+
+		if inBlock {
+			if end, ok := strings.CutPrefix(line, "%end "); ok && end == guard {
+				c.reply(guard, controlReply{lines: block})
+				inBlock = false
+			} else if end, ok := strings.CutPrefix(line, "%error "); ok && end == guard {
+				c.reply(guard, controlReply{lines: block, err: fmt.Errorf("tmux: %s", strings.Join(block, "; "))})
+				inBlock = false
+			} else {
+				block = append(block, line)
+			}
+			continue
+		}
+
+		name, rest, _ := strings.Cut(line, " ")
+		switch name {
+		case "%begin":
+			inBlock, guard, block = true, rest, nil
+		case "%output":
+			paneID, data, _ := strings.Cut(rest, " ")
+			c.mu.Lock()
+			fn := c.panes[paneID]
+			c.mu.Unlock()
+			if fn != nil {
+				fn(controlUnescape(data))
+			}
+		case "%session-changed":
+			_, sess, _ := strings.Cut(rest, " ")
+			if !attached && sess == c.session {
+				attached = true
+				close(c.attached)
+			} else if sess != c.session {
+				// The session was destroyed and tmux moved the client
+				// on; panes of a recreated session would not be seen.
+				c.logger.Warn("tmux control client left its session", "now", sess)
+				c.Close()
+			}
+		case "%exit":
+			c.Close()
+		}
+		if name != "%begin" && name != "%output" && c.notify != nil {
+			c.notify(ControlEvent{Session: c.session, Name: strings.TrimPrefix(name, "%"), Args: rest})
+		}
+	}
+
+	c.mu.Lock()
+	c.closed = true
+	pending := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+	for _, p := range pending {
+		p <- controlReply{err: errControlClosed}
+	}
+	c.cmd.Wait()
+	close(c.done)
+	c.logger.Info("tmux control connection closed")
+}
+
+// reply completes the oldest pending command. Blocks for commands tmux ran
+// on its own, such as the initial attach, have flags 0 and are ignored.
+func (c *ControlConn) reply(guard string, r controlReply) {
+	if !strings.HasSuffix(guard, " 1") {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.pending) == 0 {
+		return
+	}
+	c.pending[0] <- r
+	c.pending = c.pending[1:]
+}
+
+// controlQuote quotes an argument for the tmux command parser.
+func controlQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-.,:/%=@+") == "" {
+		return s
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; {
+		case ch == '"' || ch == '\\' || ch == '$':
+			b.WriteByte('\\')
+			b.WriteByte(ch)
+		case ch < ' ' || ch == 0x7f:
+			fmt.Fprintf(&b, "\\%03o", ch)
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// controlUnescape decodes %output data, in which tmux writes control
+// characters and backslashes as three-digit octal escapes.
+func controlUnescape(s string) []byte {
+	out := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && isOctal(s[i+1]) && isOctal(s[i+2]) && isOctal(s[i+3]) {
+			out = append(out, (s[i+1]-'0')<<6|(s[i+2]-'0')<<3|(s[i+3]-'0'))
+			i += 3
+			continue
+		}
+		out = append(out, s[i])
+	}
+	return out
+}
+
+func isOctal(ch byte) bool {
+	return ch >= '0' && ch <= '7'
+}
+
+// ControlMode hands out control connections, one per tmux session, shared by
+// the panes streamed from it and closed when the last one is released.
+// Notifications from all of them are passed to the registered listeners.
+type ControlMode struct {
+	mu     sync.Mutex
+	conns  map[string]*ControlConn
+	logger *slog.Logger
+
+	listenMu   sync.Mutex
+	listeners  map[int]func(ControlEvent)
+	nextListen int
+}
+
+func NewControlMode(logger *slog.Logger) *ControlMode {
+	return &ControlMode{
+		conns:     make(map[string]*ControlConn),
+		logger:    logger,
+		listeners: make(map[int]func(ControlEvent)),
+	}
+}
+
+// Listen registers fn for the notifications of every connection and returns
+// a function that unregisters it. fn must not block.
+func (m *ControlMode) Listen(fn func(ControlEvent)) func() {
+	m.listenMu.Lock()
+	defer m.listenMu.Unlock()
+	id := m.nextListen
+	m.nextListen++
+	m.listeners[id] = fn
+	return func() {
+		m.listenMu.Lock()
+		defer m.listenMu.Unlock()
+		delete(m.listeners, id)
+	}
+}
+
+func (m *ControlMode) dispatch(ev ControlEvent) {
+	m.listenMu.Lock()
+	fns := make([]func(ControlEvent), 0, len(m.listeners))
+	for _, fn := range m.listeners {
+		fns = append(fns, fn)
+	}
+	m.listenMu.Unlock()
+	for _, fn := range fns {
+		fn(ev)
+	}
+}
+
+// Acquire returns the connection attached to session, opening one if needed.
+func (m *ControlMode) Acquire(session string) (*ControlConn, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.conns[session]
+	if c != nil {
+		select {
+		case <-c.Done():
+			c = nil // ended; replace it
+		default:
+		}
+	}
+	if c == nil {
+		var err error
+		if c, err = NewControlConn(session, m.dispatch, m.logger); err != nil {
+			return nil, err
+		}
+		m.conns[session] = c
+	}
+	c.mu.Lock()
+	c.refs++
+	c.mu.Unlock()
+	return c, nil
+}
+
+// Release drops a reference taken by Acquire.
+func (m *ControlMode) Release(c *ControlConn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c.mu.Lock()
+	c.refs--
+	last := c.refs == 0
+	c.mu.Unlock()
+	if last {
+		c.Close()
+		if m.conns[c.session] == c {
+			delete(m.conns, c.session)
+		}
+	}
+}
+
+// ControlPane streams one pane's output through a shared control connection
+// and sends it input with send-keys over the same connection.
+type ControlPane struct {
+	mode    *ControlMode
+	ring    *RingBuffer
+	writeCh chan []byte
+	logger  *slog.Logger
+
+	// onOutput is called with each chunk of pane output and the ring offset
+	// it was written at. Set before calling Reattach.
+	onOutput func(offset int64, data []byte)
+
+	mu         sync.Mutex
+	tmuxTarget string
+	conn       *ControlConn
+	paneID     string
+	epoch      int64
+	stopCh     chan struct{}
+}
+
+func NewControlPane(mode *ControlMode, tmuxTarget string, ring *RingBuffer, logger *slog.Logger) *ControlPane {
+	return &ControlPane{
+		mode:       mode,
+		tmuxTarget: tmuxTarget,
+		epoch:      epochBase,
+		ring:       ring,
+		writeCh:    make(chan []byte, 64),
+		logger:     logger,
+	}
+}
+
+// Target returns the tmux target.
+func (p *ControlPane) Target() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.tmuxTarget
+}
+
+// Epoch returns the current session epoch.
+func (p *ControlPane) Epoch() int64 {
+	return atomic.LoadInt64(&p.epoch)
+}
+
+// Open resolves the target to its pane and session and subscribes to the
+// pane's output. The PTY path is not needed: tmux identifies the pane.
+func (p *ControlPane) Open(ttyPath string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.openLocked(ttyPath)
+}
+
+func (p *ControlPane) openLocked(ttyPath string) error {
+	out, err := exec.Command("tmux", "display-message", "-p", "-t", p.tmuxTarget, "#{pane_id}\t#{session_name}").Output()
+	if err != nil {
+		return fmt.Errorf("tmux query failed: %w", err)
+	}
+	paneID, session, ok := strings.Cut(strings.TrimSpace(string(out)), "\t")
+	if !ok || !strings.HasPrefix(paneID, "%") {
+		return fmt.Errorf("unexpected pane for target %q: %q", p.tmuxTarget, out)
+	}
+	conn, err := p.mode.Acquire(session)
+	if err != nil {
+		return err
+	}
+	p.conn, p.paneID = conn, paneID
+
+	atomic.AddInt64(&p.epoch, 1)
+	p.stopCh = make(chan struct{})
+	p.logger.Info("pane attached over control mode", "pane_id", paneID, "tty", ttyPath, "epoch", p.Epoch())
+
+	conn.Subscribe(paneID, p.output)
+	go p.writeLoop(conn, paneID, p.stopCh)
+	go p.watch(conn, p.stopCh)
+	return nil
+}
+
+// Close unsubscribes from the pane and releases the connection.
+func (p *ControlPane) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closeLocked()
+}
+
+func (p *ControlPane) closeLocked() {
+	if p.stopCh != nil {
+		close(p.stopCh)
+		p.stopCh = nil
+	}
+	if p.conn != nil {
+		p.conn.Unsubscribe(p.paneID)
+		p.mode.Release(p.conn)
+		p.conn, p.paneID = nil, ""
+	}
+}
+
+// Reattach closes the existing subscription and opens a new one.
+func (p *ControlPane) Reattach(newTTYPath string) error {
+	p.Close()
+	return p.Open(newTTYPath)
+}
+
+// WriteInput sends raw bytes to the pane as keystrokes.
+func (p *ControlPane) WriteInput(data []byte) {
+	select {
+	case p.writeCh <- data:
+	default:
+		p.logger.Warn("pane write channel full, dropping input")
+	}
+}
+
+func (p *ControlPane) output(data []byte) {
+	offset := p.ring.Write(data)
+	if p.onOutput != nil {
+		p.onOutput(offset, data)
+	}
+}
+
+// controlInputChunk bounds the bytes sent by one send-keys command.
+const controlInputChunk = 512
+
+func (p *ControlPane) writeLoop(conn *ControlConn, paneID string, stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case data := <-p.writeCh:
+			// send-keys -H writes each byte to the pane as is, so any
+			// input, including partial UTF-8 and escape sequences, survives.
+			for len(data) > 0 {
+				n := min(len(data), controlInputChunk)
+				args := []string{"send-keys", "-H", "-t", paneID}
+				for _, b := range data[:n] {
+					args = append(args, hex.EncodeToString([]byte{b}))
+				}
+				data = data[n:]
+				if _, err := conn.Command(args...); err != nil {
+					select {
+					case <-stop:
+						return
+					default:
+						p.logger.Error("tmux send-keys error", "error", err)
+					}
+				}
+			}
+		}
+	}
+}
+
+// watch reattaches when the control connection ends while the pane is still
+// wanted, e.g. after the client was detached from outside. Output produced
+// in between is lost.
+func (p *ControlPane) watch(conn *ControlConn, stop chan struct{}) {
+	select {
+	case <-stop:
+		return
+	case <-conn.Done():
+	}
+	select {
+	case <-stop:
+		return
+	case <-time.After(time.Second):
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopCh != stop {
+		return // closed or reattached meanwhile
+	}
+	p.logger.Warn("tmux control connection lost, reattaching")
+	p.closeLocked()
+	if err := p.openLocked(""); err != nil {
+		p.logger.Error("failed to reattach over control mode", "error", err)
+	}
+}
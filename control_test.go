@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestControlQuote(t *testing.T) {
+	cases := map[string]string{
+		"send-keys":       "send-keys",
+		"%3":              "%3",
+		"claude:0.1":      "claude:0.1",
+		"":                `""`,
+		"two words":       `"two words"`,
+		`a"b\c$HOME`:      `"a\"b\\c\$HOME"`,
+		"line\nnext\x1b;": `"line\012next\033;"`,
+		"#{pane_id}":      `"#{pane_id}"`,
+	}
+	for in, want := range cases {
+		if got := controlQuote(in); got != want {
+			t.Errorf("controlQuote(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestControlUnescape(t *testing.T) {
+	got := controlUnescape(`\033[1mbold\033[m \134n\015\012é\12`)
+	want := "\x1b[1mbold\x1b[m \\n\r\né\\12"
+	if string(got) != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// How often the event watcher re-lists tmux while anyone is subscribed:
+// eventPoll while some session has no control connection to report its
+// changes, and eventFallbackPoll otherwise, for changes the connections miss.
+const (
+	eventPoll         = 2 * time.Second
+	eventFallbackPoll = 30 * time.Second
+)
+
+// eventSubscription is the control-mode subscription the event watcher adds
+// to each served session's connection. tmux sends no notification when a pane's
+// @claude-state option or working directory changes; subscribed, they
+// arrive as %subscription-changed within a second.
+const (
+	eventSubscriptionName = "c3-events"
+	eventSubscription     = eventSubscriptionName + ":%*:#{@claude-state} #{pane_current_path}"
+)
+
+// eventQueueSize is how many events a subscriber may fall behind by before
+// it is dropped. It reconnects and starts over from a snapshot.
+const eventQueueSize = 256
+
+// EventSession is a tmux session in the events stream.
+type EventSession struct {
+	ID   string `json:"id"` // "$N", kept across renames
+	Name string `json:"name"`
+}
+
+// EventWindow is a tmux window in the events stream.
+type EventWindow struct {
+	ID      string `json:"id"`      // "@N", kept across renames and moves
+	Session string `json:"session"` // session ID
+	Index   string `json:"index"`
+	Name    string `json:"name"`
+}
+
+// EventPane is a tmux pane in the events stream.
+type EventPane struct {
+	ID          string `json:"id"`     // "%N"
+	Window      string `json:"window"` // window ID
+	Index       string `json:"index"`
+	Target      string `json:"target"` // "session:window.pane"
+	CurrentCmd  string `json:"currentCommand"`
+	ClaudeState string `json:"claudeState"`
+	CurrentPath string `json:"currentPath"`
+}
+
+// EventMsg is a message of the /api/events stream. The first is a snapshot
+// of every session, window and pane. Each one after it reports one of them
+// being created, changed or killed, with Changed naming the fields that
+// differ from the record last sent.
+type EventMsg struct {
+	Type     string         `json:"type"` // "snapshot", or "session", "window" or "pane" + "-created", "-changed" or "-killed"
+	Sessions []EventSession `json:"sessions,omitempty"`
+	Windows  []EventWindow  `json:"windows,omitempty"`
+	Panes    []EventPane    `json:"panes,omitempty"`
+	Session  *EventSession  `json:"session,omitempty"`
+	Window   *EventWindow   `json:"window,omitempty"`
+	Pane     *EventPane     `json:"pane,omitempty"`
+	Changed  []string       `json:"changed,omitempty"`
+}
+
+// tmuxState is the server's sessions, windows and panes by ID, with the
+// order tmux lists them in.
+type tmuxState struct {
+	sessions map[string]EventSession
+	windows  map[string]EventWindow
+	panes    map[string]EventPane
+	order    struct{ sessions, windows, panes []string }
+}
+
+func newTmuxState(records []PaneRecord) *tmuxState {
+	s := &tmuxState{
+		sessions: make(map[string]EventSession),
+		windows:  make(map[string]EventWindow),
+		panes:    make(map[string]EventPane),
+	}
+	for _, r := range records {
+		if _, ok := s.sessions[r.SessionID]; !ok {
+			s.sessions[r.SessionID] = EventSession{ID: r.SessionID, Name: r.SessionName}
+			s.order.sessions = append(s.order.sessions, r.SessionID)
+		}
+		if _, ok := s.windows[r.WindowID]; !ok {
+			s.windows[r.WindowID] = EventWindow{ID: r.WindowID, Session: r.SessionID, Index: r.WindowIndex, Name: r.WindowName}
+			s.order.windows = append(s.order.windows, r.WindowID)
+		}
+		// A window linked into several sessions is listed once per session;
+		// its panes are reported under the first.
+		if _, ok := s.panes[r.PaneID]; !ok {
+			s.panes[r.PaneID] = EventPane{
+				ID:          r.PaneID,
+				Window:      r.WindowID,
+				Index:       r.PaneIndex,
+				Target:      r.SessionName + ":" + r.WindowIndex + "." + r.PaneIndex,
+				CurrentCmd:  r.CurrentCmd,
+				ClaudeState: r.ClaudeState,
+				CurrentPath: r.CurrentPath,
+			}
+			s.order.panes = append(s.order.panes, r.PaneID)
+		}
+	}
+	return s
+}
+
+func (s *tmuxState) sessionNames() []string {
+	names := make([]string, len(s.order.sessions))
+	for i, id := range s.order.sessions {
+		names[i] = s.sessions[id].Name
+	}
+	return names
+}
+
+func (s *tmuxState) snapshot() EventMsg {
+	msg := EventMsg{Type: "snapshot"}
+	for _, id := range s.order.sessions {
+		msg.Sessions = append(msg.Sessions, s.sessions[id])
+	}
+	for _, id := range s.order.windows {
+		msg.Windows = append(msg.Windows, s.windows[id])
+	}
+	for _, id := range s.order.panes {
+		msg.Panes = append(msg.Panes, s.panes[id])
+	}
+	return msg
+}
+
+// diffState returns the events that turn old into next. Sessions are
+// reported before their windows and windows before their panes, except when
+// killed, which goes the other way round.
+func diffState(old, next *tmuxState) []EventMsg {
+	var events []EventMsg
+	for _, id := range next.order.sessions {
+		n := next.sessions[id]
+		if o, ok := old.sessions[id]; !ok {
+			events = append(events, EventMsg{Type: "session-created", Session: &n})
+		} else if o.Name != n.Name {
+			events = append(events, EventMsg{Type: "session-changed", Session: &n, Changed: []string{"name"}})
+		}
+	}
+	for _, id := range next.order.windows {
+		n := next.windows[id]
+		o, ok := old.windows[id]
+		if !ok {
+			events = append(events, EventMsg{Type: "window-created", Window: &n})
+			continue
+		}
+		var changed []string
+		if o.Session != n.Session {
+			changed = append(changed, "session")
+		}
+		if o.Index != n.Index {
+			changed = append(changed, "index")
+		}
+		if o.Name != n.Name {
+			changed = append(changed, "name")
+		}
+		if changed != nil {
+			events = append(events, EventMsg{Type: "window-changed", Window: &n, Changed: changed})
+		}
+	}
+	for _, id := range next.order.panes {
+		n := next.panes[id]
+		o, ok := old.panes[id]
+		if !ok {
+			events = append(events, EventMsg{Type: "pane-created", Pane: &n})
+			continue
+		}
+		var changed []string
+		if o.Window != n.Window {
+			changed = append(changed, "window")
+		}
+		if o.Index != n.Index {
+			changed = append(changed, "index")
+		}
+		if o.Target != n.Target {
+			changed = append(changed, "target")
+		}
+		if o.CurrentCmd != n.CurrentCmd {
+			changed = append(changed, "currentCommand")
+		}
+		if o.ClaudeState != n.ClaudeState {
+			changed = append(changed, "claudeState")
+		}
+		if o.CurrentPath != n.CurrentPath {
+			changed = append(changed, "currentPath")
+		}
+		if changed != nil {
+			events = append(events, EventMsg{Type: "pane-changed", Pane: &n, Changed: changed})
+		}
+	}
+	for _, id := range old.order.panes {
+		if _, ok := next.panes[id]; !ok {
+			o := old.panes[id]
+			events = append(events, EventMsg{Type: "pane-killed", Pane: &o})
+		}
+	}
+	for _, id := range old.order.windows {
+		if _, ok := next.windows[id]; !ok {
+			o := old.windows[id]
+			events = append(events, EventMsg{Type: "window-killed", Window: &o})
+		}
+	}
+	for _, id := range old.order.sessions {
+		if _, ok := next.sessions[id]; !ok {
+			o := old.sessions[id]
+			events = append(events, EventMsg{Type: "session-killed", Session: &o})
+		}
+	}
+	return events
+}
+
+// EventWatcher follows the tmux server for every /api/events client. While
+// anyone is subscribed it lists tmux as soon as a control connection reports
+// a change, and otherwise polls, and sends each subscriber the differences
+// from the previous listing. It only uses the connections of sessions c3 is
+// serving, which are attached anyway: attaching a control client to any
+// other session would show up in its #{session_attached}, list-clients and
+// client-attached hooks. Other sessions are followed by polling every
+// interval. With nobody subscribed it stops and lets the connections go.
+type EventWatcher struct {
+	control  *ControlMode
+	served   func() []string // names of the tmux sessions c3 is serving
+	interval time.Duration
+	logger   *slog.Logger
+	kick     chan struct{}
+
+	mu    sync.Mutex
+	subs  map[chan []byte]struct{}
+	state *tmuxState         // last listing; nil while nobody is subscribed
+	stop  context.CancelFunc // ends the watch loop
+}
+
+func NewEventWatcher(control *ControlMode, served func() []string, interval time.Duration, logger *slog.Logger) *EventWatcher {
+	return &EventWatcher{
+		control:  control,
+		served:   served,
+		interval: interval,
+		logger:   logger,
+		kick:     make(chan struct{}, 1),
+		subs:     make(map[chan []byte]struct{}),
+	}
+}
+
+// Subscribe returns a snapshot of tmux and a channel of the events that
+// follow it, both encoded as JSON. The channel is closed if the subscriber
+// falls eventQueueSize events behind. cancel unsubscribes.
+func (e *EventWatcher) Subscribe() (snapshot []byte, events <-chan []byte, cancel func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.scanLocked()
+	ch := make(chan []byte, eventQueueSize)
+	e.subs[ch] = struct{}{}
+	if e.stop == nil {
+		ctx, stop := context.WithCancel(context.Background())
+		e.stop = stop
+		go e.run(ctx)
+	}
+	snapshot, _ = json.Marshal(e.state.snapshot())
+	return snapshot, ch, func() { e.unsubscribe(ch) }
+}
+
+func (e *EventWatcher) unsubscribe(ch chan []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.subs, ch)
+	if len(e.subs) == 0 && e.stop != nil {
+		e.stop()
+		e.stop, e.state = nil, nil
+	}
+}
+
+func (e *EventWatcher) run(ctx context.Context) {
+	defer e.control.Listen(e.onEvent)()
+	conns := make(map[string]*ControlConn) // by session name
+	defer func() {
+		for name := range conns {
+			e.detach(conns, name)
+		}
+	}()
+	timer := time.NewTimer(e.interval)
+	defer timer.Stop()
+	for {
+		e.mu.Lock()
+		var names []string
+		if e.state != nil {
+			names = e.state.sessionNames()
+		}
+		e.mu.Unlock()
+		served := make(map[string]bool)
+		for _, name := range e.served() {
+			served[name] = true
+		}
+		var attach []string
+		for _, name := range names {
+			if served[name] {
+				attach = append(attach, name)
+			}
+		}
+		e.attach(conns, attach)
+
+		wait := eventFallbackPoll
+		if len(conns) < len(names) {
+			wait = e.interval
+		}
+		timer.Reset(wait)
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.kick:
+		case <-timer.C:
+		}
+		e.mu.Lock()
+		if ctx.Err() == nil {
+			e.scanLocked()
+		}
+		e.mu.Unlock()
+	}
+}
+
+// attach keeps a control connection with the event subscription to each
+// session in names and lets go of the rest. A session that cannot be
+// attached to is tried again after the next listing.
+func (e *EventWatcher) attach(conns map[string]*ControlConn, names []string) {
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+	for name, c := range conns {
+		select {
+		case <-c.Done():
+		default:
+			if wanted[name] {
+				continue
+			}
+		}
+		e.detach(conns, name)
+	}
+	for _, name := range names {
+		if conns[name] != nil {
+			continue
+		}
+		c, err := e.control.Acquire(name)
+		if err != nil {
+			e.logger.Warn("failed to attach to tmux session for events", "tmux_session", name, "error", err)
+			continue
+		}
+		if _, err := c.Command("refresh-client", "-B", eventSubscription); err != nil {
+			e.logger.Warn("tmux events subscription failed", "tmux_session", name, "error", err)
+		}
+		conns[name] = c
+	}
+}
+
+// detach removes the event subscription from a session's connection, which
+// panes may still be streaming over, and releases it.
+func (e *EventWatcher) detach(conns map[string]*ControlConn, name string) {
+	c := conns[name]
+	delete(conns, name)
+	c.Command("refresh-client", "-B", eventSubscriptionName)
+	e.control.Release(c)
+}
+
+// onEvent re-lists tmux on notifications of sessions or windows being
+// created, killed, renamed or rearranged, and of the event subscription
+// changing.
+func (e *EventWatcher) onEvent(ev ControlEvent) {
+	switch ev.Name {
+	case "sessions-changed", "session-renamed", "session-window-changed",
+		"window-add", "window-close", "window-renamed", "layout-change", "window-pane-changed",
+		"unlinked-window-add", "unlinked-window-close", "unlinked-window-renamed":
+	case "subscription-changed":
+		if name, _, _ := strings.Cut(ev.Args, " "); name != eventSubscriptionName {
+			return
+		}
+	default:
+		return
+	}
+	select {
+	case e.kick <- struct{}{}:
+	default:
+	}
+}
+
+// scanLocked lists tmux and sends subscribers what changed. A failed listing
+// is skipped rather than reported as everything being killed.
+func (e *EventWatcher) scanLocked() {
+	records, err := ListAllPanes()
+	if err != nil {
+		e.logger.Warn("failed to list tmux panes for events", "error", err)
+		if e.state == nil {
+			e.state = newTmuxState(nil)
+		}
+		return
+	}
+	next := newTmuxState(records)
+	if e.state != nil {
+		for _, ev := range diffState(e.state, next) {
+			raw, _ := json.Marshal(ev)
+			for ch := range e.subs {
+				select {
+				case ch <- raw:
+				default:
+					e.logger.Warn("events subscriber fell behind, dropping it")
+					close(ch)
+					delete(e.subs, ch)
+				}
+			}
+		}
+	}
+	e.state = next
+}
+
+// Serve streams the events to a WebSocket client until it disconnects.
+// Anything the client sends is ignored.
+func (e *EventWatcher) Serve(ctx context.Context, conn *websocket.Conn, stats *ConnStats) {
+	defer conn.CloseNow()
+	ctx = conn.CloseRead(ctx)
+
+	snapshot, events, cancel := e.Subscribe()
+	defer cancel()
+	write := func(raw []byte) error {
+		stats.raw.Add(int64(len(raw)))
+		return conn.Write(ctx, websocket.MessageText, raw)
+	}
+	if err := write(snapshot); err != nil {
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case raw, ok := <-events:
+			if !ok {
+				conn.Close(websocket.StatusTryAgainLater, "fell behind")
+				return
+			}
+			if err := write(raw); err != nil {
+				return
+			}
+		}
+	}
+}
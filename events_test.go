@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffState(t *testing.T) {
+	old := newTmuxState([]PaneRecord{
+		{SessionID: "$1", SessionName: "main", WindowID: "@1", WindowIndex: "0", WindowName: "claude", PaneID: "%1", PaneIndex: "0", CurrentCmd: "claude", ClaudeState: "active", CurrentPath: "/src"},
+		{SessionID: "$1", SessionName: "main", WindowID: "@1", WindowIndex: "0", WindowName: "claude", PaneID: "%2", PaneIndex: "1", CurrentCmd: "zsh"},
+		{SessionID: "$2", SessionName: "old", WindowID: "@2", WindowIndex: "0", WindowName: "zsh", PaneID: "%3", PaneIndex: "0", CurrentCmd: "zsh"},
+	})
+	next := newTmuxState([]PaneRecord{
+		{SessionID: "$1", SessionName: "work", WindowID: "@1", WindowIndex: "0", WindowName: "claude", PaneID: "%1", PaneIndex: "0", CurrentCmd: "claude", ClaudeState: "waiting", CurrentPath: "/src"},
+		{SessionID: "$1", SessionName: "work", WindowID: "@4", WindowIndex: "1", WindowName: "tests", PaneID: "%5", PaneIndex: "0", CurrentCmd: "npm"},
+	})
+
+	var got []string
+	for _, ev := range diffState(old, next) {
+		id := ""
+		switch {
+		case ev.Session != nil:
+			id = ev.Session.ID
+		case ev.Window != nil:
+			id = ev.Window.ID
+		case ev.Pane != nil:
+			id = ev.Pane.ID
+		}
+		got = append(got, ev.Type+" "+id+" "+strings.Join(ev.Changed, ","))
+	}
+	want := []string{
+		"session-changed $1 name",
+		"window-created @4 ",
+		"pane-changed %1 target,claudeState",
+		"pane-created %5 ",
+		"pane-killed %2 ",
+		"pane-killed %3 ",
+		"window-killed @2 ",
+		"session-killed $2 ",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("events:\n%v\nwant:\n%v", got, want)
+	}
+
+	if events := diffState(next, next); len(events) != 0 {
+		t.Fatalf("unchanged state produced %v", events)
+	}
+}
+
+func TestTmuxStateSnapshot(t *testing.T) {
+	s := newTmuxState([]PaneRecord{
+		{SessionID: "$1", SessionName: "main", WindowID: "@1", WindowIndex: "2", WindowName: "claude", PaneID: "%7", PaneIndex: "3"},
+	})
+	snap := s.snapshot()
+	want := EventMsg{
+		Type:     "snapshot",
+		Sessions: []EventSession{{ID: "$1", Name: "main"}},
+		Windows:  []EventWindow{{ID: "@1", Session: "$1", Index: "2", Name: "claude"}},
+		Panes:    []EventPane{{ID: "%7", Window: "@1", Index: "3", Target: "main:2.3"}},
+	}
+	if !reflect.DeepEqual(snap, want) {
+		t.Fatalf("got %+v, want %+v", snap, want)
+	}
+	if names := s.sessionNames(); !reflect.DeepEqual(names, []string{"main"}) {
+		t.Fatalf("session names %q", names)
+	}
+}
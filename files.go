@@ -6,7 +6,6 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
-	"path/filepath"
 	"sort"
 	"strings"
 )
@@ -17,17 +16,16 @@ type FileEntry struct {
 	Size  int64  `json:"size"`
 }
 
-func NewFilesHandler(logger *slog.Logger) http.HandlerFunc {
+func NewFilesHandler(sandbox *FileSandbox, logger *slog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		reqPath := r.URL.Query().Get("path")
 		if reqPath == "" {
 			reqPath = os.Getenv("HOME")
 		}
 
-		// Resolve to absolute and clean
-		absPath, err := filepath.Abs(reqPath)
-		if err != nil {
-			http.Error(w, "invalid path", http.StatusBadRequest)
+		// Resolve symlinks and enforce the sandbox roots
+		absPath, ok := sandbox.resolveRequest(w, r, reqPath)
+		if !ok {
 			return
 		}
 
@@ -50,8 +48,8 @@ func NewFilesHandler(logger *slog.Logger) http.HandlerFunc {
 
 		var files []FileEntry
 		for _, e := range entries {
-			// Skip hidden files
-			if strings.HasPrefix(e.Name(), ".") {
+			// Skip hidden files and anything on the deny-list
+			if strings.HasPrefix(e.Name(), ".") || sandbox.Denied(e.Name()) {
 				continue
 			}
 			info, err := e.Info()
@@ -81,7 +79,7 @@ func NewFilesHandler(logger *slog.Logger) http.HandlerFunc {
 	}
 }
 
-func NewFileContentHandler(logger *slog.Logger) http.HandlerFunc {
+func NewFileContentHandler(sandbox *FileSandbox, logger *slog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		reqPath := r.URL.Query().Get("path")
 		if reqPath == "" {
@@ -89,9 +87,8 @@ func NewFileContentHandler(logger *slog.Logger) http.HandlerFunc {
 			return
 		}
 
-		absPath, err := filepath.Abs(reqPath)
-		if err != nil {
-			http.Error(w, "invalid path", http.StatusBadRequest)
+		absPath, ok := sandbox.resolveRequest(w, r, reqPath)
+		if !ok {
 			return
 		}
 
@@ -109,7 +106,7 @@ func NewFileContentHandler(logger *slog.Logger) http.HandlerFunc {
 	}
 }
 
-func NewFileSaveHandler(logger *slog.Logger) http.HandlerFunc {
+func NewFileSaveHandler(sandbox *FileSandbox, audit *AuditLog, logger *slog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		reqPath := r.URL.Query().Get("path")
 		if reqPath == "" {
@@ -117,14 +114,13 @@ func NewFileSaveHandler(logger *slog.Logger) http.HandlerFunc {
 			return
 		}
 
-		absPath, err := filepath.Abs(reqPath)
-		if err != nil {
-			http.Error(w, "invalid path", http.StatusBadRequest)
+		// Only allow saving to existing files (no creating new files).
+		// Resolve fails with 404 for missing files inside the sandbox.
+		absPath, ok := sandbox.resolveRequest(w, r, reqPath)
+		if !ok {
 			return
 		}
-
-		// Only allow saving to existing files (no creating new files)
-		if _, err := os.Stat(absPath); err != nil {
+		if info, err := os.Stat(absPath); err != nil || info.IsDir() {
 			http.Error(w, "file not found", http.StatusNotFound)
 			return
 		}
@@ -142,6 +138,7 @@ func NewFileSaveHandler(logger *slog.Logger) http.HandlerFunc {
 		}
 
 		logger.Info("file saved", "path", absPath, "bytes", len(body))
+		audit.RecordRequest(r, "file_save", "", absPath)
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{"ok": "true", "path": absPath})
 	}
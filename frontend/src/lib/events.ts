@@ -0,0 +1,139 @@
+import type { Session } from './types';
+
+type EventSession = { id: string; name: string };
+type EventWindow = { id: string; session: string; index: string; name: string };
+type EventPane = {
+  id: string;
+  window: string;
+  index: string;
+  target: string;
+  currentCommand: string;
+  claudeState: string;
+  currentPath: string;
+};
+
+type EventMsg = {
+  type: string;
+  sessions?: EventSession[];
+  windows?: EventWindow[];
+  panes?: EventPane[];
+  session?: EventSession;
+  window?: EventWindow;
+  pane?: EventPane;
+  changed?: string[];
+};
+
+type Listener = (sessions: Session[]) => void;
+
+// One /api/events connection shared by every component on the page. It keeps
+// the sessions, windows and panes from the stream's snapshot up to date with
+// the events that follow, and hands listeners the same tree /api/sessions
+// returns.
+const sessions = new Map<string, EventSession>();
+const windows = new Map<string, EventWindow>();
+const panes = new Map<string, EventPane>();
+const listeners = new Set<Listener>();
+let tree: Session[] | null = null;
+
+let ws: WebSocket | null = null;
+let reconnectTimer: ReturnType<typeof setTimeout> | null = null;
+let reconnectDelay = 1000;
+const maxReconnectDelay = 30000;
+
+// subscribeSessions calls fn with the session tree now, if it is known, and
+// whenever it changes. It returns a function that unsubscribes.
+export function subscribeSessions(fn: Listener): () => void {
+  listeners.add(fn);
+  if (tree) fn(tree);
+  if (!ws && !reconnectTimer) connect();
+  return () => {
+    listeners.delete(fn);
+    if (listeners.size === 0) disconnect();
+  };
+}
+
+function connect() {
+  const proto = location.protocol === 'https:' ? 'wss:' : 'ws:';
+  const sock = new WebSocket(`${proto}//${location.host}/api/events`);
+  ws = sock;
+  sock.onopen = () => {
+    reconnectDelay = 1000;
+  };
+  sock.onmessage = (e: MessageEvent) => {
+    try {
+      apply(JSON.parse(e.data) as EventMsg);
+    } catch {}
+  };
+  sock.onclose = () => {
+    if (ws !== sock) return;
+    ws = null;
+    if (listeners.size === 0) return;
+    reconnectTimer = setTimeout(() => {
+      reconnectTimer = null;
+      connect();
+    }, reconnectDelay);
+    reconnectDelay = Math.min(reconnectDelay * 2, maxReconnectDelay);
+  };
+}
+
+function disconnect() {
+  if (reconnectTimer !== null) {
+    clearTimeout(reconnectTimer);
+    reconnectTimer = null;
+  }
+  const sock = ws;
+  ws = null;
+  sock?.close();
+}
+
+function apply(msg: EventMsg) {
+  if (msg.type === 'snapshot') {
+    sessions.clear();
+    windows.clear();
+    panes.clear();
+    for (const s of msg.sessions ?? []) sessions.set(s.id, s);
+    for (const w of msg.windows ?? []) windows.set(w.id, w);
+    for (const p of msg.panes ?? []) panes.set(p.id, p);
+  } else if (msg.session) {
+    if (msg.type === 'session-killed') sessions.delete(msg.session.id);
+    else sessions.set(msg.session.id, msg.session);
+  } else if (msg.window) {
+    if (msg.type === 'window-killed') windows.delete(msg.window.id);
+    else windows.set(msg.window.id, msg.window);
+  } else if (msg.pane) {
+    if (msg.type === 'pane-killed') panes.delete(msg.pane.id);
+    else panes.set(msg.pane.id, msg.pane);
+  } else {
+    return;
+  }
+  tree = buildTree();
+  for (const fn of listeners) fn(tree);
+}
+
+// buildTree orders sessions by name and windows and panes by index, as tmux
+// lists them.
+function buildTree(): Session[] {
+  const byIndex = (a: { index: string }, b: { index: string }) => Number(a.index) - Number(b.index);
+  const out: Session[] = [];
+  for (const s of [...sessions.values()].sort((a, b) => a.name.localeCompare(b.name))) {
+    const wins = [...windows.values()].filter(w => w.session === s.id).sort(byIndex);
+    const sess: Session = { name: s.name, windows: [] };
+    for (const w of wins) {
+      const ps = [...panes.values()].filter(p => p.window === w.id).sort(byIndex);
+      if (ps.length === 0) continue;
+      sess.windows.push({
+        index: w.index,
+        name: w.name,
+        panes: ps.map(p => ({
+          index: p.index,
+          currentCommand: p.currentCommand,
+          target: p.target,
+          claudeState: p.claudeState,
+          currentPath: p.currentPath,
+        })),
+      });
+    }
+    if (sess.windows.length > 0) out.push(sess);
+  }
+  return out;
+}
@@ -1,11 +1,29 @@
 export type ConnectionState = 'disconnected' | 'connecting' | 'replaying' | 'live' | 'error';
 export type PaneState = 'connected' | 'missing' | 'unknown';
 
+// Binary frame types; must match protocol.go.
+const FRAME_OUTPUT = 0x01;
+const FRAME_INPUT = 0x02;
+const FRAME_PANE = 0x03;
+
 export interface WSCallbacks {
   onOutput: (data: Uint8Array) => void;
   onStatus: (paneState: PaneState, epoch: number, cols: number, rows: number) => void;
   onConnectionState: (state: ConnectionState) => void;
   onError: (message: string) => void;
+  // Called before a snapshot replaces the terminal contents after a failed
+  // resume, a checkpoint replay, a fast-forward or throttling.
+  onReset?: () => void;
+  // Called when the server will size the pane from the clients' sizes;
+  // the client should send its size with sendResize.
+  onResizable?: () => void;
+}
+
+// Output rate cap sent in the hello. Above it the server stops streaming and
+// sends periodic screen updates until output slows down.
+export interface RateLimit {
+  maxBytesPerSec?: number;
+  maxFramesPerSec?: number;
 }
 
 export class WebSocketClient {
@@ -17,29 +35,55 @@ export class WebSocketClient {
   private maxReconnectDelay = 30000;
   private lastReplayMode: 'full' | 'tail' = 'full';
   private lastTailSize: number = 256 * 1024;
+  // Full replay: Unix ms the history is needed from; 0 replays everything.
+  private lastSince = 0;
+  // Next ring offset and its epoch, used to resume after a dropped connection.
+  private nextOffset: number | null = null;
+  private epoch: number | null = null;
+  rateLimit: RateLimit = {};
+  // The server's resize policy, when it is not 'pane'.
+  resizePolicy: string | null = null;
 
   constructor(callbacks: WSCallbacks, basePath: string = '') {
     this.callbacks = callbacks;
     this.basePath = basePath;
   }
 
-  connect(replayMode: 'full' | 'tail' = 'full', tailSize: number = 256 * 1024): void {
+  connect(replayMode: 'full' | 'tail' = 'full', tailSize: number = 256 * 1024, since: number = 0): void {
     this.lastReplayMode = replayMode;
     this.lastTailSize = tailSize;
+    this.lastSince = since;
+    this.nextOffset = null;
+    this.epoch = null;
+    this.open();
+  }
+
+  private open(): void {
+    const replayMode = this.lastReplayMode;
+    const tailSize = this.lastTailSize;
     this.cancelReconnect();
     this.callbacks.onConnectionState('connecting');
 
     const proto = location.protocol === 'https:' ? 'wss:' : 'ws:';
     const ws = new WebSocket(`${proto}//${location.host}${this.basePath}/ws`);
+    ws.binaryType = 'arraybuffer';
     this.ws = ws;
 
     ws.onopen = () => {
       this.reconnectDelay = 1000;
       this.callbacks.onConnectionState('replaying');
-      this.send({ type: 'hello', replayMode, tailSize });
+      if (this.nextOffset !== null && this.epoch !== null) {
+        this.send({ type: 'hello', replayMode: 'resume', tailSize, offset: this.nextOffset, epoch: this.epoch, binary: true, ...this.rateLimit });
+      } else {
+        this.send({ type: 'hello', replayMode, tailSize, since: this.lastSince || undefined, binary: true, ...this.rateLimit });
+      }
     };
 
     ws.onmessage = (ev: MessageEvent) => {
+      if (ev.data instanceof ArrayBuffer) {
+        this.handleFrame(ev.data);
+        return;
+      }
       try {
         const msg = JSON.parse(ev.data);
         this.handleMessage(msg);
@@ -70,8 +114,12 @@ export class WebSocketClient {
 
   sendInput(text: string): void {
     const bytes = new TextEncoder().encode(text);
-    const b64 = btoa(String.fromCharCode(...bytes));
-    this.send({ type: 'input', data: b64 });
+    if (this.ws?.readyState === WebSocket.OPEN) {
+      const frame = new Uint8Array(1 + bytes.length);
+      frame[0] = FRAME_INPUT;
+      frame.set(bytes, 1);
+      this.ws.send(frame);
+    }
   }
 
   sendResize(cols: number, rows: number): void {
@@ -92,13 +140,36 @@ export class WebSocketClient {
         for (let i = 0; i < binary.length; i++) {
           bytes[i] = binary.charCodeAt(i);
         }
-        this.callbacks.onOutput(bytes);
-        // Once we receive output, we're live (or still replaying, but good enough)
-        this.callbacks.onConnectionState('live');
+        this.handleOutput(bytes, typeof msg.offset === 'number' ? msg.offset : -1);
         break;
       }
+      case 'replay':
+        // The server could not resume, or a full replay starts from a
+        // checkpoint; either way a snapshot follows.
+        if (msg.mode !== 'resume') {
+          this.nextOffset = null;
+          this.callbacks.onReset?.();
+        }
+        break;
       case 'status':
+        // We fell behind and output was skipped, or went over our rate
+        // limit; a snapshot follows and the offset is stale until the
+        // stream resumes.
+        if (msg.truncated || msg.throttled) {
+          this.nextOffset = null;
+          this.callbacks.onReset?.();
+        }
+        if (typeof msg.epoch === 'number') {
+          if (this.epoch !== null && msg.epoch !== this.epoch) {
+            this.nextOffset = null;
+          }
+          this.epoch = msg.epoch;
+        }
         this.callbacks.onStatus(msg.paneState as PaneState, msg.epoch, msg.cols || 0, msg.rows || 0);
+        if (msg.resizePolicy) {
+          this.resizePolicy = msg.resizePolicy;
+          this.callbacks.onResizable?.();
+        }
         break;
       case 'error':
         this.callbacks.onError(msg.message);
@@ -106,10 +177,264 @@ export class WebSocketClient {
     }
   }
 
+  // handleFrame decodes a binary output frame: type byte, big-endian int64
+  // offset (-1 for snapshots), then the raw bytes.
+  private handleFrame(buf: ArrayBuffer): void {
+    const view = new DataView(buf);
+    if (buf.byteLength < 9 || view.getUint8(0) !== FRAME_OUTPUT) return;
+    const offset = Number(view.getBigInt64(1));
+    this.handleOutput(new Uint8Array(buf, 9), offset);
+  }
+
+  private handleOutput(bytes: Uint8Array, offset: number): void {
+    if (offset >= 0) {
+      this.nextOffset = offset + bytes.length;
+    }
+    this.callbacks.onOutput(bytes);
+    // Once we receive output, we're live (or still replaying, but good enough)
+    this.callbacks.onConnectionState('live');
+  }
+
+  private scheduleReconnect(): void {
+    this.cancelReconnect();
+    this.reconnectTimer = setTimeout(() => {
+      this.open();
+    }, this.reconnectDelay);
+    this.reconnectDelay = Math.min(this.reconnectDelay * 2, this.maxReconnectDelay);
+  }
+
+  private cancelReconnect(): void {
+    if (this.reconnectTimer !== null) {
+      clearTimeout(this.reconnectTimer);
+      this.reconnectTimer = null;
+    }
+  }
+}
+
+// A node of a tmux window layout, in character cells: a pane, or a split
+// whose children sit side by side ('horizontal') or stacked ('vertical').
+export interface LayoutCell {
+  x: number;
+  y: number;
+  cols: number;
+  rows: number;
+  pane?: string;
+  split?: 'horizontal' | 'vertical';
+  children?: LayoutCell[];
+}
+
+export interface WindowPane {
+  id: string;
+  target: string;
+  active: boolean;
+}
+
+export interface WindowLayout {
+  window: string;
+  layout: LayoutCell;
+  panes: WindowPane[];
+}
+
+export interface WindowCallbacks {
+  onLayout: (layout: WindowLayout) => void;
+  onOutput: (pane: string, data: Uint8Array) => void;
+  // pane is null for the window itself, which is only ever 'missing'.
+  onStatus: (pane: string | null, paneState: PaneState) => void;
+  onConnectionState: (state: ConnectionState) => void;
+  onError: (message: string) => void;
+  onReset?: (pane: string) => void;
+}
+
+// WindowClient connects to /w/{session}:{window}/ws, which carries every
+// pane of a window: layout messages, and each pane's single-pane stream
+// wrapped in 'pane' envelopes or pane frames.
+export class WindowClient {
+  private ws: WebSocket | null = null;
+  private callbacks: WindowCallbacks;
+  private window: string;
+  private reconnectTimer: ReturnType<typeof setTimeout> | null = null;
+  private reconnectDelay = 1000;
+  private maxReconnectDelay = 30000;
+  // Per pane: next ring offset and its epoch, to resume after a drop.
+  private offsets = new Map<string, { offset: number | null; epoch: number | null }>();
+  rateLimit: RateLimit = {};
+
+  constructor(callbacks: WindowCallbacks, window: string) {
+    this.callbacks = callbacks;
+    this.window = window;
+  }
+
+  connect(): void {
+    this.offsets.clear();
+    this.open();
+  }
+
+  private open(): void {
+    this.cancelReconnect();
+    this.callbacks.onConnectionState('connecting');
+
+    const proto = location.protocol === 'https:' ? 'wss:' : 'ws:';
+    const ws = new WebSocket(`${proto}//${location.host}/w/${encodeURIComponent(this.window)}/ws`);
+    ws.binaryType = 'arraybuffer';
+    this.ws = ws;
+
+    ws.onopen = () => {
+      this.reconnectDelay = 1000;
+      this.callbacks.onConnectionState('replaying');
+      const panes: Record<string, { offset: number; epoch: number }> = {};
+      for (const [id, p] of this.offsets) {
+        if (p.offset !== null && p.epoch !== null) panes[id] = { offset: p.offset, epoch: p.epoch };
+      }
+      const resume = Object.keys(panes).length > 0;
+      this.send({ type: 'hello', replayMode: resume ? 'resume' : 'tail', panes: resume ? panes : undefined, binary: true, ...this.rateLimit });
+    };
+
+    ws.onmessage = (ev: MessageEvent) => {
+      if (ev.data instanceof ArrayBuffer) {
+        this.handleFrame(ev.data);
+        return;
+      }
+      try {
+        this.handleMessage(JSON.parse(ev.data));
+      } catch {
+        // ignore malformed messages
+      }
+    };
+
+    ws.onclose = () => {
+      this.ws = null;
+      this.callbacks.onConnectionState('disconnected');
+      this.scheduleReconnect();
+    };
+
+    ws.onerror = () => {
+      this.callbacks.onConnectionState('error');
+    };
+  }
+
+  disconnect(): void {
+    this.cancelReconnect();
+    if (this.ws) {
+      this.ws.close();
+      this.ws = null;
+    }
+    this.callbacks.onConnectionState('disconnected');
+  }
+
+  sendInput(pane: string, text: string): void {
+    const bytes = new TextEncoder().encode(text);
+    const id = new TextEncoder().encode(pane);
+    if (this.ws?.readyState === WebSocket.OPEN) {
+      const frame = new Uint8Array(3 + id.length + bytes.length);
+      frame[0] = FRAME_PANE;
+      frame[1] = id.length;
+      frame.set(id, 2);
+      frame[2 + id.length] = FRAME_INPUT;
+      frame.set(bytes, 3 + id.length);
+      this.ws.send(frame);
+    }
+  }
+
+  private send(msg: object): void {
+    if (this.ws?.readyState === WebSocket.OPEN) {
+      this.ws.send(JSON.stringify(msg));
+    }
+  }
+
+  private handleMessage(msg: any): void {
+    switch (msg.type) {
+      case 'layout':
+        for (const id of [...this.offsets.keys()]) {
+          if (!msg.panes.some((p: WindowPane) => p.id === id)) this.offsets.delete(id);
+        }
+        this.callbacks.onLayout(msg as WindowLayout);
+        break;
+      case 'pane':
+        this.handlePaneMessage(msg.pane, msg.msg);
+        break;
+      case 'status':
+        this.offsets.clear();
+        this.callbacks.onStatus(null, msg.paneState as PaneState);
+        break;
+      case 'error':
+        this.callbacks.onError(msg.message);
+        break;
+    }
+  }
+
+  // handlePaneMessage applies one message of a pane's stream, as
+  // WebSocketClient does for a single-pane connection.
+  private handlePaneMessage(pane: string, msg: any): void {
+    const p = this.pane(pane);
+    switch (msg.type) {
+      case 'output': {
+        const binary = atob(msg.data);
+        const bytes = new Uint8Array(binary.length);
+        for (let i = 0; i < binary.length; i++) {
+          bytes[i] = binary.charCodeAt(i);
+        }
+        this.handleOutput(pane, bytes, typeof msg.offset === 'number' ? msg.offset : -1);
+        break;
+      }
+      case 'replay':
+        if (msg.mode !== 'resume') {
+          p.offset = null;
+          this.callbacks.onReset?.(pane);
+        }
+        break;
+      case 'status':
+        if (msg.truncated || msg.throttled) {
+          p.offset = null;
+          this.callbacks.onReset?.(pane);
+        }
+        if (typeof msg.epoch === 'number') {
+          if (p.epoch !== null && msg.epoch !== p.epoch) {
+            p.offset = null;
+          }
+          p.epoch = msg.epoch;
+        }
+        this.callbacks.onStatus(pane, msg.paneState as PaneState);
+        break;
+      case 'error':
+        this.callbacks.onError(msg.message);
+        break;
+    }
+  }
+
+  // handleFrame decodes a pane frame: type byte, pane ID length and ID, then
+  // a single-pane binary output frame.
+  private handleFrame(buf: ArrayBuffer): void {
+    const bytes = new Uint8Array(buf);
+    if (bytes.length < 2 || bytes[0] !== FRAME_PANE) return;
+    const start = 2 + bytes[1];
+    const pane = new TextDecoder().decode(bytes.subarray(2, start));
+    const view = new DataView(buf, start);
+    if (view.byteLength < 9 || view.getUint8(0) !== FRAME_OUTPUT) return;
+    const offset = Number(view.getBigInt64(1));
+    this.handleOutput(pane, new Uint8Array(buf, start + 9), offset);
+  }
+
+  private handleOutput(pane: string, bytes: Uint8Array, offset: number): void {
+    if (offset >= 0) {
+      this.pane(pane).offset = offset + bytes.length;
+    }
+    this.callbacks.onOutput(pane, bytes);
+    this.callbacks.onConnectionState('live');
+  }
+
+  private pane(id: string): { offset: number | null; epoch: number | null } {
+    let p = this.offsets.get(id);
+    if (!p) {
+      p = { offset: null, epoch: null };
+      this.offsets.set(id, p);
+    }
+    return p;
+  }
+
   private scheduleReconnect(): void {
     this.cancelReconnect();
     this.reconnectTimer = setTimeout(() => {
-      this.connect(this.lastReplayMode, this.lastTailSize);
+      this.open();
     }, this.reconnectDelay);
     this.reconnectDelay = Math.min(this.reconnectDelay * 2, this.maxReconnectDelay);
   }
@@ -3,20 +3,36 @@ package main
 import (
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"log/slog"
+	"sort"
 	"sync"
+
+	"github.com/coder/websocket"
+)
+
+// Policies for clients whose send queue fills up, selected with
+// --slow-client-policy.
+const (
+	// SlowClientFastForward skips a lagging client ahead: its queue is
+	// drained and it is resynced from a terminal snapshot.
+	SlowClientFastForward = "fast-forward"
+	// SlowClientDisconnect closes the connection after repeated drops.
+	SlowClientDisconnect = "disconnect"
 )
 
 // Hub manages all connected WebSocket clients and broadcasts PTY output.
 type Hub struct {
 	mu      sync.RWMutex
 	clients map[string]*Client
+	policy  string
 	logger  *slog.Logger
 }
 
-func NewHub(logger *slog.Logger) *Hub {
+func NewHub(policy string, logger *slog.Logger) *Hub {
 	return &Hub{
 		clients: make(map[string]*Client),
+		policy:  policy,
 		logger:  logger,
 	}
 }
@@ -38,40 +54,198 @@ func (h *Hub) Unregister(c *Client) {
 	}
 }
 
-// Broadcast sends raw PTY output data to all connected clients as an OutputMsg.
-func (h *Hub) Broadcast(data []byte) {
-	msg := OutputMsg{
-		Type: "output",
-		Data: base64.StdEncoding.EncodeToString(data),
+// RegisterAt registers a client that has been sent everything before from by
+// a resume, replay or snapshot. Bytes written to ring since then are queued
+// while the hub is locked, so nothing is lost or repeated between the replay
+// and live output.
+func (h *Hub) RegisterAt(c *Client, ring *RingBuffer, from int64) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	from, err := catchUp(c, ring, from)
+	if err != nil {
+		return err
 	}
-	raw, err := json.Marshal(msg)
+	c.liveFrom = from
+	h.clients[c.id] = c
+	h.logger.Info("client registered", "client_id", c.id, "total", len(h.clients), "live_from", from)
+	return nil
+}
+
+// Resync resumes live output to a lagging or throttled client: it queues
+// snapshot, which reproduces the terminal up to from, and the bytes written
+// since. A throttled client already has the screen at from and passes a nil
+// snapshot.
+func (h *Hub) Resync(c *Client, ring *RingBuffer, snapshot []byte, from int64) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.clients[c.id] != c {
+		return nil // unregistered meanwhile
+	}
+	if snapshot != nil {
+		select {
+		case c.sendCh <- (&outputFrames{offset: noOffset, data: snapshot}).frame(c.binary):
+		default:
+			return fmt.Errorf("send queue full before snapshot")
+		}
+	}
+	from, err := catchUp(c, ring, from)
 	if err != nil {
-		h.logger.Error("failed to marshal output message", "error", err)
-		return
+		return err
+	}
+	c.liveFrom = from
+	c.lagging.Store(false)
+	c.throttled.Store(false)
+	h.logger.Info("client resynced", "client_id", c.id, "live_from", from)
+	return nil
+}
+
+// catchUp queues the ring's bytes from from onwards and returns where they
+// end. The caller must hold h.mu so no broadcast interleaves.
+func catchUp(c *Client, ring *RingBuffer, from int64) (int64, error) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, next, err := ring.ReadFrom(from, buf)
+		if err != nil {
+			return from, err
+		}
+		if n == 0 {
+			return from, nil
+		}
+		select {
+		case c.sendCh <- (&outputFrames{offset: from, data: buf[:n]}).frame(c.binary):
+		default:
+			return from, fmt.Errorf("send queue full after %d bytes of catch-up", from)
+		}
+		from = next
+	}
+}
+
+// wsFrame is one queued outbound WebSocket message.
+type wsFrame struct {
+	typ  websocket.MessageType
+	data []byte
+}
+
+func textFrame(raw []byte) wsFrame {
+	return wsFrame{typ: websocket.MessageText, data: raw}
+}
+
+// outputFrames encodes a chunk of output for either framing mode, at most
+// once per mode, so a broadcast costs one encoding per mode in use.
+type outputFrames struct {
+	offset int64 // ring offset of data[0], or noOffset
+	data   []byte
+	text   []byte
+	bin    []byte
+}
+
+func (o *outputFrames) frame(binary bool) wsFrame {
+	if binary {
+		if o.bin == nil {
+			o.bin = EncodeOutputFrame(o.offset, o.data)
+		}
+		return wsFrame{typ: websocket.MessageBinary, data: o.bin}
+	}
+	if o.text == nil {
+		msg := OutputMsg{Type: "output", Data: base64.StdEncoding.EncodeToString(o.data)}
+		if o.offset != noOffset {
+			msg.Offset = &o.offset
+		}
+		o.text, _ = json.Marshal(msg)
 	}
+	return textFrame(o.text)
+}
+
+// Broadcast sends raw PTY output data to all connected clients, as an
+// OutputMsg or a binary output frame depending on what each negotiated.
+// offset is the ring position of data's first byte.
+func (h *Hub) Broadcast(offset int64, data []byte) {
+	out := &outputFrames{offset: offset, data: data}
+	end := offset + int64(len(data))
 
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
 	for _, c := range h.clients {
+		if c.lagging.Load() || c.throttled.Load() {
+			continue // skipped until its resync
+		}
+		var msg wsFrame
+		if c.liveFrom > offset {
+			// Already sent during RegisterAt catch-up.
+			if end <= c.liveFrom {
+				continue
+			}
+			msg = (&outputFrames{offset: c.liveFrom, data: data[c.liveFrom-offset:]}).frame(c.binary)
+		} else {
+			msg = out.frame(c.binary)
+		}
+		if c.limit != nil && !c.limit.allow(len(msg.data)) {
+			// Over its cap: the client's throttle loop sends it screen
+			// updates until output slows down.
+			if c.throttled.CompareAndSwap(false, true) {
+				h.logger.Info("client over its rate limit, sending screen updates", "client_id", c.id)
+			}
+			continue
+		}
 		select {
-		case c.sendCh <- raw:
+		case c.sendCh <- msg:
 		default:
-			c.dropped++
-			if c.dropped >= 10 {
-				h.logger.Warn("client too slow, will disconnect", "client_id", c.id, "dropped", c.dropped)
-				go c.conn.CloseNow()
-			}
+			h.lagged(c)
 		}
 	}
 }
 
-// BroadcastStatus sends a status message to all connected clients.
-func (h *Hub) BroadcastStatus(paneState string, epoch int64) {
+// lagged applies the slow-client policy to a client whose queue is full.
+func (h *Hub) lagged(c *Client) {
+	if h.policy == SlowClientDisconnect {
+		c.dropped++
+		if c.dropped >= 10 {
+			h.logger.Warn("client too slow, will disconnect", "client_id", c.id, "dropped", c.dropped)
+			go c.conn.CloseNow()
+		}
+		return
+	}
+	// Anything queued after a dropped frame would corrupt the terminal, so
+	// stop sending and let the write pump resync the client.
+	if c.lagging.CompareAndSwap(false, true) {
+		h.logger.Warn("client too slow, fast-forwarding", "client_id", c.id)
+		select {
+		case c.resyncCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Queue enqueues a frame for a registered client without blocking. It
+// reports false if the client has gone or its queue is full.
+func (h *Hub) Queue(c *Client, msg wsFrame) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.clients[c.id] != c {
+		return false
+	}
+	select {
+	case c.sendCh <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// BroadcastStatus sends a status message to all connected clients. cols and
+// rows are the pane's size, or zero when unknown, so clients can resize
+// their terminals to match as soon as the pane changes.
+func (h *Hub) BroadcastStatus(paneState string, epoch int64, cols, rows int) {
 	msg := StatusMsg{
 		Type:      "status",
 		PaneState: paneState,
 		Epoch:     epoch,
+		Cols:      cols,
+		Rows:      rows,
 	}
 	raw, err := json.Marshal(msg)
 	if err != nil {
@@ -83,12 +257,24 @@ func (h *Hub) BroadcastStatus(paneState string, epoch int64) {
 
 	for _, c := range h.clients {
 		select {
-		case c.sendCh <- raw:
+		case c.sendCh <- textFrame(raw):
 		default:
 		}
 	}
 }
 
+// Stats returns the byte counters of every registered client.
+func (h *Hub) Stats() []ClientStats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	result := make([]ClientStats, 0, len(h.clients))
+	for _, c := range h.clients {
+		result = append(result, c.Stats())
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
 // ClientCount returns the number of connected clients.
 func (h *Hub) ClientCount() int {
 	h.mu.RLock()
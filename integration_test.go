@@ -18,6 +18,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -69,7 +70,7 @@ func defaultConfig(t *testing.T, tmuxTarget string, port int) *Config {
 
 // startServer starts the c3 HTTP server and returns components and a cleanup function.
 // It uses the SessionManager architecture; the returned Hub/Ring are for the cfg.TmuxTarget session.
-func startServer(t *testing.T, cfg *Config) (*Hub, *PTYManager, *RingBuffer, *http.Server, func()) {
+func startServer(t *testing.T, cfg *Config) (*Hub, PaneIO, *RingBuffer, *http.Server, func()) {
 	t.Helper()
 	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
 
@@ -79,7 +80,7 @@ func startServer(t *testing.T, cfg *Config) (*Hub, *PTYManager, *RingBuffer, *ht
 	sess := sm.Get(cfg.TmuxTarget)
 
 	indexer := NewFileIndexer([]string{"/tmp"}, 999*time.Hour, logger)
-	mux := NewServer(cfg, sm, indexer, logger)
+	mux := NewServer(cfg, sm, indexer, nil, logger)
 	server := &http.Server{Addr: cfg.ListenAddr, Handler: mux}
 
 	go server.ListenAndServe()
@@ -1081,3 +1082,1493 @@ func TestIntegration_ConcurrentConnectDisconnect(t *testing.T) {
 
 	t.Log("10 concurrent connect/disconnect cycles completed")
 }
+
+// TestIntegration_ViewerReadOnly verifies that a connection on the /view route
+// receives output but has its input rejected.
+func TestIntegration_ViewerReadOnly(t *testing.T) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		t.Skip("tmux not found")
+	}
+
+	port, target, ring, _, cleanup := setupSession(t, "c3-viewer-test")
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	url := fmt.Sprintf("ws://127.0.0.1:%d/s/%s/view", port, target)
+	conn, _, err := websocket.Dial(ctx, url, nil)
+	if err != nil {
+		t.Fatalf("ws dial failed: %v", err)
+	}
+	defer conn.CloseNow()
+	hello, _ := json.Marshal(HelloMsg{Type: "hello", ReplayMode: "full"})
+	conn.Write(ctx, websocket.MessageText, hello)
+
+	// The initial status reports the viewer role.
+	gotRole := ""
+	for gotRole == "" {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			t.Fatalf("read status: %v", err)
+		}
+		var status StatusMsg
+		json.Unmarshal(data, &status)
+		if status.Type == "status" {
+			gotRole = status.Role
+		}
+	}
+	if gotRole != "view" {
+		t.Fatalf("expected role view, got %q", gotRole)
+	}
+
+	// Input is rejected with an error message and never reaches the pane.
+	sendWSInput(t, ctx, conn, "echo viewer-typed\n")
+	gotError := false
+	for !gotError {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			t.Fatalf("read error message: %v", err)
+		}
+		var msg ErrorMsg
+		json.Unmarshal(data, &msg)
+		gotError = msg.Type == "error"
+	}
+
+	// A control connection still works and its output reaches the viewer.
+	tmuxSend(t, target, "echo viewer-sees-this", "Enter")
+	output := readWSOutputUntil(t, ctx, conn, func(acc []byte) bool {
+		return strings.Contains(string(acc), "viewer-sees-this")
+	})
+	if !strings.Contains(string(output), "viewer-sees-this") {
+		t.Error("viewer did not receive live output")
+	}
+	if data, _ := ring.Snapshot(); strings.Contains(string(data), "viewer-typed") {
+		t.Error("viewer input reached the pane")
+	}
+
+	conn.Close(websocket.StatusNormalClosure, "done")
+}
+
+// ---------------------------------------------------------------------------
+// Test: Resume from offset — a reconnecting client receives only the bytes it
+// missed, with contiguous offsets and no snapshot
+// ---------------------------------------------------------------------------
+
+func TestIntegration_ResumeFromOffset(t *testing.T) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		t.Skip("tmux not found")
+	}
+
+	port, target, ring, _, cleanup := setupSession(t, "c3-resume-test")
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	dial := func(hello HelloMsg) *websocket.Conn {
+		conn, _, err := websocket.Dial(ctx, fmt.Sprintf("ws://127.0.0.1:%d/s/%s/ws", port, target), nil)
+		if err != nil {
+			t.Fatalf("ws dial failed: %v", err)
+		}
+		raw, _ := json.Marshal(hello)
+		conn.Write(ctx, websocket.MessageText, raw)
+		return conn
+	}
+
+	// First connection: learn the epoch and track offsets of live output.
+	conn := dial(HelloMsg{Type: "hello", ReplayMode: "tail"})
+	var epoch, next int64
+	var seen []byte
+	sent := false
+	for !strings.Contains(string(seen), "RESUME_A\r\n") {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		var msg struct {
+			Type   string `json:"type"`
+			Data   string `json:"data"`
+			Offset *int64 `json:"offset"`
+			Epoch  int64  `json:"epoch"`
+		}
+		json.Unmarshal(data, &msg)
+		switch {
+		case msg.Type == "status":
+			epoch = msg.Epoch
+			if !sent {
+				tmuxSend(t, target, `printf 'RESUME_%s\n' A`, "Enter")
+				sent = true
+			}
+		case msg.Type == "output" && msg.Offset != nil:
+			decoded, _ := base64.StdEncoding.DecodeString(msg.Data)
+			seen = append(seen, decoded...)
+			next = *msg.Offset + int64(len(decoded))
+		}
+	}
+	conn.CloseNow()
+
+	// Output produced while disconnected.
+	tmuxSend(t, target, `printf 'RESUME_%s\n' B`, "Enter")
+	if err := waitForRingContent(ring, "RESUME_B\r\n", 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// Resume: a replay message, then output starting exactly at next.
+	conn = dial(HelloMsg{Type: "hello", ReplayMode: "resume", Offset: next, Epoch: epoch})
+	defer conn.CloseNow()
+	var replay ReplayMsg
+	for replay.Type != "replay" {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			t.Fatalf("read replay: %v", err)
+		}
+		json.Unmarshal(data, &replay)
+	}
+	if replay.Mode != "resume" || replay.Offset != next {
+		t.Fatalf("expected resume at %d, got %+v", next, replay)
+	}
+	var resumed []byte
+	pos := next
+	for !strings.Contains(string(resumed), "RESUME_B\r\n") {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			t.Fatalf("read: %v (got %q)", err, resumed)
+		}
+		var msg OutputMsg
+		json.Unmarshal(data, &msg)
+		if msg.Type != "output" {
+			continue
+		}
+		if msg.Offset == nil || *msg.Offset != pos {
+			t.Fatalf("expected output at offset %d, got %v", pos, msg.Offset)
+		}
+		decoded, _ := base64.StdEncoding.DecodeString(msg.Data)
+		resumed = append(resumed, decoded...)
+		pos += int64(len(decoded))
+	}
+	if strings.Contains(string(resumed), "RESUME_A\r\n") {
+		t.Errorf("resume replayed output the client already had: %q", resumed)
+	}
+	if strings.Contains(string(resumed), "\x1b[2J") {
+		t.Errorf("resume sent a snapshot: %q", resumed)
+	}
+
+	// A stale epoch falls back to a snapshot.
+	stale := dial(HelloMsg{Type: "hello", ReplayMode: "resume", Offset: next, Epoch: epoch - 1})
+	defer stale.CloseNow()
+	replay = ReplayMsg{}
+	for replay.Type != "replay" {
+		_, data, err := stale.Read(ctx)
+		if err != nil {
+			t.Fatalf("read replay: %v", err)
+		}
+		json.Unmarshal(data, &replay)
+	}
+	if replay.Mode != "snapshot" {
+		t.Fatalf("expected snapshot fallback for stale epoch, got %+v", replay)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Test: Binary framing — output arrives as binary frames with offsets and
+// binary input frames reach the pane, while control messages stay JSON
+// ---------------------------------------------------------------------------
+
+func TestIntegration_BinaryFrames(t *testing.T) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		t.Skip("tmux not found")
+	}
+
+	port, target, _, _, cleanup := setupSession(t, "c3-binary-test")
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, fmt.Sprintf("ws://127.0.0.1:%d/s/%s/ws", port, target), nil)
+	if err != nil {
+		t.Fatalf("ws dial failed: %v", err)
+	}
+	defer conn.CloseNow()
+	hello, _ := json.Marshal(HelloMsg{Type: "hello", ReplayMode: "tail", Binary: true})
+	conn.Write(ctx, websocket.MessageText, hello)
+
+	var output []byte
+	gotStatus := false
+	for !strings.Contains(string(output), "binary-echo-ok") {
+		typ, data, err := conn.Read(ctx)
+		if err != nil {
+			t.Fatalf("read: %v (got %q)", err, output)
+		}
+		if typ == websocket.MessageText {
+			var status StatusMsg
+			json.Unmarshal(data, &status)
+			if status.Type == "status" && !gotStatus {
+				gotStatus = true
+				conn.Write(ctx, websocket.MessageBinary, EncodeInputFrame([]byte("echo binary-echo-ok\n")))
+			}
+			continue
+		}
+		_, chunk, err := DecodeOutputFrame(data)
+		if err != nil {
+			t.Fatalf("bad binary frame: %v", err)
+		}
+		output = append(output, chunk...)
+	}
+	if !gotStatus {
+		t.Error("expected status as a JSON text message")
+	}
+
+	// The client shows up in the metrics with its framing and byte counts.
+	resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/api/metrics", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var metrics struct {
+		Clients map[string][]ClientStats `json:"clients"`
+	}
+	json.NewDecoder(resp.Body).Decode(&metrics)
+	clients := metrics.Clients[target]
+	if len(clients) != 1 || !clients[0].Binary || clients[0].RawBytes < int64(len(output)) || clients[0].WireBytes == 0 {
+		t.Fatalf("unexpected metrics: %+v", metrics)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Terminal emulator snapshots
+// ---------------------------------------------------------------------------
+
+// TestIntegration_SnapshotState checks that a tail reconnect receives the
+// emulator's snapshot, including terminal modes, and injects no input.
+func TestIntegration_SnapshotState(t *testing.T) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		t.Skip("tmux not found")
+	}
+
+	port, target, ring, _, cleanup := setupSession(t, "c3-vt-test")
+	defer cleanup()
+
+	tmuxSend(t, target, `printf '\033[?1049h\033[2;10r\033[?1000h\033[5;3HALT-%s' MARKER`, "Enter")
+	if err := waitForRingContent(ring, "ALT-MARKER", 10*time.Second); err != nil {
+		t.Fatalf("output not captured: %v", err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	before := ring.WritePos()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn := connectWS(t, ctx, port, target, "tail", 0)
+	defer conn.CloseNow()
+
+	snap := readWSOutputUntil(t, ctx, conn, func(acc []byte) bool {
+		return strings.Contains(string(acc), "ALT-MARKER")
+	})
+	for _, want := range []string{"\x1bc", "\x1b[?1049h", "\x1b[2;10r", "\x1b[?1000h", "\x1b[5;1H  ALT-MARKER"} {
+		if !strings.Contains(string(snap), want) {
+			t.Errorf("snapshot missing %q: %q", want, snap)
+		}
+	}
+
+	// Previously a Ctrl-L was injected to make the program redraw; the pane
+	// must now see no input at all.
+	time.Sleep(1 * time.Second)
+	if after := ring.WritePos(); after != before {
+		t.Errorf("pane produced %d bytes after reconnect, want none", after-before)
+	}
+}
+
+// TestIntegration_SeedFromTmux checks that the emulator seeded from a pane
+// that is already in the alternate screen reproduces its state.
+func TestIntegration_SeedFromTmux(t *testing.T) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		t.Skip("tmux not found")
+	}
+
+	cleanup := testTmuxSession(t, "c3-seed-test")
+	defer cleanup()
+	target := "c3-seed-test:0.0"
+
+	tmuxSend(t, target, "echo PRIMARY-TEXT", "Enter")
+	tmuxSend(t, target, `printf '\033[?1049h\033[?1h\033[3;4HALT-TEXT'`, "Enter")
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		out, _ := exec.Command("tmux", "display-message", "-p", "-t", target, "#{alternate_on}").Output()
+		if strings.TrimSpace(string(out)) == "1" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("pane never entered the alternate screen")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	ring := NewRingBuffer(1024)
+	ring.Write([]byte("earlier output"))
+	vt := NewVTerm(10, 10, snapshotScrollback)
+	if err := seedTerminal(vt, ring, target); err != nil {
+		t.Fatalf("seed failed: %v", err)
+	}
+
+	if cols, rows := vt.Size(); cols != 80 || rows != 24 {
+		t.Errorf("size %dx%d, want 80x24", cols, rows)
+	}
+	if vt.Offset() != ring.WritePos() {
+		t.Errorf("offset %d, want %d", vt.Offset(), ring.WritePos())
+	}
+	if !vt.altActive || !vt.appCursor {
+		t.Errorf("alt=%v appCursor=%v, want both set", vt.altActive, vt.appCursor)
+	}
+	if got := vtText(vt)[2]; !strings.HasPrefix(got, "   ALT-TEXT") {
+		t.Errorf("alt row 3 = %q", got)
+	}
+	vt.Write(vt.Offset(), []byte("\x1b[?1049l"))
+	if !strings.Contains(strings.Join(vtText(vt), "\n"), "PRIMARY-TEXT") {
+		t.Errorf("primary screen not restored: %q", vtText(vt))
+	}
+}
+
+// TestIntegration_CheckpointReplay checks that a full replay with since
+// starts from the last checkpoint before it rather than the oldest byte.
+func TestIntegration_CheckpointReplay(t *testing.T) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		t.Skip("tmux not found")
+	}
+
+	name := "c3-checkpoint-test"
+	tmuxCleanup := testTmuxSession(t, name)
+	defer tmuxCleanup()
+	port := getFreePort(t)
+	target := name + ":0.0"
+	cfg := defaultConfig(t, target, port)
+	cfg.CheckpointBytes = 1
+	_, _, ring, _, serverCleanup := startServer(t, cfg)
+	defer serverCleanup()
+	time.Sleep(3 * time.Second)
+
+	tmuxSend(t, target, "echo CP_OLD", "Enter")
+	if err := waitForRingContent(ring, "CP_OLD\r\n", 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	since := time.Now()
+	cp, ok := ring.CheckpointBefore(since)
+	if !ok {
+		t.Fatal("no checkpoint recorded")
+	}
+	tmuxSend(t, target, "echo CP_NEW", "Enter")
+	if err := waitForRingContent(ring, "CP_NEW\r\n", 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, fmt.Sprintf("ws://127.0.0.1:%d/s/%s/ws", port, target), nil)
+	if err != nil {
+		t.Fatalf("ws dial failed: %v", err)
+	}
+	defer conn.CloseNow()
+	raw, _ := json.Marshal(HelloMsg{Type: "hello", ReplayMode: "full", Since: since.UnixMilli()})
+	conn.Write(ctx, websocket.MessageText, raw)
+
+	var replay ReplayMsg
+	for replay.Type != "replay" {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			t.Fatalf("read replay: %v", err)
+		}
+		json.Unmarshal(data, &replay)
+	}
+	if replay.Mode != "checkpoint" || replay.Offset != cp.Offset || cp.Offset == 0 {
+		t.Fatalf("expected replay from checkpoint at %d, got %+v", cp.Offset, replay)
+	}
+
+	var state, streamed []byte
+	for !strings.Contains(string(streamed), "CP_NEW\r\n") {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			t.Fatalf("read: %v (got %q)", err, streamed)
+		}
+		var msg OutputMsg
+		json.Unmarshal(data, &msg)
+		if msg.Type != "output" {
+			continue
+		}
+		decoded, _ := base64.StdEncoding.DecodeString(msg.Data)
+		switch {
+		case msg.Offset == nil:
+			state = append(state, decoded...)
+		case *msg.Offset != cp.Offset+int64(len(streamed)):
+			t.Fatalf("expected output at offset %d, got %d", cp.Offset+int64(len(streamed)), *msg.Offset)
+		default:
+			streamed = append(streamed, decoded...)
+		}
+	}
+	if !strings.Contains(string(state), "CP_OLD") {
+		t.Errorf("checkpoint state missing earlier output: %q", state)
+	}
+	if strings.Contains(string(streamed), "CP_OLD") {
+		t.Errorf("replay streamed bytes from before the checkpoint: %q", streamed)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Slow clients
+// ---------------------------------------------------------------------------
+
+// TestIntegration_SlowClientFastForward checks that a client that stops
+// reading is resynced from a snapshot, with a truncated status, instead of
+// being disconnected.
+func TestIntegration_SlowClientFastForward(t *testing.T) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		t.Skip("tmux not found")
+	}
+
+	name := "c3-slow-test"
+	tmuxCleanup := testTmuxSession(t, name)
+	defer tmuxCleanup()
+	port := getFreePort(t)
+	target := name + ":0.0"
+	cfg := defaultConfig(t, target, port)
+	cfg.ClientQueueSize = 8
+	_, _, ring, _, serverCleanup := startServer(t, cfg)
+	defer serverCleanup()
+	time.Sleep(3 * time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	// A tiny receive buffer stops the kernel from absorbing the backlog.
+	dialer := &net.Dialer{Control: func(_, _ string, rc syscall.RawConn) error {
+		return rc.Control(func(fd uintptr) {
+			syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF, 4096)
+		})
+	}}
+	conn, _, err := websocket.Dial(ctx, fmt.Sprintf("ws://127.0.0.1:%d/s/%s/ws", port, target), &websocket.DialOptions{
+		HTTPClient: &http.Client{Transport: &http.Transport{DialContext: dialer.DialContext}},
+	})
+	if err != nil {
+		t.Fatalf("ws dial failed: %v", err)
+	}
+	defer conn.CloseNow()
+	conn.SetReadLimit(1024 * 1024)
+	raw, _ := json.Marshal(HelloMsg{Type: "hello", ReplayMode: "tail"})
+	conn.Write(ctx, websocket.MessageText, raw)
+	readWSOutputUntil(t, ctx, conn, func(acc []byte) bool { return len(acc) > 0 })
+
+	// Stop reading while the pane floods output.
+	tmuxSend(t, target, "seq 1 1000000 && printf 'SLOW_%s\\n' DONE", "Enter")
+	if err := waitForRingContent(ring, "SLOW_DONE\r\n", 20*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	truncated := false
+	var tail []byte
+	for !truncated || !strings.Contains(string(tail), "SLOW_DONE\r\n") {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			t.Fatalf("read: %v (truncated=%v)", err, truncated)
+		}
+		var msg struct {
+			Type      string `json:"type"`
+			Data      string `json:"data"`
+			Truncated bool   `json:"truncated"`
+		}
+		json.Unmarshal(data, &msg)
+		switch msg.Type {
+		case "status":
+			if msg.Truncated {
+				truncated = true
+				tail = nil
+			}
+		case "output":
+			decoded, _ := base64.StdEncoding.DecodeString(msg.Data)
+			tail = append(tail, decoded...)
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Output batching
+// ---------------------------------------------------------------------------
+
+// TestIntegration_OutputBatching checks that a stream of small writes reaches
+// clients in fewer frames than the pane produced chunks.
+func TestIntegration_OutputBatching(t *testing.T) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		t.Skip("tmux not found")
+	}
+
+	name := "c3-batch-test"
+	tmuxCleanup := testTmuxSession(t, name)
+	defer tmuxCleanup()
+	port := getFreePort(t)
+	target := name + ":0.0"
+	cfg := defaultConfig(t, target, port)
+	cfg.OutputBatchWindow = 200 * time.Millisecond
+	cfg.OutputBatchBytes = 64 * 1024
+	_, _, _, _, serverCleanup := startServer(t, cfg)
+	defer serverCleanup()
+	time.Sleep(3 * time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	conn := connectWS(t, ctx, port, target, "tail", 0)
+	defer conn.CloseNow()
+	readWSOutputUntil(t, ctx, conn, func(acc []byte) bool { return len(acc) > 0 })
+
+	metrics := func() OutputStats {
+		resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/api/metrics", port))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var m struct {
+			Output map[string]OutputStats `json:"output"`
+		}
+		json.NewDecoder(resp.Body).Decode(&m)
+		return m.Output[target]
+	}
+	before := metrics()
+
+	tmuxSend(t, target, `for i in $(seq 40); do printf .; sleep 0.02; done; printf 'BATCH_%s\n' DONE`, "Enter")
+	readWSOutputUntil(t, ctx, conn, func(acc []byte) bool {
+		return strings.Contains(string(acc), "BATCH_DONE\r\n")
+	})
+
+	after := metrics()
+	chunks, frames := after.Chunks-before.Chunks, after.Frames-before.Frames
+	if chunks < 40 || frames*4 > chunks {
+		t.Fatalf("expected output coalesced, got %d chunks in %d frames", chunks, frames)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Rate limiting
+// ---------------------------------------------------------------------------
+
+// TestIntegration_RateLimitedClient checks that a client over the rate limit
+// in its hello is sent screen updates instead of the flood, and is streamed
+// raw output again once the pane goes quiet.
+func TestIntegration_RateLimitedClient(t *testing.T) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		t.Skip("tmux not found")
+	}
+
+	name := "c3-ratelimit-test"
+	port, target, _, _, cleanup := setupSession(t, name)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, fmt.Sprintf("ws://127.0.0.1:%d/s/%s/ws", port, target), nil)
+	if err != nil {
+		t.Fatalf("ws dial failed: %v", err)
+	}
+	defer conn.CloseNow()
+	raw, _ := json.Marshal(HelloMsg{Type: "hello", ReplayMode: "tail", MaxBytesPerSec: 4096})
+	conn.Write(ctx, websocket.MessageText, raw)
+	readWSOutputUntil(t, ctx, conn, func(acc []byte) bool { return len(acc) > 0 })
+
+	tmuxSend(t, target, "seq 1 50000 && printf 'RATE_%s\\n' DONE", "Enter")
+
+	type message struct {
+		Type      string `json:"type"`
+		Data      string `json:"data"`
+		Offset    *int64 `json:"offset"`
+		Throttled bool   `json:"throttled"`
+	}
+	read := func() (message, []byte) {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		var msg message
+		json.Unmarshal(data, &msg)
+		decoded, _ := base64.StdEncoding.DecodeString(msg.Data)
+		return msg, decoded
+	}
+
+	throttled, received := false, 0
+	for {
+		msg, data := read()
+		received += len(data)
+		if msg.Throttled {
+			throttled = true
+		}
+		if throttled && msg.Type == "output" && msg.Offset == nil && strings.Contains(string(data), "RATE_DONE") {
+			break
+		}
+	}
+	if received > 64*1024 {
+		t.Fatalf("received %d bytes while throttled", received)
+	}
+
+	// Once quiet, output streams again with offsets.
+	time.Sleep(time.Second)
+	tmuxSend(t, target, "printf 'RATE_%s\\n' LIVE", "Enter")
+	for {
+		msg, data := read()
+		if msg.Type == "output" && msg.Offset != nil && strings.Contains(string(data), "RATE_LIVE\r\n") {
+			break
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Control mode
+// ---------------------------------------------------------------------------
+
+// TestIntegration_ControlMode runs a session on the control-mode backend:
+// output reaches the browser and input, including UTF-8 and control
+// characters, reaches the pane.
+func TestIntegration_ControlMode(t *testing.T) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		t.Skip("tmux not found")
+	}
+
+	name := "c3-control-test"
+	tmuxCleanup := testTmuxSession(t, name)
+	defer tmuxCleanup()
+	port := getFreePort(t)
+	target := name + ":0.0"
+	cfg := defaultConfig(t, target, port)
+	cfg.TmuxBackend = TmuxBackendControl
+	_, _, ring, _, serverCleanup := startServer(t, cfg)
+	defer serverCleanup()
+	time.Sleep(3 * time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	conn := connectWS(t, ctx, port, target, "tail", 0)
+	defer conn.CloseNow()
+	readWSOutputUntil(t, ctx, conn, func(acc []byte) bool { return len(acc) > 0 })
+
+	sendWSInput(t, ctx, conn, "sleep 999\r")
+	if err := waitForRingContent(ring, "sleep 999", 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	sendWSInput(t, ctx, conn, "\x03")
+	sendWSInput(t, ctx, conn, "printf 'CTL_%s\\n' \"$(printf 'caf\\303\\251')\" é\r")
+	readWSOutputUntil(t, ctx, conn, func(acc []byte) bool {
+		return strings.Contains(string(acc), "CTL_café\r\n")
+	})
+	if err := waitForRingContent(ring, "é\r\n", 5*time.Second); err != nil {
+		t.Fatalf("typed UTF-8 not echoed: %v", err)
+	}
+}
+
+// TestIntegration_ControlModeSharedConnection checks that panes of one tmux
+// session share a control connection, each receiving only its own output,
+// and that the connection is closed with the last pane.
+func TestIntegration_ControlModeSharedConnection(t *testing.T) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		t.Skip("tmux not found")
+	}
+
+	name := "c3-control-shared-test"
+	tmuxCleanup := testTmuxSession(t, name)
+	defer tmuxCleanup()
+	if err := exec.Command("tmux", "split-window", "-t", name+":0").Run(); err != nil {
+		t.Fatal(err)
+	}
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	mode := NewControlMode(logger)
+
+	var panes []*ControlPane
+	var rings []*RingBuffer
+	for i := range 2 {
+		ring := NewRingBuffer(64 * 1024)
+		p := NewControlPane(mode, fmt.Sprintf("%s:0.%d", name, i), ring, logger)
+		if err := p.Reattach(""); err != nil {
+			t.Fatal(err)
+		}
+		defer p.Close()
+		p.WriteInput([]byte(fmt.Sprintf("printf 'PANE_%%s\\n' %d\r", i)))
+		panes = append(panes, p)
+		rings = append(rings, ring)
+	}
+	if len(mode.conns) != 1 {
+		t.Fatalf("expected one shared connection, got %d", len(mode.conns))
+	}
+	for i, ring := range rings {
+		if err := waitForRingContent(ring, fmt.Sprintf("PANE_%d\r\n", i), 5*time.Second); err != nil {
+			t.Fatalf("pane %d: %v", i, err)
+		}
+		data, _ := ring.Snapshot()
+		if strings.Contains(string(data), fmt.Sprintf("PANE_%d\r\n", 1-i)) {
+			t.Fatalf("pane %d received the other pane's output", i)
+		}
+	}
+
+	conn := mode.conns[name]
+	panes[0].Close()
+	select {
+	case <-conn.Done():
+		t.Fatal("connection closed while a pane still uses it")
+	case <-time.After(200 * time.Millisecond):
+	}
+	panes[1].Close()
+	select {
+	case <-conn.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection not closed after the last pane")
+	}
+	if len(mode.conns) != 0 {
+		t.Fatalf("expected no connections, got %d", len(mode.conns))
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Existing pipe-pane
+// ---------------------------------------------------------------------------
+
+// userPipeSession creates a session whose pane already pipes into a log file,
+// as a logging plugin would, and returns the target and the log's path.
+func userPipeSession(t *testing.T, name string) (string, string, func()) {
+	t.Helper()
+	cleanup := testTmuxSession(t, name)
+	target := name + ":0.0"
+	logPath := filepath.Join(t.TempDir(), "pane.log")
+	userPipe := "cat >> " + logPath
+	if err := exec.Command("tmux", "pipe-pane", "-t", target, userPipe).Run(); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	exec.Command("tmux", "set-option", "-p", "-t", target, "@c3-user-pipe", userPipe).Run()
+	return target, logPath, cleanup
+}
+
+// waitForFileContent polls until the file at path contains substr.
+func waitForFileContent(path, substr string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if data, _ := os.ReadFile(path); strings.Contains(string(data), substr) {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("timed out waiting for %q in %s", substr, path)
+}
+
+func panePiped(t *testing.T, target string) bool {
+	t.Helper()
+	piped, _, err := PanePipe(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return piped
+}
+
+// TestIntegration_PipePaneRefuse checks that by default the pipe-pane
+// backend leaves a user's pipe running rather than replacing it.
+func TestIntegration_PipePaneRefuse(t *testing.T) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		t.Skip("tmux not found")
+	}
+
+	target, logPath, tmuxCleanup := userPipeSession(t, "c3-userpipe-refuse-test")
+	defer tmuxCleanup()
+	port := getFreePort(t)
+	cfg := defaultConfig(t, target, port)
+	cfg.TmuxBackend = TmuxBackendPipePane
+	cfg.PipePaneConflict = PipeConflictRefuse
+	_, _, ring, _, serverCleanup := startServer(t, cfg)
+	time.Sleep(3 * time.Second)
+
+	tmuxSend(t, target, "printf 'REFUSE_%s\\n' LOGGED", "Enter")
+	if err := waitForFileContent(logPath, "REFUSE_LOGGED", 5*time.Second); err != nil {
+		t.Fatalf("user pipe replaced: %v", err)
+	}
+	if data, _ := ring.Snapshot(); strings.Contains(string(data), "REFUSE_LOGGED") {
+		t.Fatal("c3 read output through a pipe it should have refused")
+	}
+
+	serverCleanup()
+	if !panePiped(t, target) {
+		t.Fatal("closing the session stopped the user's pipe")
+	}
+}
+
+// TestIntegration_PipePaneTee checks that with the tee policy both c3 and
+// the user's pipe receive output, and the user's pipe is restored on close.
+func TestIntegration_PipePaneTee(t *testing.T) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		t.Skip("tmux not found")
+	}
+
+	target, logPath, tmuxCleanup := userPipeSession(t, "c3-userpipe-tee-test")
+	defer tmuxCleanup()
+	port := getFreePort(t)
+	cfg := defaultConfig(t, target, port)
+	cfg.TmuxBackend = TmuxBackendPipePane
+	cfg.PipePaneConflict = PipeConflictTee
+	_, _, ring, _, serverCleanup := startServer(t, cfg)
+	time.Sleep(3 * time.Second)
+
+	tmuxSend(t, target, "printf 'TEE_%s\\n' BOTH", "Enter")
+	if err := waitForRingContent(ring, "TEE_BOTH\r\n", 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitForFileContent(logPath, "TEE_BOTH\r\n", 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	serverCleanup()
+	if !panePiped(t, target) {
+		t.Fatal("user pipe not restored")
+	}
+	tmuxSend(t, target, "printf 'TEE_%s\\n' RESTORED", "Enter")
+	if err := waitForFileContent(logPath, "TEE_RESTORED\r\n", 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Pane events
+// ---------------------------------------------------------------------------
+
+// TestIntegration_PaneMonitorEvents checks that a monitor fed by tmux events
+// notices a pane being respawned, its session killed and recreated, without
+// waiting for its polling interval.
+func TestIntegration_PaneMonitorEvents(t *testing.T) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		t.Skip("tmux not found")
+	}
+
+	name := "c3-events-test"
+	tmuxCleanup := testTmuxSession(t, name)
+	defer tmuxCleanup()
+	target := name + ":0.0"
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	monitor := NewPaneMonitor(target, time.Hour, NewControlMode(logger), logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go monitor.Run(ctx)
+
+	expect := func(state PaneState, within time.Duration) PaneEvent {
+		t.Helper()
+		select {
+		case ev := <-monitor.Events():
+			if ev.State != state {
+				t.Fatalf("got state %v, want %v", ev.State, state)
+			}
+			return ev
+		case <-time.After(within):
+			t.Fatalf("no event with state %v within %v", state, within)
+		}
+		return PaneEvent{}
+	}
+	first := expect(PaneStateConnected, 2*time.Second)
+	time.Sleep(500 * time.Millisecond) // let the control connection attach
+
+	exec.Command("tmux", "respawn-pane", "-k", "-t", target).Run()
+	if ev := expect(PaneStateConnected, 3*time.Second); ev.TTY == first.TTY {
+		t.Fatalf("respawn reported the same TTY %s", ev.TTY)
+	}
+
+	// While the pane's session is gone, the monitor listens on another one
+	// to hear about new sessions.
+	otherCleanup := testTmuxSession(t, "c3-events-other-test")
+	defer otherCleanup()
+	exec.Command("tmux", "kill-session", "-t", name).Run()
+	expect(PaneStateMissing, 2*time.Second)
+
+	time.Sleep(time.Second)
+	testTmuxSession(t, name)
+	expect(PaneStateConnected, 2*time.Second)
+}
+
+// ---------------------------------------------------------------------------
+// Window connections
+// ---------------------------------------------------------------------------
+
+// windowStream accumulates what a window connection has received.
+type windowStream struct {
+	layout LayoutMsg
+	output map[string][]byte // by pane ID
+}
+
+// readWindowUntil reads layouts and pane output from a window connection
+// until pred returns true or ctx expires.
+func readWindowUntil(t *testing.T, ctx context.Context, conn *websocket.Conn, s *windowStream, pred func(*windowStream) bool) bool {
+	t.Helper()
+	for !pred(s) {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			t.Logf("readWindowUntil: read error: %v", err)
+			return false
+		}
+		msg, err := ParseClientMessage(data)
+		if err != nil {
+			if err := json.Unmarshal(data, &s.layout); err != nil || s.layout.Type != "layout" {
+				continue
+			}
+			continue
+		}
+		pm, ok := msg.(*PaneMsg)
+		if !ok {
+			continue
+		}
+		var out OutputMsg
+		if json.Unmarshal(pm.Msg, &out) == nil && out.Type == "output" {
+			decoded, _ := base64.StdEncoding.DecodeString(out.Data)
+			s.output[pm.Pane] = append(s.output[pm.Pane], decoded...)
+		}
+	}
+	return true
+}
+
+func sendWindowInput(t *testing.T, ctx context.Context, conn *websocket.Conn, pane, text string) {
+	t.Helper()
+	inner, _ := json.Marshal(InputMsg{Type: "input", Data: base64.StdEncoding.EncodeToString([]byte(text))})
+	raw, _ := json.Marshal(PaneMsg{Type: "pane", Pane: pane, Msg: inner})
+	if err := conn.Write(ctx, websocket.MessageText, raw); err != nil {
+		t.Fatalf("ws write input failed: %v", err)
+	}
+}
+
+// TestIntegration_WindowConnection follows a window through a split and the
+// first pane being killed, typing into each pane through the multiplexed
+// connection.
+func TestIntegration_WindowConnection(t *testing.T) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		t.Skip("tmux not found")
+	}
+
+	port, target, _, _, cleanup := setupSession(t, "c3-window-test")
+	defer cleanup()
+	window := strings.TrimSuffix(target, ".0")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, fmt.Sprintf("ws://127.0.0.1:%d/w/%s/ws", port, window), nil)
+	if err != nil {
+		t.Fatalf("ws dial failed: %v", err)
+	}
+	defer conn.CloseNow()
+	raw, _ := json.Marshal(HelloMsg{Type: "hello", ReplayMode: "tail"})
+	conn.Write(ctx, websocket.MessageText, raw)
+
+	s := &windowStream{output: map[string][]byte{}}
+	if !readWindowUntil(t, ctx, conn, s, func(s *windowStream) bool { return len(s.layout.Panes) == 1 }) {
+		t.Fatal("no initial layout")
+	}
+	first := s.layout.Panes[0].ID
+	if s.layout.Window != window || s.layout.Layout.Pane != first || s.layout.Panes[0].Target != target {
+		t.Fatalf("unexpected layout %+v", s.layout)
+	}
+
+	if err := exec.Command("tmux", "split-window", "-h", "-t", window).Run(); err != nil {
+		t.Fatalf("split-window: %v", err)
+	}
+	if !readWindowUntil(t, ctx, conn, s, func(s *windowStream) bool { return len(s.layout.Panes) == 2 }) {
+		t.Fatal("no layout after split")
+	}
+	if s.layout.Layout.Split != SplitHorizontal || len(s.layout.Layout.Children) != 2 {
+		t.Fatalf("expected a side-by-side split, got %+v", s.layout.Layout)
+	}
+	second := s.layout.Panes[1].ID
+	time.Sleep(2 * time.Second) // let the new pane's shell start
+
+	sendWindowInput(t, ctx, conn, second, "printf 'WIN_%s\\n' RIGHT\r")
+	if !readWindowUntil(t, ctx, conn, s, func(s *windowStream) bool {
+		return bytes.Contains(s.output[second], []byte("WIN_RIGHT"))
+	}) {
+		t.Fatal("no output from the second pane")
+	}
+	if bytes.Contains(s.output[first], []byte("WIN_RIGHT")) {
+		t.Fatal("second pane's output arrived on the first pane's stream")
+	}
+
+	// The second pane takes index 0, and the session that was streaming
+	// the first pane under that target follows it.
+	if err := exec.Command("tmux", "kill-pane", "-t", first).Run(); err != nil {
+		t.Fatalf("kill-pane: %v", err)
+	}
+	if !readWindowUntil(t, ctx, conn, s, func(s *windowStream) bool {
+		return len(s.layout.Panes) == 1 && s.layout.Panes[0].ID == second
+	}) {
+		t.Fatal("no layout after kill-pane")
+	}
+	if s.layout.Panes[0].Target != target {
+		t.Fatalf("remaining pane has target %s, want %s", s.layout.Panes[0].Target, target)
+	}
+	sendWindowInput(t, ctx, conn, second, "printf 'WIN_%s\\n' AGAIN\r")
+	if !readWindowUntil(t, ctx, conn, s, func(s *windowStream) bool {
+		return bytes.Contains(s.output[second], []byte("WIN_AGAIN"))
+	}) {
+		t.Fatal("no output from the remaining pane")
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Resize policies
+// ---------------------------------------------------------------------------
+
+// readWSStatus reads until a status message satisfying pred arrives.
+func readWSStatus(t *testing.T, ctx context.Context, conn *websocket.Conn, pred func(StatusMsg) bool) (StatusMsg, bool) {
+	t.Helper()
+	for {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			t.Logf("readWSStatus: read error: %v", err)
+			return StatusMsg{}, false
+		}
+		var msg StatusMsg
+		if json.Unmarshal(data, &msg) == nil && msg.Type == "status" && pred(msg) {
+			return msg, true
+		}
+	}
+}
+
+// TestIntegration_ResizeSmallest checks that under the smallest-client
+// policy the pane follows the smallest connected client and gets its
+// original size back when the last one leaves.
+func TestIntegration_ResizeSmallest(t *testing.T) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		t.Skip("tmux not found")
+	}
+
+	name := "c3-resize-test"
+	tmuxCleanup := testTmuxSession(t, name)
+	defer tmuxCleanup()
+	port := getFreePort(t)
+	target := name + ":0.0"
+	cfg := defaultConfig(t, target, port)
+	cfg.ResizePolicy = ResizePolicySmallest
+	_, _, _, _, serverCleanup := startServer(t, cfg)
+	defer serverCleanup()
+	time.Sleep(time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	size := func(cols, rows int) func(StatusMsg) bool {
+		return func(s StatusMsg) bool { return s.Cols == cols && s.Rows == rows }
+	}
+	resize := func(conn *websocket.Conn, cols, rows int) {
+		raw, _ := json.Marshal(ResizeMsg{Type: "resize", Cols: cols, Rows: rows})
+		conn.Write(ctx, websocket.MessageText, raw)
+	}
+
+	desktop := connectWS(t, ctx, port, target, "tail", 0)
+	defer desktop.CloseNow()
+	if s, _ := readWSStatus(t, ctx, desktop, size(80, 24)); s.ResizePolicy != ResizePolicySmallest {
+		t.Fatalf("initial status has resize policy %q", s.ResizePolicy)
+	}
+	resize(desktop, 120, 40)
+	if _, ok := readWSStatus(t, ctx, desktop, size(120, 40)); !ok {
+		t.Fatal("pane not resized to the only client")
+	}
+
+	phone := connectWS(t, ctx, port, target, "tail", 0)
+	readWSStatus(t, ctx, phone, size(120, 40))
+	resize(phone, 60, 50)
+	if _, ok := readWSStatus(t, ctx, desktop, size(60, 40)); !ok {
+		t.Fatal("pane not resized to the smallest of both clients")
+	}
+
+	phone.Close(websocket.StatusNormalClosure, "")
+	if _, ok := readWSStatus(t, ctx, desktop, size(120, 40)); !ok {
+		t.Fatal("pane not resized back after the smaller client left")
+	}
+
+	// The size is restored before the window-size option, so wait for both.
+	desktop.Close(websocket.StatusNormalClosure, "")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		cols, rows, err := PaneDimensions(target)
+		opt, _ := exec.Command("tmux", "show-options", "-w", "-v", "-t", target, "window-size").Output()
+		if err == nil && cols == 80 && rows == 24 && len(bytes.TrimSpace(opt)) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pane is %dx%d with window-size %q after every client left, want 80x24 and unset", cols, rows, opt)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Pane size changes
+// ---------------------------------------------------------------------------
+
+// TestIntegration_PaneResizeBroadcast checks that resizing a window from
+// tmux reaches connected clients as a status with the new size, and that
+// later snapshots are taken at that size.
+func TestIntegration_PaneResizeBroadcast(t *testing.T) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		t.Skip("tmux not found")
+	}
+
+	port, target, _, _, cleanup := setupSession(t, "c3-size-test")
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	conn := connectWS(t, ctx, port, target, "tail", 0)
+	defer conn.CloseNow()
+	readWSStatus(t, ctx, conn, func(s StatusMsg) bool { return s.Cols == 80 && s.Rows == 24 })
+
+	start := time.Now()
+	if err := exec.Command("tmux", "resize-window", "-t", target, "-x", "100", "-y", "30").Run(); err != nil {
+		t.Fatalf("resize-window: %v", err)
+	}
+	status, ok := readWSStatus(t, ctx, conn, func(s StatusMsg) bool { return s.Cols == 100 && s.Rows == 30 })
+	if !ok {
+		t.Fatal("no status with the new size")
+	}
+	if status.PaneState != "connected" {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("size change took %v to arrive", elapsed)
+	}
+
+	// Clients connecting afterwards start at the new size too.
+	late := connectWS(t, ctx, port, target, "tail", 0)
+	defer late.CloseNow()
+	if s, _ := readWSStatus(t, ctx, late, func(StatusMsg) bool { return true }); s.Cols != 100 || s.Rows != 30 {
+		t.Fatalf("new client told %dx%d", s.Cols, s.Rows)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// tmux management endpoints
+// ---------------------------------------------------------------------------
+
+// postAPI posts a JSON body to a management endpoint and returns the status
+// code and the target in the reply.
+func postAPI(t *testing.T, port int, path string, body any) (int, string) {
+	t.Helper()
+	raw, _ := json.Marshal(body)
+	resp, err := http.Post(fmt.Sprintf("http://127.0.0.1:%d%s", port, path), "application/json", bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("POST %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	var reply struct {
+		Target string `json:"target"`
+	}
+	json.NewDecoder(resp.Body).Decode(&reply)
+	return resp.StatusCode, reply.Target
+}
+
+func TestIntegration_TmuxManagement(t *testing.T) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		t.Skip("tmux not found")
+	}
+
+	port, _, _, _, cleanup := setupSession(t, "c3-mgmt")
+	defer cleanup()
+	defer exec.Command("tmux", "kill-session", "-t", "c3-mgmt-renamed").Run()
+
+	expect := func(path string, body any, want string) {
+		t.Helper()
+		code, target := postAPI(t, port, path, body)
+		if code != http.StatusOK || target != want {
+			t.Fatalf("%s: got %d %q, want %q", path, code, target, want)
+		}
+	}
+	query := func(target, format string) string {
+		t.Helper()
+		out, err := exec.Command("tmux", "display-message", "-p", "-t", target, format).Output()
+		if err != nil {
+			t.Fatalf("display-message %s: %v", target, err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	if code, _ := postAPI(t, port, "/api/new-window", map[string]string{"name": "x"}); code != http.StatusBadRequest {
+		t.Fatalf("new-window without a session: %d", code)
+	}
+
+	dir := t.TempDir()
+	expect("/api/new-window", map[string]any{
+		"session": "c3-mgmt",
+		"name":    "work",
+		"cwd":     dir,
+		"command": `echo "var=$C3_MGMT"; exec sleep 60`,
+		"env":     map[string]string{"C3_MGMT": "hello"},
+	}, "c3-mgmt:1.0")
+	if got := query("c3-mgmt:1.0", "#{window_name} #{pane_current_path}"); got != "work "+dir {
+		t.Fatalf("new window: %q", got)
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		out, _ := exec.Command("tmux", "capture-pane", "-p", "-t", "c3-mgmt:1.0").Output()
+		if strings.Contains(string(out), "var=hello") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("command output missing from pane:\n%s", out)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	expect("/api/split-pane", map[string]string{"target": "c3-mgmt:1.0", "split": "horizontal"}, "c3-mgmt:1.1")
+	if code, _ := postAPI(t, port, "/api/split-pane", map[string]string{"target": "c3-mgmt:1.0", "split": "diagonal"}); code != http.StatusBadRequest {
+		t.Fatalf("split with an invalid direction: %d", code)
+	}
+	expect("/api/kill-pane", map[string]string{"target": "c3-mgmt:1.1"}, "c3-mgmt:1.0")
+	expect("/api/respawn-pane", map[string]string{"target": "c3-mgmt:1.0", "command": "exec sleep 30"}, "c3-mgmt:1.0")
+	expect("/api/move-window", map[string]string{"target": "c3-mgmt:1", "dest": "c3-mgmt:5"}, "c3-mgmt:5.0")
+	expect("/api/swap-window", map[string]string{"target": "c3-mgmt:5", "dest": "c3-mgmt:0"}, "c3-mgmt:0.0")
+	if got := query("c3-mgmt:0.0", "#{window_name}"); got != "work" {
+		t.Fatalf("window 0 after swap is %q", got)
+	}
+	expect("/api/select-window", map[string]string{"target": "c3-mgmt:5"}, "c3-mgmt:5.0")
+	if got := query("c3-mgmt", "#{window_index}"); got != "5" {
+		t.Fatalf("current window %q after select", got)
+	}
+	expect("/api/rename-session", map[string]string{"target": "c3-mgmt:0.0", "name": "c3-mgmt-renamed"}, "c3-mgmt-renamed:0.0")
+	expect("/api/kill-session", map[string]string{"name": "c3-mgmt-renamed"}, "")
+	if exec.Command("tmux", "has-session", "-t", "c3-mgmt-renamed").Run() == nil {
+		t.Fatal("session still exists after kill-session")
+	}
+}
+
+func TestIntegration_TmuxManagementExactSession(t *testing.T) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		t.Skip("tmux not found")
+	}
+
+	port, _, _, _, cleanup := setupSession(t, "c3-killhost")
+	defer cleanup()
+	// No session is named exactly c3-victim, but one starts with it.
+	victimCleanup := testTmuxSession(t, "c3-victimxyz")
+	defer victimCleanup()
+
+	if code, _ := postAPI(t, port, "/api/kill-session", map[string]string{"name": "c3-victim"}); code != http.StatusInternalServerError {
+		t.Fatalf("kill-session by prefix: %d", code)
+	}
+	if code, _ := postAPI(t, port, "/api/new-window", map[string]string{"session": "c3-victim"}); code != http.StatusInternalServerError {
+		t.Fatalf("new-window by prefix: %d", code)
+	}
+	out, err := exec.Command("tmux", "list-windows", "-t", "=c3-victimxyz", "-F", "#{window_index}").Output()
+	if err != nil {
+		t.Fatalf("session matched by prefix was killed: %v", err)
+	}
+	if got := strings.TrimSpace(string(out)); got != "0" {
+		t.Fatalf("window opened in the session matched by prefix: %q", got)
+	}
+
+	for _, tc := range []struct {
+		path string
+		body map[string]string
+		want int
+	}{
+		{"/api/kill-session", map[string]string{"name": "c3-victim*"}, http.StatusInternalServerError},
+		{"/api/kill-session", map[string]string{"name": "c3-victimxyz:0"}, http.StatusBadRequest},
+		{"/api/new-window", map[string]string{"session": "c3-victimxyz.0"}, http.StatusBadRequest},
+		{"/api/rename-session", map[string]string{"target": "c3-victimxyz:0.0", "name": "c3.victim"}, http.StatusBadRequest},
+	} {
+		if code, _ := postAPI(t, port, tc.path, tc.body); code != tc.want {
+			t.Fatalf("%s %v: got %d, want %d", tc.path, tc.body, code, tc.want)
+		}
+	}
+	if err := exec.Command("tmux", "has-session", "-t", "=c3-victimxyz").Run(); err != nil {
+		t.Fatalf("session matched by pattern was killed: %v", err)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Session templates
+// ---------------------------------------------------------------------------
+
+func TestIntegration_SessionTemplate(t *testing.T) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		t.Skip("tmux not found")
+	}
+
+	tmuxCleanup := testTmuxSession(t, "c3-tmpl-host")
+	defer tmuxCleanup()
+	defer exec.Command("tmux", "kill-session", "-t", "c3-tmpl-built").Run()
+
+	dir := t.TempDir()
+	port := getFreePort(t)
+	cfg := defaultConfig(t, "c3-tmpl-host:0.0", port)
+	cfg.SessionTemplates = map[string]SessionTemplate{
+		"dev": {
+			Cwd: dir,
+			Env: map[string]string{"C3_TMPL": "template"},
+			Windows: []TemplateWindow{
+				{Name: "code", Layout: "even-horizontal", Panes: []TemplatePane{
+					{SpawnOptions: SpawnOptions{Command: "exec sleep 60"}},
+					{SpawnOptions: SpawnOptions{Command: `echo "tmpl=$C3_TMPL"; exec sleep 60`, Env: map[string]string{"C3_TMPL": "pane"}}, Split: SplitHorizontal, Primary: true},
+				}},
+				{Name: "shell", Panes: []TemplatePane{{SpawnOptions: SpawnOptions{Cwd: "/"}}}},
+			},
+		},
+	}
+	_, _, _, _, serverCleanup := startServer(t, cfg)
+	defer serverCleanup()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/api/session-templates", port))
+		if err == nil {
+			var list struct {
+				Templates []string `json:"templates"`
+			}
+			json.NewDecoder(resp.Body).Decode(&list)
+			resp.Body.Close()
+			if len(list.Templates) != 1 || list.Templates[0] != "dev" {
+				t.Fatalf("templates %v", list.Templates)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server not ready: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if code, _ := postAPI(t, port, "/api/new-session", map[string]string{"name": "c3-tmpl-built", "template": "nope"}); code != http.StatusBadRequest {
+		t.Fatalf("unknown template: %d", code)
+	}
+	if code, _ := postAPI(t, port, "/api/new-session", map[string]string{"name": "c3-tmpl.built", "template": "dev"}); code != http.StatusBadRequest {
+		t.Fatalf("invalid name: %d", code)
+	}
+	code, target := postAPI(t, port, "/api/new-session", map[string]string{"name": "c3-tmpl-built", "template": "dev"})
+	if code != http.StatusOK || target != "c3-tmpl-built:0.1" {
+		t.Fatalf("new-session: %d %q", code, target)
+	}
+
+	out, err := exec.Command("tmux", "list-panes", "-s", "-t", "c3-tmpl-built", "-F",
+		"#{window_index}.#{pane_index} #{window_name} #{pane_current_path}").Output()
+	if err != nil {
+		t.Fatalf("list-panes: %v", err)
+	}
+	want := fmt.Sprintf("0.0 code %s\n0.1 code %s\n1.0 shell /\n", dir, dir)
+	if string(out) != want {
+		t.Fatalf("panes:\n%s\nwant:\n%s", out, want)
+	}
+	deadline = time.Now().Add(3 * time.Second)
+	for {
+		out, _ := exec.Command("tmux", "capture-pane", "-p", "-t", target).Output()
+		if strings.Contains(string(out), "tmpl=pane") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pane environment not applied:\n%s", out)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// Creating it again fails without touching the existing session.
+	if code, _ := postAPI(t, port, "/api/new-session", map[string]string{"name": "c3-tmpl-built", "template": "dev"}); code != http.StatusInternalServerError {
+		t.Fatalf("duplicate session: %d", code)
+	}
+	if exec.Command("tmux", "has-session", "-t", "c3-tmpl-built").Run() != nil {
+		t.Fatal("existing session was killed")
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Events stream
+// ---------------------------------------------------------------------------
+
+func TestIntegration_Events(t *testing.T) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		t.Skip("tmux not found")
+	}
+
+	port, target, _, _, cleanup := setupSession(t, "c3-events")
+	defer cleanup()
+	defer exec.Command("tmux", "kill-session", "-t", "c3-events-renamed").Run()
+	unservedCleanup := testTmuxSession(t, "c3-evunserved")
+	defer unservedCleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, fmt.Sprintf("ws://127.0.0.1:%d/api/events", port), nil)
+	if err != nil {
+		t.Fatalf("dial events: %v", err)
+	}
+	defer conn.CloseNow()
+
+	read := func() EventMsg {
+		t.Helper()
+		_, raw, err := conn.Read(ctx)
+		if err != nil {
+			t.Fatalf("read event: %v", err)
+		}
+		var msg EventMsg
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			t.Fatalf("bad event %s: %v", raw, err)
+		}
+		return msg
+	}
+	// await reads events until one satisfies pred, within 5 seconds.
+	await := func(desc string, pred func(EventMsg) bool) EventMsg {
+		t.Helper()
+		wctx, wcancel := context.WithTimeout(ctx, 5*time.Second)
+		defer wcancel()
+		for {
+			_, raw, err := conn.Read(wctx)
+			if err != nil {
+				t.Fatalf("no %s event: %v", desc, err)
+			}
+			var msg EventMsg
+			json.Unmarshal(raw, &msg)
+			if pred(msg) {
+				return msg
+			}
+		}
+	}
+
+	snap := read()
+	if snap.Type != "snapshot" {
+		t.Fatalf("first message %+v", snap)
+	}
+	var pane EventPane
+	for _, p := range snap.Panes {
+		if p.Target == target {
+			pane = p
+		}
+	}
+	if pane.ID == "" {
+		t.Fatalf("snapshot has no pane %s: %+v", target, snap.Panes)
+	}
+
+	exec.Command("tmux", "set-option", "-p", "-t", target, "@claude-state", "waiting").Run()
+	await("claude state", func(m EventMsg) bool {
+		return m.Type == "pane-changed" && m.Pane.ID == pane.ID && m.Pane.ClaudeState == "waiting"
+	})
+
+	// A session c3 is not serving gets no control client, so its changes
+	// arrive by polling.
+	exec.Command("tmux", "set-option", "-p", "-t", "=c3-evunserved:0.0", "@claude-state", "working").Run()
+	await("unserved claude state", func(m EventMsg) bool {
+		return m.Type == "pane-changed" && m.Pane.Target == "c3-evunserved:0.0" && m.Pane.ClaudeState == "working"
+	})
+	if out, _ := exec.Command("tmux", "list-clients", "-t", "=c3-evunserved").Output(); len(out) != 0 {
+		t.Fatalf("control client attached to a session c3 is not serving: %s", out)
+	}
+
+	exec.Command("tmux", "rename-window", "-t", target, "events-win").Run()
+	await("window rename", func(m EventMsg) bool {
+		return m.Type == "window-changed" && m.Window.ID == pane.Window && m.Window.Name == "events-win"
+	})
+
+	exec.Command("tmux", "split-window", "-d", "-t", target).Run()
+	created := await("pane creation", func(m EventMsg) bool {
+		return m.Type == "pane-created" && m.Pane.Window == pane.Window
+	})
+	exec.Command("tmux", "kill-pane", "-t", created.Pane.ID).Run()
+	await("pane kill", func(m EventMsg) bool {
+		return m.Type == "pane-killed" && m.Pane.ID == created.Pane.ID
+	})
+
+	exec.Command("tmux", "rename-session", "-t", "c3-events", "c3-events-renamed").Run()
+	renamed := await("session rename", func(m EventMsg) bool {
+		return m.Type == "session-changed" && m.Session.Name == "c3-events-renamed"
+	})
+	exec.Command("tmux", "kill-session", "-t", "c3-events-renamed").Run()
+	await("session kill", func(m EventMsg) bool {
+		return m.Type == "session-killed" && m.Session.ID == renamed.Session.ID
+	})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Split directions of a LayoutCell.
+const (
+	SplitHorizontal = "horizontal" // children side by side, left to right
+	SplitVertical   = "vertical"   // children stacked, top to bottom
+)
+
+// LayoutCell is one node of a tmux window layout: either a pane, or a split
+// whose children divide its area. Positions and sizes are in character cells;
+// the one-cell borders tmux draws between panes lie outside every child.
+type LayoutCell struct {
+	X        int          `json:"x"`
+	Y        int          `json:"y"`
+	Cols     int          `json:"cols"`
+	Rows     int          `json:"rows"`
+	Pane     string       `json:"pane,omitempty"`  // tmux pane ID, e.g. "%3"; leaves only
+	Split    string       `json:"split,omitempty"` // SplitHorizontal or SplitVertical; splits only
+	Children []LayoutCell `json:"children,omitempty"`
+}
+
+// Panes returns the layout's leaves in the order tmux lists them.
+func (c LayoutCell) Panes() []LayoutCell {
+	if c.Split == "" {
+		return []LayoutCell{c}
+	}
+	var panes []LayoutCell
+	for _, child := range c.Children {
+		panes = append(panes, child.Panes()...)
+	}
+	return panes
+}
+
+// ParseWindowLayout parses a #{window_layout} string such as
+// "b25d,159x40,0,0{79x40,0,0,1,79x40,80,0,2}".
+func ParseWindowLayout(s string) (LayoutCell, error) {
+	p := layoutParser{s: s}
+	// Skip the checksum.
+	for p.pos < len(s) && s[p.pos] != ',' {
+		p.pos++
+	}
+	if !p.consume(',') {
+		return LayoutCell{}, fmt.Errorf("invalid layout %q: missing checksum", s)
+	}
+	cell, err := p.cell()
+	if err != nil {
+		return LayoutCell{}, fmt.Errorf("invalid layout %q: %w", s, err)
+	}
+	if p.pos != len(s) {
+		return LayoutCell{}, fmt.Errorf("invalid layout %q: trailing data at %d", s, p.pos)
+	}
+	return cell, nil
+}
+
+type layoutParser struct {
+	s   string
+	pos int
+}
+
+// cell parses "WxH,X,Y" followed by ",ID" for a pane, or a bracketed list
+// of cells for a split: "{...}" side by side, "[...]" stacked.
+func (p *layoutParser) cell() (LayoutCell, error) {
+	var c LayoutCell
+	var err error
+	if c.Cols, err = p.number(); err != nil {
+		return c, err
+	}
+	if !p.consume('x') {
+		return c, fmt.Errorf("expected 'x' at %d", p.pos)
+	}
+	if c.Rows, err = p.number(); err != nil {
+		return c, err
+	}
+	for _, v := range []*int{&c.X, &c.Y} {
+		if !p.consume(',') {
+			return c, fmt.Errorf("expected ',' at %d", p.pos)
+		}
+		if *v, err = p.number(); err != nil {
+			return c, err
+		}
+	}
+
+	var end byte
+	switch {
+	case p.consume('{'):
+		c.Split, end = SplitHorizontal, '}'
+	case p.consume('['):
+		c.Split, end = SplitVertical, ']'
+	case p.consume(','):
+		id, err := p.number()
+		if err != nil {
+			return c, err
+		}
+		c.Pane = "%" + strconv.Itoa(id)
+		return c, nil
+	default:
+		return c, fmt.Errorf("expected pane ID or split at %d", p.pos)
+	}
+	for {
+		child, err := p.cell()
+		if err != nil {
+			return c, err
+		}
+		c.Children = append(c.Children, child)
+		if p.consume(end) {
+			return c, nil
+		}
+		if !p.consume(',') {
+			return c, fmt.Errorf("expected ',' or %q at %d", end, p.pos)
+		}
+	}
+}
+
+func (p *layoutParser) number() (int, error) {
+	start := p.pos
+	for p.pos < len(p.s) && p.s[p.pos] >= '0' && p.s[p.pos] <= '9' {
+		p.pos++
+	}
+	if start == p.pos {
+		return 0, fmt.Errorf("expected number at %d", start)
+	}
+	return strconv.Atoi(p.s[start:p.pos])
+}
+
+func (p *layoutParser) consume(b byte) bool {
+	if p.pos < len(p.s) && p.s[p.pos] == b {
+		p.pos++
+		return true
+	}
+	return false
+}
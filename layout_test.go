@@ -0,0 +1,49 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseWindowLayout(t *testing.T) {
+	got, err := ParseWindowLayout("c2e1,159x40,0,0{79x40,0,0,1,79x40,80,0[79x20,80,0,2,79x19,80,21,13]}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := LayoutCell{Cols: 159, Rows: 40, Split: SplitHorizontal, Children: []LayoutCell{
+		{Cols: 79, Rows: 40, Pane: "%1"},
+		{X: 80, Cols: 79, Rows: 40, Split: SplitVertical, Children: []LayoutCell{
+			{X: 80, Cols: 79, Rows: 20, Pane: "%2"},
+			{X: 80, Y: 21, Cols: 79, Rows: 19, Pane: "%13"},
+		}},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v\nwant %+v", got, want)
+	}
+
+	var ids []string
+	for _, p := range got.Panes() {
+		ids = append(ids, p.Pane)
+	}
+	if !reflect.DeepEqual(ids, []string{"%1", "%2", "%13"}) {
+		t.Fatalf("panes %v", ids)
+	}
+}
+
+func TestParseWindowLayoutSinglePane(t *testing.T) {
+	got, err := ParseWindowLayout("b25f,80x24,0,0,0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (LayoutCell{Cols: 80, Rows: 24, Pane: "%0"}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestParseWindowLayoutInvalid(t *testing.T) {
+	for _, s := range []string{"", "80x24,0,0,0", "b25f,80x24,0,0", "b25f,80x24,0,0{80x24,0,0,1", "b25f,80x24,0,0,1,"} {
+		if _, err := ParseWindowLayout(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
@@ -26,8 +26,16 @@ func main() {
 	logger.Info("starting c3",
 		"listen_addr", cfg.ListenAddr,
 		"ring_buffer_size", cfg.RingBufferSize,
+		"auth_enabled", cfg.AuthSecret != "" || cfg.AuthViewSecret != "" || len(cfg.AuthTokens) > 0,
 	)
 
+	// Teeing into a pane's existing pipe needs a tee that keeps writing to
+	// the pipe after c3's end goes away.
+	if cfg.TmuxBackend == TmuxBackendPipePane && cfg.PipePaneConflict == PipeConflictTee && !teeSupportsOutputErrors() {
+		logger.Warn("tee does not support -p, falling back to --pipe-pane-conflict=refuse")
+		cfg.PipePaneConflict = PipeConflictRefuse
+	}
+
 	sm := NewSessionManager(cfg, logger)
 	defer sm.CloseAll()
 
@@ -37,21 +45,28 @@ func main() {
 		logger.Info("default session created", "target", cfg.TmuxTarget)
 	}
 
-	// File indexer — scans home directory in background
-	homeDir, _ := os.UserHomeDir()
-	if homeDir == "" {
-		homeDir = "/"
-	}
-	indexer := NewFileIndexer([]string{homeDir, "/tmp"}, 30*time.Second, logger)
+	// File indexer — scans the file browser's sandbox roots in background
+	indexer := NewFileIndexer(cfg.AllowedRoots, 30*time.Second, logger)
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	go indexer.Run(ctx)
 
-	mux := NewServer(cfg, sm, indexer, logger)
+	// Audit log — optional, but fatal if configured and unwritable
+	var audit *AuditLog
+	if cfg.AuditLogPath != "" {
+		audit, err = NewAuditLog(cfg.AuditLogPath, cfg.AuditMaxSize, cfg.AuditMaxFiles, logger)
+		if err != nil {
+			logger.Error("audit log error", "error", err)
+			os.Exit(1)
+		}
+		defer audit.Close()
+	}
+
+	handler := NewServer(cfg, sm, indexer, audit, logger)
 
 	server := &http.Server{
 		Addr:    cfg.ListenAddr,
-		Handler: mux,
+		Handler: handler,
 	}
 
 	// Graceful shutdown
@@ -66,8 +81,22 @@ func main() {
 		server.Close()
 	}()
 
-	logger.Info("listening", "addr", cfg.ListenAddr)
-	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+	certFile, keyFile := cfg.TLSCert, cfg.TLSKey
+	if cfg.TLSSelfSigned {
+		certFile, keyFile, err = EnsureSelfSignedCert(cfg.TLSDir, logger)
+		if err != nil {
+			logger.Error("tls error", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	logger.Info("listening", "addr", cfg.ListenAddr, "tls", certFile != "")
+	if certFile != "" {
+		err = server.ListenAndServeTLS(certFile, keyFile)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
 		logger.Error("server error", "error", err)
 		os.Exit(1)
 	}
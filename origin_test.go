@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestOriginCheckerAllowed(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	o := NewOriginChecker([]string{"*.ts.net", "https://c3.example.com"}, logger)
+
+	tests := []struct {
+		name    string
+		origin  string
+		fetch   string
+		allowed bool
+	}{
+		{"no origin (curl)", "", "", true},
+		{"no origin, same-origin fetch", "", "same-origin", true},
+		{"no origin, cross-site fetch", "", "cross-site", false},
+		{"same host", "http://vps:8080", "", true},
+		{"same host, different case", "http://VPS:8080", "", true},
+		{"host pattern", "http://box.tail1234.ts.net", "", true},
+		{"scheme pattern", "https://c3.example.com", "", true},
+		{"scheme pattern, wrong scheme", "http://c3.example.com", "", false},
+		{"foreign origin", "https://evil.example", "", false},
+		{"opaque origin", "null", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "http://vps:8080/api/kill-window", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.fetch != "" {
+				r.Header.Set("Sec-Fetch-Site", tt.fetch)
+			}
+			if got := o.Allowed(r); got != tt.allowed {
+				t.Errorf("Allowed() = %v, want %v", got, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestOriginCheckerProtect(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	o := NewOriginChecker(nil, logger)
+	called := false
+	h := o.Protect(func(w http.ResponseWriter, r *http.Request) { called = true })
+
+	r := httptest.NewRequest("POST", "http://vps:8080/api/rename", nil)
+	r.Header.Set("Origin", "https://evil.example")
+	rec := httptest.NewRecorder()
+	h(rec, r)
+	if rec.Code != http.StatusForbidden || called {
+		t.Fatalf("expected 403 without calling handler, got %d (called=%v)", rec.Code, called)
+	}
+}
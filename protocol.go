@@ -1,7 +1,9 @@
 package main
 
 import (
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 )
 
@@ -9,8 +11,27 @@ import (
 
 type HelloMsg struct {
 	Type       string `json:"type"`
-	ReplayMode string `json:"replayMode"`
+	ReplayMode string `json:"replayMode"` // "tail", "full", or "resume"
 	TailSize   int    `json:"tailSize,omitempty"`
+	Offset     int64  `json:"offset,omitempty"` // resume: next ring offset the client needs
+	Epoch      int64  `json:"epoch,omitempty"`  // resume: epoch the offset belongs to
+	Binary     bool   `json:"binary,omitempty"` // use binary frames for output and input
+	Since      int64  `json:"since,omitempty"`  // full: Unix ms; replay may start at the last checkpoint before it
+
+	// Optional caps on live output. Above them the client is sent periodic
+	// screen updates instead of the raw stream until output slows down.
+	MaxBytesPerSec  int `json:"maxBytesPerSec,omitempty"`
+	MaxFramesPerSec int `json:"maxFramesPerSec,omitempty"`
+
+	// Window connections: resume points keyed by pane ID. Panes without
+	// one start from a snapshot.
+	Panes map[string]PaneOffset `json:"panes,omitempty"`
+}
+
+// PaneOffset is where a pane's stream left off on a window connection.
+type PaneOffset struct {
+	Offset int64 `json:"offset"`
+	Epoch  int64 `json:"epoch"`
 }
 
 type InputMsg struct {
@@ -27,8 +48,20 @@ type ResizeMsg struct {
 // Server -> Client messages
 
 type OutputMsg struct {
-	Type string `json:"type"`
-	Data string `json:"data"` // base64-encoded
+	Type   string `json:"type"`
+	Data   string `json:"data"`             // base64-encoded
+	Offset *int64 `json:"offset,omitempty"` // ring offset of the first byte; absent for snapshots
+}
+
+// ReplayMsg precedes the replay of a resume request and tells the client
+// whether it is receiving only the missing bytes ("resume") or a fresh
+// snapshot it should render from a reset terminal ("snapshot"). A full
+// replay with Since set is preceded by one with mode "checkpoint" when it
+// starts from a checkpoint rather than the oldest byte.
+type ReplayMsg struct {
+	Type   string `json:"type"`
+	Mode   string `json:"mode"`
+	Offset int64  `json:"offset"` // resume: offset the stream continues from
 }
 
 type ErrorMsg struct {
@@ -37,11 +70,92 @@ type ErrorMsg struct {
 }
 
 type StatusMsg struct {
-	Type      string `json:"type"`
-	PaneState string `json:"paneState"` // "connected", "missing"
-	Epoch     int64  `json:"epoch"`
-	Cols      int    `json:"cols,omitempty"`
-	Rows      int    `json:"rows,omitempty"`
+	Type         string `json:"type"`
+	PaneState    string `json:"paneState"` // "connected", "missing"
+	Epoch        int64  `json:"epoch"`
+	Cols         int    `json:"cols,omitempty"`
+	Rows         int    `json:"rows,omitempty"`
+	Role         string `json:"role,omitempty"`         // "control" or "view"; sent on the initial status only
+	ResizePolicy string `json:"resizePolicy,omitempty"` // set when the client's resizes are applied to the pane; initial status only
+	Truncated    bool   `json:"truncated,omitempty"`    // the client fell behind; output was skipped and a snapshot follows
+	Throttled    bool   `json:"throttled,omitempty"`    // over the client's rate limit; screen updates follow until the stream resumes
+}
+
+// PaneMsg wraps a message for one pane of a window connection, in either
+// direction. Msg is any message of the single-pane protocol.
+type PaneMsg struct {
+	Type string          `json:"type"`
+	Pane string          `json:"pane"` // tmux pane ID, e.g. "%3"
+	Msg  json.RawMessage `json:"msg"`
+}
+
+// LayoutMsg tells a window connection how the window is split. It is sent
+// before the streams of the panes it lists, and again whenever the layout,
+// the set of panes or the active pane changes.
+type LayoutMsg struct {
+	Type   string       `json:"type"`
+	Window string       `json:"window"` // "session:window"
+	Layout LayoutCell   `json:"layout"`
+	Panes  []WindowPane `json:"panes"`
+}
+
+// Binary framing, negotiated with HelloMsg.Binary. Output and input travel as
+// binary WebSocket messages of one frame-type byte followed by the payload;
+// control messages stay JSON text in both modes.
+const (
+	FrameOutput byte = 0x01 // [type][offset int64 big-endian, -1 for snapshots][data]
+	FrameInput  byte = 0x02 // [type][data]
+	FramePane   byte = 0x03 // [type][pane ID length][pane ID][inner frame]; window connections
+)
+
+// noOffset marks output that is not from the ring buffer (snapshots).
+const noOffset int64 = -1
+
+// EncodeOutputFrame builds a binary output frame.
+func EncodeOutputFrame(offset int64, data []byte) []byte {
+	frame := make([]byte, 9+len(data))
+	frame[0] = FrameOutput
+	binary.BigEndian.PutUint64(frame[1:9], uint64(offset))
+	copy(frame[9:], data)
+	return frame
+}
+
+// DecodeOutputFrame parses a binary output frame.
+func DecodeOutputFrame(frame []byte) (int64, []byte, error) {
+	if len(frame) < 9 || frame[0] != FrameOutput {
+		return 0, nil, errors.New("not an output frame")
+	}
+	return int64(binary.BigEndian.Uint64(frame[1:9])), frame[9:], nil
+}
+
+// EncodeInputFrame builds a binary input frame.
+func EncodeInputFrame(data []byte) []byte {
+	return append([]byte{FrameInput}, data...)
+}
+
+// DecodeInputFrame parses a binary input frame.
+func DecodeInputFrame(frame []byte) ([]byte, error) {
+	if len(frame) < 1 || frame[0] != FrameInput {
+		return nil, errors.New("not an input frame")
+	}
+	return frame[1:], nil
+}
+
+// EncodePaneFrame wraps a binary frame for one pane of a window connection.
+func EncodePaneFrame(pane string, inner []byte) []byte {
+	frame := make([]byte, 0, 2+len(pane)+len(inner))
+	frame = append(frame, FramePane, byte(len(pane)))
+	frame = append(frame, pane...)
+	return append(frame, inner...)
+}
+
+// DecodePaneFrame unwraps a binary pane frame.
+func DecodePaneFrame(frame []byte) (string, []byte, error) {
+	if len(frame) < 2 || frame[0] != FramePane || len(frame) < 2+int(frame[1]) {
+		return "", nil, errors.New("not a pane frame")
+	}
+	n := 2 + int(frame[1])
+	return string(frame[2:n]), frame[n:], nil
 }
 
 // ParseClientMessage parses a raw JSON message from a client into the appropriate type.
@@ -72,6 +186,12 @@ func ParseClientMessage(raw []byte) (any, error) {
 			return nil, err
 		}
 		return &msg, nil
+	case "pane":
+		var msg PaneMsg
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			return nil, err
+		}
+		return &msg, nil
 	default:
 		return nil, fmt.Errorf("unknown message type: %q", base.Type)
 	}
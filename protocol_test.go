@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBinaryFrames(t *testing.T) {
+	frame := EncodeOutputFrame(1<<40, []byte("\x1b[2Jhello"))
+	if len(frame) != 9+len("\x1b[2Jhello") {
+		t.Fatalf("unexpected frame length %d", len(frame))
+	}
+	offset, data, err := DecodeOutputFrame(frame)
+	if err != nil || offset != 1<<40 || string(data) != "\x1b[2Jhello" {
+		t.Fatalf("round trip failed: %d %q %v", offset, data, err)
+	}
+
+	// Snapshots carry no ring offset.
+	if offset, _, _ := DecodeOutputFrame(EncodeOutputFrame(noOffset, nil)); offset != noOffset {
+		t.Fatalf("expected noOffset, got %d", offset)
+	}
+
+	input, err := DecodeInputFrame(EncodeInputFrame([]byte{0x03, 0x00, 0xff}))
+	if err != nil || !bytes.Equal(input, []byte{0x03, 0x00, 0xff}) {
+		t.Fatalf("input round trip failed: %v %v", input, err)
+	}
+
+	if _, _, err := DecodeOutputFrame([]byte{FrameOutput, 0, 0}); err == nil {
+		t.Error("expected short output frame to be rejected")
+	}
+	if _, err := DecodeInputFrame(frame); err == nil {
+		t.Error("expected output frame to be rejected as input")
+	}
+}
+
+func TestPaneFrames(t *testing.T) {
+	inner := EncodeInputFrame([]byte("ls\r"))
+	pane, data, err := DecodePaneFrame(EncodePaneFrame("%12", inner))
+	if err != nil || pane != "%12" || !bytes.Equal(data, inner) {
+		t.Fatalf("round trip failed: %q %q %v", pane, data, err)
+	}
+	if _, _, err := DecodePaneFrame([]byte{FramePane, 5, '%', '1'}); err == nil {
+		t.Error("expected truncated pane ID to be rejected")
+	}
+	if _, _, err := DecodePaneFrame(inner); err == nil {
+		t.Error("expected input frame to be rejected as a pane frame")
+	}
+}
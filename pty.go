@@ -10,10 +10,41 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"golang.org/x/sys/unix"
 )
 
+// PaneIO carries a tmux pane's output into the ring buffer and keystrokes
+// back to the pane. PTYManager implements it with pipe-pane, ControlPane with
+// a shared tmux control-mode connection.
+type PaneIO interface {
+	Target() string
+	Epoch() int64
+	// Reattach (re)connects to the pane, whose PTY is now at ttyPath, and
+	// starts a new epoch.
+	Reattach(ttyPath string) error
+	Close()
+	WriteInput(data []byte)
+}
+
+// What PTYManager does when a pane already has a pipe-pane, selected with
+// --pipe-pane-conflict.
+const (
+	// PipeConflictRefuse leaves the existing pipe alone and does not attach.
+	PipeConflictRefuse = "refuse"
+	// PipeConflictTee replaces the pipe with one that tees into both c3 and
+	// the command in the pane's @c3-user-pipe option, and restores that
+	// command on close.
+	PipeConflictTee = "tee"
+)
+
+// teeSupportsOutputErrors reports whether the installed tee accepts -p, so
+// that it carries on writing to its other outputs once one of them breaks.
+func teeSupportsOutputErrors() bool {
+	return exec.Command("tee", "-p", os.DevNull).Run() == nil
+}
+
 // PTYManager manages terminal I/O for a tmux pane.
 //
 // Reading uses `tmux pipe-pane` piping through a FIFO to capture the raw byte
@@ -22,32 +53,36 @@ import (
 //
 // Writing uses direct PTY slave writes to inject input bytes.
 //
-// Resize uses ioctl on the PTY slave fd.
+// The pane's size belongs to tmux, which overrides any ioctl on the PTY;
+// client-driven resizes go through tmux instead (see Sizer).
 type PTYManager struct {
 	tmuxTarget string
+	conflict   string // PipeConflictRefuse or PipeConflictTee
 	ring       *RingBuffer
 	writeCh    chan []byte
-	resizeCh   chan [2]uint16 // [cols, rows]
 	logger     *slog.Logger
 
-	// onOutput is called with each chunk of PTY output data.
-	// Set before calling Open.
-	onOutput func(data []byte)
+	// onOutput is called with each chunk of PTY output data and the ring
+	// offset it was written at. Set before calling Open.
+	onOutput func(offset int64, data []byte)
 
 	mu       sync.Mutex
-	ptyFile  *os.File // PTY slave fd for writes and resize
+	ptyFile  *os.File // PTY slave fd for writes
 	fifoPath string   // path to the FIFO for pipe-pane output
 	fifoFile *os.File // read end of the FIFO
 	epoch    int64
 	stopCh   chan struct{}
+	piping   bool   // our pipe-pane is installed
+	userPipe string // the user's pipe command to restore on close
 }
 
-func NewPTYManager(tmuxTarget string, ring *RingBuffer, logger *slog.Logger) *PTYManager {
+func NewPTYManager(tmuxTarget, conflict string, ring *RingBuffer, logger *slog.Logger) *PTYManager {
 	return &PTYManager{
 		tmuxTarget: tmuxTarget,
+		conflict:   conflict,
+		epoch:      epochBase,
 		ring:       ring,
 		writeCh:    make(chan []byte, 64),
-		resizeCh:   make(chan [2]uint16, 8),
 		logger:     logger,
 	}
 }
@@ -66,17 +101,36 @@ func (p *PTYManager) Target() string {
 	return p.tmuxTarget
 }
 
+// epochBase seeds every PTY epoch with the process start time, so an epoch
+// seen by a client before a c3 restart never matches one issued after it.
+var epochBase = time.Now().UnixMilli()
+
 // Epoch returns the current session epoch.
 func (p *PTYManager) Epoch() int64 {
 	return atomic.LoadInt64(&p.epoch)
 }
 
-// Open attaches to the PTY for writes/resize and starts tmux pipe-pane for reads.
+// Open attaches to the PTY for writes and starts tmux pipe-pane for reads.
 func (p *PTYManager) Open(ttyPath string) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	// Open PTY slave for writing input and resize
+	// pipe-pane replaces any pipe already running, such as a logging
+	// plugin's, so check before taking over.
+	piped, userPipe, err := PanePipe(p.tmuxTarget)
+	if err != nil {
+		return err
+	}
+	if piped && (p.conflict != PipeConflictTee || userPipe == "") {
+		p.logger.Warn("pane already has a pipe-pane, not attaching; use --tmux-backend=control, " +
+			"or --pipe-pane-conflict=tee with the pipe's command in the pane's @c3-user-pipe option")
+		return fmt.Errorf("pane %s already has a pipe-pane", p.tmuxTarget)
+	}
+	if !piped {
+		userPipe = ""
+	}
+
+	// Open PTY slave for writing input
 	f, err := os.OpenFile(ttyPath, os.O_WRONLY, 0)
 	if err != nil {
 		return fmt.Errorf("open pty slave: %w", err)
@@ -100,21 +154,28 @@ func (p *PTYManager) Open(ttyPath string) error {
 
 	p.logger.Info("pty opened", "path", ttyPath, "fifo", fifoPath, "epoch", p.Epoch())
 
-	// Start tmux pipe-pane writing to our FIFO.
+	// Start tmux pipe-pane writing to our FIFO. When taking over the
+	// user's pipe, tee into it as well. -p, which GNU tee supports but BSD
+	// and BusyBox tee do not, keeps their command fed if our end of the
+	// FIFO goes away; main only allows PipeConflictTee where it works.
 	pipeCmd := fmt.Sprintf("cat > %s", fifoPath)
+	if userPipe != "" {
+		pipeCmd = fmt.Sprintf("tee -p %s | (%s)", fifoPath, userPipe)
+		p.logger.Info("teeing into the pane's existing pipe", "user_pipe", userPipe)
+	}
 	cmd := exec.Command("tmux", "pipe-pane", "-t", p.tmuxTarget, pipeCmd)
 	if err := cmd.Run(); err != nil {
 		f.Close()
 		os.Remove(fifoPath)
 		return fmt.Errorf("tmux pipe-pane: %w", err)
 	}
+	p.piping, p.userPipe = true, userPipe
 
 	// Open FIFO for reading in a goroutine. The open blocks in O_RDONLY mode
 	// until tmux's cat process opens the write end (which happens when the
 	// pane first produces output). This is correct POSIX FIFO behavior.
 	go p.fifoReadGoroutine(fifoPath, p.stopCh)
 	go p.writeLoop(f, p.stopCh)
-	go p.resizeLoop(f, p.stopCh)
 
 	return nil
 }
@@ -132,8 +193,16 @@ func (p *PTYManager) closeLocked() {
 		p.stopCh = nil
 	}
 
-	// Stop pipe-pane in tmux
-	exec.Command("tmux", "pipe-pane", "-t", p.tmuxTarget).Run()
+	// Stop our pipe-pane in tmux, handing the pane back to the user's pipe
+	// if we took it over. A pipe we did not install is left running.
+	if p.piping {
+		args := []string{"pipe-pane", "-t", p.tmuxTarget}
+		if p.userPipe != "" {
+			args = append(args, p.userPipe)
+		}
+		exec.Command("tmux", args...).Run()
+		p.piping, p.userPipe = false, ""
+	}
 
 	if p.fifoFile != nil {
 		p.fifoFile.Close()
@@ -164,14 +233,6 @@ func (p *PTYManager) WriteInput(data []byte) {
 	}
 }
 
-// Resize sends new dimensions to the PTY.
-func (p *PTYManager) Resize(cols, rows uint16) {
-	select {
-	case p.resizeCh <- [2]uint16{cols, rows}:
-	default:
-	}
-}
-
 func (p *PTYManager) fifoReadGoroutine(fifoPath string, stop chan struct{}) {
 	// Open in blocking mode — blocks until a writer (tmux's cat) connects.
 	fifoFile, err := os.OpenFile(fifoPath, os.O_RDONLY, 0)
@@ -206,9 +267,9 @@ func (p *PTYManager) readLoop(r io.Reader, stop chan struct{}) {
 		if n > 0 {
 			data := make([]byte, n)
 			copy(data, buf[:n])
-			p.ring.Write(data)
+			offset := p.ring.Write(data)
 			if p.onOutput != nil {
-				p.onOutput(data)
+				p.onOutput(offset, data)
 			}
 		}
 		if err != nil {
@@ -253,23 +314,3 @@ func (p *PTYManager) writeLoop(f *os.File, stop chan struct{}) {
 		}
 	}
 }
-
-func (p *PTYManager) resizeLoop(f *os.File, stop chan struct{}) {
-	for {
-		select {
-		case <-stop:
-			return
-		case dims := <-p.resizeCh:
-			ws := &unix.Winsize{
-				Col: dims[0],
-				Row: dims[1],
-			}
-			err := unix.IoctlSetWinsize(int(f.Fd()), unix.TIOCSWINSZ, ws)
-			if err != nil {
-				p.logger.Error("pty resize error", "error", err, "cols", dims[0], "rows", dims[1])
-			} else {
-				p.logger.Info("pty resized", "cols", dims[0], "rows", dims[1])
-			}
-		}
-	}
-}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimit is a token bucket over bytes and frames per second, negotiated
+// by a client in its hello. Either cap may be zero to leave it unlimited.
+// Each bucket holds one second's worth, so a client that has been quiet can
+// take a burst of that size.
+type rateLimit struct {
+	mu        sync.Mutex
+	maxBytes  float64
+	maxFrames float64
+	bytes     float64
+	frames    float64
+	last      time.Time
+}
+
+// newRateLimit returns nil when neither cap is set.
+func newRateLimit(maxBytesPerSec, maxFramesPerSec int) *rateLimit {
+	if maxBytesPerSec <= 0 && maxFramesPerSec <= 0 {
+		return nil
+	}
+	return &rateLimit{
+		maxBytes:  float64(max(maxBytesPerSec, 0)),
+		maxFrames: float64(max(maxFramesPerSec, 0)),
+		bytes:     float64(max(maxBytesPerSec, 0)),
+		frames:    float64(max(maxFramesPerSec, 0)),
+		last:      time.Now(),
+	}
+}
+
+// allow reports whether a frame of n bytes may be sent now, and if so takes
+// it from the buckets. A frame larger than a full bucket is let through once
+// the bucket is full, leaving it in debt.
+func (r *rateLimit) allow(n int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	elapsed := now.Sub(r.last).Seconds()
+	r.last = now
+	r.bytes = min(r.bytes+elapsed*r.maxBytes, r.maxBytes)
+	r.frames = min(r.frames+elapsed*r.maxFrames, r.maxFrames)
+
+	if r.maxBytes > 0 && r.bytes < min(float64(n), r.maxBytes) {
+		return false
+	}
+	if r.maxFrames > 0 && r.frames < 1 {
+		return false
+	}
+	if r.maxBytes > 0 {
+		r.bytes -= float64(n)
+	}
+	if r.maxFrames > 0 {
+		r.frames--
+	}
+	return true
+}
+
+// interval is how often a throttled client is sent a screen update: as often
+// as its frame cap allows, but no more than 20 times a second.
+func (r *rateLimit) interval() time.Duration {
+	if r.maxFrames <= 0 {
+		return 250 * time.Millisecond
+	}
+	return max(time.Duration(float64(time.Second)/r.maxFrames), 50*time.Millisecond)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimitBytes(t *testing.T) {
+	r := newRateLimit(1000, 0)
+	if !r.allow(600) || r.allow(600) {
+		t.Fatal("expected the first second's budget to admit exactly one 600-byte frame")
+	}
+	// Oversized frames pass once the bucket is full.
+	r.last = r.last.Add(-2 * time.Second)
+	if !r.allow(5000) || r.allow(1) {
+		t.Fatal("expected a full bucket to admit an oversized frame and then go into debt")
+	}
+}
+
+func TestRateLimitFrames(t *testing.T) {
+	r := newRateLimit(0, 2)
+	if !r.allow(1<<20) || !r.allow(1<<20) || r.allow(1) {
+		t.Fatal("expected two frames per second of any size")
+	}
+	r.last = r.last.Add(-600 * time.Millisecond)
+	if !r.allow(1) {
+		t.Fatal("expected a frame to be refilled after half a second")
+	}
+	if got := r.interval(); got != 500*time.Millisecond {
+		t.Fatalf("interval %v, want 500ms", got)
+	}
+}
+
+func TestRateLimitDisabled(t *testing.T) {
+	if r := newRateLimit(0, 0); r != nil {
+		t.Fatalf("expected no limit, got %+v", r)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Resize policies, selected with --resize-policy.
+const (
+	// ResizePolicyPane leaves the pane's size alone; clients match it.
+	ResizePolicyPane = "pane"
+	// ResizePolicySmallest sizes the pane to the smallest client, so it fits on
+	// every screen.
+	ResizePolicySmallest = "smallest"
+	// ResizePolicyLargest sizes the pane to the largest client.
+	ResizePolicyLargest = "largest"
+	// ResizePolicyRecent sizes the pane to the client that most recently typed or
+	// resized.
+	ResizePolicyRecent = "recent"
+)
+
+// Bounds on the size a client may ask for, so a bogus request cannot make
+// tmux allocate a huge pane or collapse it to nothing.
+const (
+	minClientCols, maxClientCols = 2, 1000
+	minClientRows, maxClientRows = 1, 500
+)
+
+// clientSize is the size a client asked for and when it was last active.
+type clientSize struct {
+	cols, rows int
+	active     time.Time
+}
+
+// Sizer applies client-requested sizes to a pane under a resize policy. It
+// records the pane's size before it first changes it and restores that size
+// once no client is asking for one. A nil *Sizer ignores every request, which
+// is how the pane policy is implemented.
+type Sizer struct {
+	policy   string
+	target   string
+	onResize func() // called after the pane is resized or restored
+	logger   *slog.Logger
+
+	mu       sync.Mutex
+	clients  map[string]*clientSize
+	original *PaneSize // nil while c3 has not resized the pane
+	cols     int       // size last applied
+	rows     int
+}
+
+// NewSizer returns nil for the pane policy.
+func NewSizer(policy, target string, onResize func(), logger *slog.Logger) *Sizer {
+	if policy == "" || policy == ResizePolicyPane {
+		return nil
+	}
+	return &Sizer{
+		policy:   policy,
+		target:   target,
+		onResize: onResize,
+		logger:   logger,
+		clients:  make(map[string]*clientSize),
+	}
+}
+
+// Policy returns the resize policy, ResizePolicyPane for a nil Sizer.
+func (s *Sizer) Policy() string {
+	if s == nil {
+		return ResizePolicyPane
+	}
+	return s.policy
+}
+
+// Request records the size a client's terminal can show.
+func (s *Sizer) Request(id string, cols, rows int) {
+	if s == nil || cols <= 0 || rows <= 0 {
+		return
+	}
+	cols, rows = clampClientSize(cols, rows)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients[id] = &clientSize{cols: cols, rows: rows, active: time.Now()}
+	s.updateLocked()
+}
+
+// Touch marks a client as active, which matters under ResizePolicyRecent.
+func (s *Sizer) Touch(id string) {
+	if s == nil || s.policy != ResizePolicyRecent {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.clients[id]; ok {
+		c.active = time.Now()
+		s.updateLocked()
+	}
+}
+
+// Leave forgets a client's size.
+func (s *Sizer) Leave(id string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.clients[id]; ok {
+		delete(s.clients, id)
+		s.updateLocked()
+	}
+}
+
+// Close restores the pane's original size.
+func (s *Sizer) Close() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.clients)
+	s.updateLocked()
+}
+
+// updateLocked resizes the pane to what the policy picks from the current
+// requests, or restores it when there are none.
+func (s *Sizer) updateLocked() {
+	cols, rows, ok := chooseSize(s.policy, s.clients)
+	if !ok {
+		if s.original == nil {
+			return
+		}
+		if err := RestorePaneSize(s.target, *s.original); err != nil {
+			s.logger.Warn("failed to restore pane size", "error", err)
+		} else {
+			s.logger.Info("pane size restored", "cols", s.original.Cols, "rows", s.original.Rows)
+		}
+		s.original, s.cols, s.rows = nil, 0, 0
+		s.onResize()
+		return
+	}
+	if cols == s.cols && rows == s.rows {
+		return
+	}
+	if s.original == nil {
+		orig, err := GetPaneSize(s.target)
+		if err != nil {
+			s.logger.Warn("failed to read pane size", "error", err)
+			return
+		}
+		s.original = &orig
+	}
+	if err := ResizePane(s.target, cols, rows); err != nil {
+		s.logger.Warn("failed to resize pane", "cols", cols, "rows", rows, "error", err)
+		return
+	}
+	s.cols, s.rows = cols, rows
+	s.logger.Info("pane resized for clients", "policy", s.policy, "cols", cols, "rows", rows)
+	s.onResize()
+}
+
+// clampClientSize limits a requested size to the bounds above.
+func clampClientSize(cols, rows int) (int, int) {
+	return min(max(cols, minClientCols), maxClientCols), min(max(rows, minClientRows), maxClientRows)
+}
+
+// chooseSize picks the size a policy gives the pane; ok is false when no
+// client has asked for one.
+func chooseSize(policy string, clients map[string]*clientSize) (cols, rows int, ok bool) {
+	var recent time.Time
+	for _, c := range clients {
+		switch {
+		case !ok:
+			cols, rows, recent = c.cols, c.rows, c.active
+		case policy == ResizePolicySmallest:
+			cols, rows = min(cols, c.cols), min(rows, c.rows)
+		case policy == ResizePolicyLargest:
+			cols, rows = max(cols, c.cols), max(rows, c.rows)
+		case policy == ResizePolicyRecent && c.active.After(recent):
+			cols, rows, recent = c.cols, c.rows, c.active
+		}
+		ok = true
+	}
+	return cols, rows, ok
+}
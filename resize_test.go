@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChooseSize(t *testing.T) {
+	now := time.Now()
+	clients := map[string]*clientSize{
+		"desktop": {cols: 200, rows: 50, active: now.Add(-time.Minute)},
+		"phone":   {cols: 60, rows: 40, active: now},
+		"tablet":  {cols: 120, rows: 30, active: now.Add(-time.Hour)},
+	}
+	tests := []struct {
+		policy     string
+		cols, rows int
+	}{
+		{ResizePolicySmallest, 60, 30},
+		{ResizePolicyLargest, 200, 50},
+		{ResizePolicyRecent, 60, 40},
+	}
+	for _, tt := range tests {
+		cols, rows, ok := chooseSize(tt.policy, clients)
+		if !ok || cols != tt.cols || rows != tt.rows {
+			t.Errorf("%s: got %dx%d (%v), want %dx%d", tt.policy, cols, rows, ok, tt.cols, tt.rows)
+		}
+	}
+
+	if _, _, ok := chooseSize(ResizePolicySmallest, nil); ok {
+		t.Error("expected no size without clients")
+	}
+}
+
+func TestClampClientSize(t *testing.T) {
+	tests := []struct{ cols, rows, wantCols, wantRows int }{
+		{80, 24, 80, 24},
+		{1, 1, 2, 1},
+		{100000, 100000, 1000, 500},
+	}
+	for _, tt := range tests {
+		if cols, rows := clampClientSize(tt.cols, tt.rows); cols != tt.wantCols || rows != tt.wantRows {
+			t.Errorf("%dx%d: got %dx%d, want %dx%d", tt.cols, tt.rows, cols, rows, tt.wantCols, tt.wantRows)
+		}
+	}
+}
+
+func TestSizerPanePolicy(t *testing.T) {
+	s := NewSizer(ResizePolicyPane, "x:0.0", nil, nil)
+	if s != nil {
+		t.Fatal("expected no sizer for the pane policy")
+	}
+	// A nil sizer ignores requests.
+	s.Request("c1", 80, 24)
+	s.Touch("c1")
+	s.Leave("c1")
+	s.Close()
+	if got := s.Policy(); got != ResizePolicyPane {
+		t.Fatalf("policy %q", got)
+	}
+}
@@ -8,11 +8,19 @@ import (
 // RingBuffer is a circular byte buffer with monotonically increasing write position.
 // The actual ring index is writePos % size. A reader at offset C is behind if
 // writePos - C > size (its data has been overwritten).
+//
+// With a RingStore attached, every write is also appended to disk, the
+// buffer is reloaded from it on startup, and reads older than what fits in
+// memory are served from the store.
 type RingBuffer struct {
 	mu       sync.Mutex
 	buf      []byte
 	size     int
 	writePos int64 // total bytes written (monotonically increasing)
+	start    int64 // oldest offset ever held in memory (non-zero after reload)
+	store    *RingStore
+
+	checkpoints []Checkpoint // ascending by offset and time
 }
 
 func NewRingBuffer(size int) *RingBuffer {
@@ -22,11 +30,38 @@ func NewRingBuffer(size int) *RingBuffer {
 	}
 }
 
-// Write appends data to the ring buffer.
-func (rb *RingBuffer) Write(data []byte) {
+// NewPersistentRingBuffer returns a ring buffer backed by store, with its
+// write position and in-memory contents restored from what is on disk.
+func NewPersistentRingBuffer(size int, store *RingStore) *RingBuffer {
+	rb := NewRingBuffer(size)
+	rb.store = store
+
+	oldest, end := store.Range()
+	data, at, err := store.ReadRange(max(oldest, end-int64(size)), end)
+	if err != nil {
+		store.logger.Error("ring store reload failed", "dir", store.dir, "error", err)
+		data, at = nil, end
+	}
+	rb.writePos = at
+	rb.start = at
+	rb.writeLocked(data)
+	return rb
+}
+
+// Write appends data to the ring buffer and returns the offset of its first byte.
+func (rb *RingBuffer) Write(data []byte) int64 {
 	rb.mu.Lock()
 	defer rb.mu.Unlock()
 
+	offset := rb.writePos
+	if rb.store != nil {
+		rb.store.Append(offset, data)
+	}
+	rb.writeLocked(data)
+	return offset
+}
+
+func (rb *RingBuffer) writeLocked(data []byte) {
 	for len(data) > 0 {
 		idx := int(rb.writePos % int64(rb.size))
 		n := copy(rb.buf[idx:], data)
@@ -42,10 +77,10 @@ func (rb *RingBuffer) WritePos() int64 {
 	return rb.writePos
 }
 
-// oldestOffset returns the offset of the oldest available byte (caller must hold mu).
+// oldestOffset returns the offset of the oldest byte in memory (caller must hold mu).
 func (rb *RingBuffer) oldestOffset() int64 {
-	if rb.writePos <= int64(rb.size) {
-		return 0
+	if rb.writePos-rb.start <= int64(rb.size) {
+		return rb.start
 	}
 	return rb.writePos - int64(rb.size)
 }
@@ -56,9 +91,12 @@ func (rb *RingBuffer) oldestOffset() int64 {
 // with the fast-forwarded offset.
 func (rb *RingBuffer) ReadFrom(offset int64, dst []byte) (int, int64, error) {
 	rb.mu.Lock()
-	defer rb.mu.Unlock()
-
 	oldest := rb.oldestOffset()
+	if offset < oldest && rb.store != nil {
+		rb.mu.Unlock()
+		return rb.readStored(offset, oldest, dst)
+	}
+	defer rb.mu.Unlock()
 
 	if offset < oldest {
 		return 0, oldest, fmt.Errorf("data overwritten: requested offset %d, oldest available %d", offset, oldest)
@@ -89,9 +127,52 @@ func (rb *RingBuffer) ReadFrom(offset int64, dst []byte) (int, int64, error) {
 	return read, offset + int64(read), nil
 }
 
+// readStored serves a ReadFrom for an offset that has left memory but may
+// still be on disk.
+func (rb *RingBuffer) readStored(offset, memOldest int64, dst []byte) (int, int64, error) {
+	if diskOldest, _ := rb.store.Range(); offset < diskOldest {
+		return 0, diskOldest, fmt.Errorf("data overwritten: requested offset %d, oldest available %d", offset, diskOldest)
+	}
+	data, at, err := rb.store.ReadRange(offset, min(memOldest, offset+int64(len(dst))))
+	if err != nil {
+		return 0, memOldest, fmt.Errorf("read ring store: %w", err)
+	}
+	if at != offset {
+		return 0, at, fmt.Errorf("data overwritten: requested offset %d, oldest available %d", offset, at)
+	}
+	n := copy(dst, data)
+	return n, offset + int64(n), nil
+}
+
+// prependStored extends data, which begins at start, backwards to from using
+// the disk store. It returns data unchanged if there is no store or the
+// older bytes cannot be read.
+func (rb *RingBuffer) prependStored(data []byte, start, from int64) ([]byte, int64) {
+	if rb.store == nil || from >= start {
+		return data, start
+	}
+	older, at, err := rb.store.ReadRange(from, start)
+	if err != nil {
+		rb.store.logger.Error("ring store read failed", "dir", rb.store.dir, "error", err)
+		return data, start
+	}
+	if len(older) == 0 || at+int64(len(older)) != start {
+		return data, start
+	}
+	return append(older, data...), at
+}
+
 // Tail returns the last n bytes from the buffer (or fewer if less data is available)
 // and the offset at which the returned data begins.
 func (rb *RingBuffer) Tail(n int) ([]byte, int64) {
+	data, start := rb.memTail(n)
+	if len(data) < n {
+		return rb.prependStored(data, start, start-int64(n-len(data)))
+	}
+	return data, start
+}
+
+func (rb *RingBuffer) memTail(n int) ([]byte, int64) {
 	rb.mu.Lock()
 	defer rb.mu.Unlock()
 
@@ -173,8 +254,22 @@ func (rb *RingBuffer) TailFromRedraw(maxSearch int) ([]byte, int64) {
 }
 
 // Snapshot returns the entire available buffer contents in write order
-// and the offset at which the data begins.
+// and the offset at which the data begins, including history on disk. It
+// holds all of it in memory at once; to send history to a client, use
+// ReadFrom from readableOffset instead.
 func (rb *RingBuffer) Snapshot() ([]byte, int64) {
+	data, start := rb.memSnapshot()
+	return rb.prependStored(data, start, 0)
+}
+
+// Close flushes and closes the disk store, if any.
+func (rb *RingBuffer) Close() {
+	if rb.store != nil {
+		rb.store.Close()
+	}
+}
+
+func (rb *RingBuffer) memSnapshot() ([]byte, int64) {
 	rb.mu.Lock()
 	defer rb.mu.Unlock()
 
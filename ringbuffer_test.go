@@ -2,7 +2,10 @@ package main
 
 import (
 	"bytes"
+	"log/slog"
+	"os"
 	"testing"
+	"time"
 )
 
 func TestRingBufferBasicWriteRead(t *testing.T) {
@@ -34,6 +37,9 @@ func TestRingBufferWrap(t *testing.T) {
 	}
 
 	// Oldest available should be offset 4 (20 - 16)
+	if rb.readableOffset() != 4 {
+		t.Fatalf("expected readableOffset 4, got %d", rb.readableOffset())
+	}
 	data, offset := rb.Snapshot()
 	if offset != 4 {
 		t.Fatalf("expected offset 4, got %d", offset)
@@ -143,3 +149,190 @@ func TestRingBufferEmpty(t *testing.T) {
 		t.Fatalf("expected empty tail, got %d bytes", len(data))
 	}
 }
+
+func TestRingBufferPersistentReload(t *testing.T) {
+	dir := t.TempDir()
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	store, err := OpenRingStore(dir, 1<<20, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb := NewPersistentRingBuffer(16, store)
+	rb.Write([]byte("0123456789"))
+	rb.Write([]byte("abcdefghij"))
+	rb.Close()
+
+	// Reopen: offsets continue and memory holds the newest bytes.
+	store, err = OpenRingStore(dir, 1<<20, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb = NewPersistentRingBuffer(16, store)
+	defer rb.Close()
+	if rb.WritePos() != 20 {
+		t.Fatalf("expected writePos 20 after reload, got %d", rb.WritePos())
+	}
+	rb.Write([]byte("XYZ"))
+
+	// Snapshot and Tail reach past what fits in memory.
+	data, offset := rb.Snapshot()
+	if offset != 0 || string(data) != "0123456789abcdefghijXYZ" {
+		t.Fatalf("unexpected snapshot %q at %d", data, offset)
+	}
+	data, offset = rb.Tail(20)
+	if offset != 3 || string(data) != "3456789abcdefghijXYZ" {
+		t.Fatalf("unexpected tail %q at %d", data, offset)
+	}
+
+	// ReadFrom serves old offsets from disk, then continues from memory.
+	if oldest := rb.readableOffset(); oldest != 0 {
+		t.Fatalf("expected oldest offset 0 on disk, got %d", oldest)
+	}
+	var got []byte
+	buf := make([]byte, 8)
+	for pos := int64(0); pos < rb.WritePos(); {
+		n, next, err := rb.ReadFrom(pos, buf)
+		if err != nil {
+			t.Fatalf("ReadFrom(%d): %v", pos, err)
+		}
+		got = append(got, buf[:n]...)
+		pos = next
+	}
+	if string(got) != "0123456789abcdefghijXYZ" {
+		t.Fatalf("unexpected ReadFrom result %q", got)
+	}
+}
+
+func TestRingStorePrunesOldSegments(t *testing.T) {
+	dir := t.TempDir()
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	store, err := OpenRingStore(dir, ringSegmentSize, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb := NewPersistentRingBuffer(64, store)
+	defer rb.Close()
+
+	chunk := bytes.Repeat([]byte("x"), 1024*1024)
+	for i := 0; i < 10; i++ {
+		rb.Write(chunk)
+	}
+
+	oldest, end := store.Range()
+	if end != 10*1024*1024 {
+		t.Fatalf("expected end 10MiB, got %d", end)
+	}
+	if oldest == 0 || end-oldest > 2*ringSegmentSize {
+		t.Fatalf("expected old segments pruned, range [%d, %d)", oldest, end)
+	}
+	if got := rb.readableOffset(); got != oldest {
+		t.Fatalf("expected readableOffset %d, got %d", oldest, got)
+	}
+	if _, next, err := rb.ReadFrom(0, make([]byte, 16)); err == nil || next != oldest {
+		t.Fatalf("expected overwritten error fast-forwarding to %d, got %d %v", oldest, next, err)
+	}
+}
+
+func TestRingBufferCheckpoints(t *testing.T) {
+	rb := NewRingBuffer(16)
+	base := time.Unix(1000, 0)
+
+	rb.Write([]byte("0123456789"))
+	rb.AddCheckpoint(Checkpoint{Offset: 4, Time: base, State: []byte("a")})
+	rb.AddCheckpoint(Checkpoint{Offset: 10, Time: base.Add(time.Minute), State: []byte("b")})
+
+	if _, ok := rb.CheckpointBefore(base.Add(-time.Second)); ok {
+		t.Fatal("expected no checkpoint before the first one")
+	}
+	if cp, ok := rb.CheckpointBefore(base.Add(30 * time.Second)); !ok || cp.Offset != 4 {
+		t.Fatalf("expected checkpoint at 4, got %+v %v", cp, ok)
+	}
+	if cp, ok := rb.CheckpointBefore(base.Add(time.Hour)); !ok || cp.Offset != 10 {
+		t.Fatalf("expected checkpoint at 10, got %+v %v", cp, ok)
+	}
+
+	// Once offset 4 is overwritten its checkpoint can no longer be used.
+	rb.Write([]byte("abcdefghijk"))
+	if _, ok := rb.CheckpointBefore(base.Add(30 * time.Second)); ok {
+		t.Fatal("expected overwritten checkpoint to be skipped")
+	}
+	rb.AddCheckpoint(Checkpoint{Offset: 21, Time: base.Add(2 * time.Minute)})
+	if len(rb.checkpoints) != 2 || rb.checkpoints[0].Offset != 10 {
+		t.Fatalf("expected overwritten checkpoint pruned, got %+v", rb.checkpoints)
+	}
+}
+
+func TestRingBufferCheckpointLimit(t *testing.T) {
+	rb := NewRingBuffer(1024 * 1024)
+	rb.Write(make([]byte, 1024*1024))
+	base := time.Unix(1000, 0)
+	for i := 0; i < 3*maxCheckpoints; i++ {
+		rb.AddCheckpoint(Checkpoint{Offset: int64(i * 1000), Time: base.Add(time.Duration(i) * time.Second)})
+	}
+
+	cps := rb.checkpoints
+	if len(cps) != maxCheckpoints {
+		t.Fatalf("expected %d checkpoints, got %d", maxCheckpoints, len(cps))
+	}
+	if cps[0].Offset != 0 || cps[len(cps)-1].Offset != int64(3*maxCheckpoints-1)*1000 {
+		t.Fatalf("expected oldest and newest kept, got %d..%d", cps[0].Offset, cps[len(cps)-1].Offset)
+	}
+	for i := 1; i < len(cps); i++ {
+		if gap := cps[i].Offset - cps[i-1].Offset; gap > 8000 {
+			t.Fatalf("checkpoints unevenly thinned: gap of %d at %d", gap, i)
+		}
+	}
+}
+
+func TestRingBufferCheckpointBytes(t *testing.T) {
+	rb := NewRingBuffer(1024 * 1024)
+	rb.Write(make([]byte, 1024*1024))
+	state := make([]byte, maxCheckpointBytes/4+1)
+	for i := 0; i < 10; i++ {
+		rb.AddCheckpoint(Checkpoint{Offset: int64(i * 1000), State: state})
+	}
+
+	cps := rb.checkpoints
+	if len(cps) != 3 {
+		t.Fatalf("expected 3 checkpoints within %d bytes, got %d", maxCheckpointBytes, len(cps))
+	}
+	if cps[len(cps)-1].Offset != 9000 {
+		t.Fatalf("expected newest kept, got %d", cps[len(cps)-1].Offset)
+	}
+
+	// A single checkpoint over the budget is still kept.
+	rb.AddCheckpoint(Checkpoint{Offset: 10000, State: make([]byte, maxCheckpointBytes+1)})
+	if len(rb.checkpoints) != 1 || rb.checkpoints[0].Offset != 10000 {
+		t.Fatalf("expected only the oversized checkpoint, got %d", len(rb.checkpoints))
+	}
+}
+
+func TestCheckpointerTriggers(t *testing.T) {
+	rb := NewRingBuffer(1024)
+	vt := NewVTerm(20, 4, 0)
+	cp := newCheckpointer(vt, rb, 0, 10)
+
+	write := func(s string) {
+		offset := rb.Write([]byte(s))
+		vt.Write(offset, []byte(s))
+		cp.observe(rb.WritePos())
+	}
+	write("hello")
+	if len(rb.checkpoints) != 0 {
+		t.Fatal("checkpoint taken before the byte threshold")
+	}
+	write(" world\r\n")
+	if len(rb.checkpoints) != 1 || rb.checkpoints[0].Offset != 13 {
+		t.Fatalf("expected a checkpoint at 13, got %+v", rb.checkpoints)
+	}
+
+	// Replaying the checkpoint and the bytes after it reproduces the screen.
+	write("more")
+	got, _ := rb.CheckpointBefore(time.Now())
+	dst := NewVTerm(20, 4, 0)
+	dst.Write(0, got.State)
+	data, _ := rb.Snapshot()
+	dst.Write(0, data[got.Offset:])
+	vtAssertEqual(t, vt, dst)
+}
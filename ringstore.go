@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	ringSegmentSize   = 4 * 1024 * 1024
+	ringSegmentSuffix = ".seg"
+)
+
+// ringSegment is one file of the append log. Its name is the writePos offset
+// of its first byte, so offsets survive restarts unchanged.
+type ringSegment struct {
+	start int64
+	size  int64
+	path  string
+}
+
+// RingStore is a segmented append log that backs a RingBuffer on disk. It
+// keeps at most maxBytes (rounded up to whole segments) and drops the oldest
+// segments beyond that.
+type RingStore struct {
+	dir      string
+	maxBytes int64
+	logger   *slog.Logger
+
+	mu       sync.Mutex
+	segments []ringSegment
+	f        *os.File // open for append on the last segment
+	failed   bool
+	closed   bool
+}
+
+// OpenRingStore opens (or creates) the append log in dir.
+func OpenRingStore(dir string, maxBytes int64, logger *slog.Logger) (*RingStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("create ring store dir: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read ring store dir: %w", err)
+	}
+
+	s := &RingStore{dir: dir, maxBytes: maxBytes, logger: logger}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ringSegmentSuffix)
+		if !ok || e.IsDir() {
+			continue
+		}
+		start, err := strconv.ParseInt(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		s.segments = append(s.segments, ringSegment{start: start, size: info.Size(), path: filepath.Join(dir, e.Name())})
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].start < s.segments[j].start })
+
+	// Only the contiguous run ending at the newest segment is usable; a gap
+	// means a segment was lost or truncated.
+	for i := len(s.segments) - 1; i > 0; i-- {
+		if s.segments[i-1].start+s.segments[i-1].size != s.segments[i].start {
+			logger.Warn("ring store gap, discarding older segments", "dir", dir, "offset", s.segments[i].start)
+			for _, seg := range s.segments[:i] {
+				os.Remove(seg.path)
+			}
+			s.segments = s.segments[i:]
+			break
+		}
+	}
+	return s, nil
+}
+
+// Range returns the oldest and end offsets held on disk.
+func (s *RingStore) Range() (int64, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rangeLocked()
+}
+
+func (s *RingStore) rangeLocked() (int64, int64) {
+	if len(s.segments) == 0 {
+		return 0, 0
+	}
+	last := s.segments[len(s.segments)-1]
+	return s.segments[0].start, last.start + last.size
+}
+
+// Append writes data that begins at offset pos. Errors are logged once and
+// disable further persistence rather than stalling terminal output.
+func (s *RingStore) Append(pos int64, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failed || s.closed || len(data) == 0 {
+		return
+	}
+	if err := s.appendLocked(pos, data); err != nil {
+		s.failed = true
+		s.logger.Error("ring store write failed, persistence disabled", "dir", s.dir, "error", err)
+	}
+}
+
+func (s *RingStore) appendLocked(pos int64, data []byte) error {
+	if _, end := s.rangeLocked(); len(s.segments) > 0 && end != pos {
+		return fmt.Errorf("append at %d does not continue log ending at %d", pos, end)
+	}
+
+	for len(data) > 0 {
+		n := len(s.segments)
+		if n == 0 || s.segments[n-1].size >= ringSegmentSize {
+			if err := s.rollLocked(pos); err != nil {
+				return err
+			}
+			n = len(s.segments)
+		} else if s.f == nil {
+			f, err := os.OpenFile(s.segments[n-1].path, os.O_WRONLY|os.O_APPEND, 0600)
+			if err != nil {
+				return err
+			}
+			s.f = f
+		}
+
+		chunk := data
+		if room := ringSegmentSize - s.segments[n-1].size; int64(len(chunk)) > room {
+			chunk = chunk[:room]
+		}
+		written, err := s.f.Write(chunk)
+		s.segments[n-1].size += int64(written)
+		pos += int64(written)
+		if err != nil {
+			return err
+		}
+		data = data[written:]
+	}
+	return nil
+}
+
+// rollLocked starts a new segment at pos and prunes old ones.
+func (s *RingStore) rollLocked(pos int64) error {
+	if s.f != nil {
+		s.f.Close()
+		s.f = nil
+	}
+	path := filepath.Join(s.dir, fmt.Sprintf("%020d%s", pos, ringSegmentSuffix))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	s.f = f
+	s.segments = append(s.segments, ringSegment{start: pos, path: path})
+
+	var total int64
+	for _, seg := range s.segments {
+		total += seg.size
+	}
+	for len(s.segments) > 1 && total > s.maxBytes {
+		total -= s.segments[0].size
+		os.Remove(s.segments[0].path)
+		s.segments = s.segments[1:]
+	}
+	return nil
+}
+
+// ReadRange returns the bytes in [from, to) that are still on disk, clamped
+// to the stored range, and the offset at which they begin.
+func (s *RingStore) ReadRange(from, to int64) ([]byte, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	oldest, end := s.rangeLocked()
+	if from < oldest {
+		from = oldest
+	}
+	if to > end {
+		to = end
+	}
+	if from >= to {
+		return nil, from, nil
+	}
+
+	result := make([]byte, 0, to-from)
+	for _, seg := range s.segments {
+		segEnd := seg.start + seg.size
+		if segEnd <= from || seg.start >= to {
+			continue
+		}
+		lo := max(from, seg.start)
+		hi := min(to, segEnd)
+		f, err := os.Open(seg.path)
+		if err != nil {
+			return nil, from, err
+		}
+		buf := make([]byte, hi-lo)
+		_, err = f.ReadAt(buf, lo-seg.start)
+		f.Close()
+		if err != nil && err != io.EOF {
+			return nil, from, err
+		}
+		result = append(result, buf...)
+	}
+	return result, from, nil
+}
+
+// Close closes the active segment file. Later appends are dropped.
+func (s *RingStore) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.f != nil {
+		s.f.Close()
+		s.f = nil
+	}
+}
+
+// ringStoreDir maps a tmux target to a per-session directory name.
+func ringStoreDir(root, target string) string {
+	return filepath.Join(root, strings.NewReplacer("/", "_", "%", "_").Replace(target))
+}
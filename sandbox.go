@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+var errPathNotFound = errors.New("path not found")
+
+// PathDeniedError is returned when a path falls outside the sandbox roots or
+// matches the deny-list.
+type PathDeniedError struct {
+	Path   string // fully resolved path
+	Reason string
+}
+
+func (e *PathDeniedError) Error() string {
+	return fmt.Sprintf("access to %s denied: %s", e.Path, e.Reason)
+}
+
+// FileSandbox confines the file browser to a set of allowed root directories.
+// Paths are checked after symlink resolution, so a link inside a root cannot
+// be used to reach files outside it. Any path component matching one of the
+// deny patterns (e.g. ".ssh", ".env*") is refused even inside a root.
+type FileSandbox struct {
+	roots  []string
+	deny   []string
+	logger *slog.Logger
+}
+
+func NewFileSandbox(roots, deny []string, logger *slog.Logger) *FileSandbox {
+	s := &FileSandbox{deny: deny, logger: logger}
+	for _, root := range roots {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			continue
+		}
+		if real, err := filepath.EvalSymlinks(abs); err == nil {
+			abs = real
+		}
+		s.roots = append(s.roots, abs)
+	}
+	return s
+}
+
+// Roots returns the resolved sandbox roots.
+func (s *FileSandbox) Roots() []string {
+	return s.roots
+}
+
+// Resolve returns the absolute, symlink-free form of p if it is inside the
+// sandbox. It returns a *PathDeniedError for paths outside the sandbox and
+// errPathNotFound for paths inside it that do not exist.
+func (s *FileSandbox) Resolve(p string) (string, error) {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return "", &PathDeniedError{Path: p, Reason: "invalid path"}
+	}
+
+	real, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		// The path does not exist. Check its closest existing ancestor so
+		// that probing for files outside the sandbox yields 403, never 404.
+		if cerr := s.check(resolveExisting(abs)); cerr != nil {
+			return "", cerr
+		}
+		return "", errPathNotFound
+	}
+
+	// Deny patterns apply to both the requested and the resolved path, so
+	// neither ~/.ssh/id_ed25519 nor a symlink pointing at it is served.
+	if err := s.checkDeny(abs); err != nil {
+		return "", err
+	}
+	if err := s.check(real); err != nil {
+		return "", err
+	}
+	return real, nil
+}
+
+// resolveExisting resolves symlinks in the longest existing prefix of p and
+// re-appends the missing tail.
+func resolveExisting(p string) string {
+	var tail []string
+	for {
+		if real, err := filepath.EvalSymlinks(p); err == nil {
+			return filepath.Join(append([]string{real}, tail...)...)
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return filepath.Join(append([]string{p}, tail...)...)
+		}
+		tail = append([]string{filepath.Base(p)}, tail...)
+		p = parent
+	}
+}
+
+func (s *FileSandbox) check(p string) error {
+	inside := false
+	for _, root := range s.roots {
+		if root == string(filepath.Separator) || p == root || strings.HasPrefix(p, root+string(filepath.Separator)) {
+			inside = true
+			break
+		}
+	}
+	if !inside {
+		return &PathDeniedError{Path: p, Reason: "outside allowed roots"}
+	}
+	return s.checkDeny(p)
+}
+
+func (s *FileSandbox) checkDeny(p string) error {
+	for _, part := range strings.Split(p, string(filepath.Separator)) {
+		if part != "" && s.Denied(part) {
+			return &PathDeniedError{Path: p, Reason: fmt.Sprintf("%q matches the deny-list", part)}
+		}
+	}
+	return nil
+}
+
+// Denied reports whether a single directory entry name matches the deny-list.
+func (s *FileSandbox) Denied(name string) bool {
+	for _, pattern := range s.deny {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveRequest resolves reqPath for an HTTP handler, writing a 403 or 404
+// response and returning ok=false if the path cannot be served.
+func (s *FileSandbox) resolveRequest(w http.ResponseWriter, r *http.Request, reqPath string) (string, bool) {
+	real, err := s.Resolve(reqPath)
+	if err == nil {
+		return real, true
+	}
+
+	var denied *PathDeniedError
+	if errors.As(err, &denied) {
+		identity := ""
+		if p, ok := PrincipalFrom(r.Context()); ok {
+			identity = p.Name
+		}
+		s.logger.Warn("file access denied",
+			"method", r.Method,
+			"path", reqPath,
+			"resolved", denied.Path,
+			"reason", denied.Reason,
+			"identity", identity,
+			"remote_addr", r.RemoteAddr,
+		)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return "", false
+	}
+
+	http.Error(w, "not found", http.StatusNotFound)
+	return "", false
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileSandboxResolve(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+
+	os.MkdirAll(filepath.Join(root, "proj"), 0755)
+	os.WriteFile(filepath.Join(root, "proj", "main.go"), []byte("package main"), 0644)
+	os.WriteFile(filepath.Join(root, "proj", ".env"), []byte("SECRET=1"), 0644)
+	os.MkdirAll(filepath.Join(root, ".ssh"), 0700)
+	os.WriteFile(filepath.Join(root, ".ssh", "id_ed25519"), []byte("key"), 0600)
+	os.WriteFile(filepath.Join(outside, "passwd"), []byte("root:x:0:0"), 0644)
+
+	// Symlinks inside the root pointing outside it, or at a denied file.
+	os.Symlink(filepath.Join(outside, "passwd"), filepath.Join(root, "escape"))
+	os.Symlink(filepath.Join(root, ".ssh", "id_ed25519"), filepath.Join(root, "key-link"))
+
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	sb := NewFileSandbox([]string{root}, []string{".ssh", ".env", ".env.*"}, logger)
+
+	if _, err := sb.Resolve(filepath.Join(root, "proj", "main.go")); err != nil {
+		t.Fatalf("expected file inside root to resolve, got %v", err)
+	}
+	if _, err := sb.Resolve(filepath.Join(root, "proj", "..", "proj")); err != nil {
+		t.Fatalf("expected cleaned path inside root to resolve, got %v", err)
+	}
+
+	denied := []string{
+		filepath.Join(outside, "passwd"),
+		filepath.Join(root, "..", filepath.Base(outside), "passwd"),
+		filepath.Join(root, "escape"),
+		filepath.Join(root, "key-link"),
+		filepath.Join(root, ".ssh", "id_ed25519"),
+		filepath.Join(root, "proj", ".env"),
+		filepath.Join(outside, "does-not-exist"),
+		"/etc/shadow",
+	}
+	for _, p := range denied {
+		var de *PathDeniedError
+		if _, err := sb.Resolve(p); !errors.As(err, &de) {
+			t.Errorf("expected %s to be denied, got %v", p, err)
+		}
+	}
+
+	if _, err := sb.Resolve(filepath.Join(root, "proj", "missing.go")); !errors.Is(err, errPathNotFound) {
+		t.Errorf("expected missing file inside root to be not found, got %v", err)
+	}
+}
+
+func TestFileHandlersSandboxed(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(root, "notes.md"), []byte("hello"), 0644)
+	os.WriteFile(filepath.Join(outside, "secret"), []byte("nope"), 0644)
+
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	sb := NewFileSandbox([]string{root}, []string{".ssh"}, logger)
+
+	get := NewFileContentHandler(sb, logger)
+	put := NewFileSaveHandler(sb, nil, logger)
+	list := NewFilesHandler(sb, logger)
+
+	rec := httptest.NewRecorder()
+	get(rec, httptest.NewRequest("GET", "/api/files/raw?path="+url.QueryEscape(filepath.Join(root, "notes.md")), nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "hello" {
+		t.Fatalf("expected 200 hello, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	get(rec, httptest.NewRequest("GET", "/api/files/raw?path="+url.QueryEscape(filepath.Join(outside, "secret")), nil))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 reading outside root, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	put(rec, httptest.NewRequest("PUT", "/api/files/raw?path="+url.QueryEscape(filepath.Join(outside, "secret")), strings.NewReader("pwned")))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 writing outside root, got %d", rec.Code)
+	}
+	if data, _ := os.ReadFile(filepath.Join(outside, "secret")); string(data) != "nope" {
+		t.Fatalf("file outside root was modified: %q", data)
+	}
+
+	rec = httptest.NewRecorder()
+	list(rec, httptest.NewRequest("GET", "/api/files?path="+url.QueryEscape(outside), nil))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 listing outside root, got %d", rec.Code)
+	}
+}
@@ -3,9 +3,11 @@ package main
 import (
 	"embed"
 	"encoding/json"
+	"fmt"
 	"io/fs"
 	"log/slog"
 	"net/http"
+	"sort"
 	"strings"
 
 	"github.com/coder/websocket"
@@ -14,19 +16,86 @@ import (
 //go:embed frontend/dist/*
 var frontendFS embed.FS
 
-func NewServer(cfg *Config, sm *SessionManager, indexer *FileIndexer, logger *slog.Logger) *http.ServeMux {
+func NewServer(cfg *Config, sm *SessionManager, indexer *FileIndexer, audit *AuditLog, logger *slog.Logger) http.Handler {
 	mux := http.NewServeMux()
+	shares := NewShareManager(logger)
+	auth := NewAuthenticator(cfg, shares, logger)
+	origins := NewOriginChecker(cfg.AllowedOrigins, logger)
+
+	// mutating guards state-changing endpoints: same-origin only, and
+	// refused for read-only viewers.
+	mutating := func(h http.HandlerFunc) http.HandlerFunc {
+		return origins.Protect(RequireControl(h))
+	}
+
+	// Login endpoints (exempt from the auth middleware)
+	mux.HandleFunc("GET /login", auth.HandleLoginPage)
+	mux.HandleFunc("POST /api/login", origins.Protect(auth.HandleLogin))
+	mux.HandleFunc("POST /api/logout", origins.Protect(auth.HandleLogout))
+
+	// Share links scoped to one target and role
+	mux.HandleFunc("GET /share/{token}", shares.HandleRedeem)
+	mux.HandleFunc("GET /api/shares", RequireControl(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"shares": shares.List()})
+	}))
+	mux.HandleFunc("POST /api/shares", mutating(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Target string `json:"target"`
+			Role   Role   `json:"role"`
+			TTL    string `json:"ttl"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Target == "" {
+			http.Error(w, "missing target", http.StatusBadRequest)
+			return
+		}
+		if body.Role == "" {
+			body.Role = RoleView
+		}
+		if body.Role != RoleView && body.Role != RoleControl {
+			http.Error(w, "role must be view or control", http.StatusBadRequest)
+			return
+		}
+		ttl, err := parseShareTTL(body.TTL)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p, _ := PrincipalFrom(r.Context())
+		share, token := shares.Create(body.Target, body.Role, ttl, p.Name)
+		audit.RecordRequest(r, "share_create", body.Target, fmt.Sprintf("id=%s role=%s ttl=%s", share.ID, share.Role, ttl))
+
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"share": share,
+			"url":   fmt.Sprintf("%s://%s/share/%s", scheme, r.Host, token),
+		})
+	}))
+	mux.HandleFunc("DELETE /api/shares/{id}", mutating(func(w http.ResponseWriter, r *http.Request) {
+		if !shares.Revoke(r.PathValue("id")) {
+			http.Error(w, "share not found", http.StatusNotFound)
+			return
+		}
+		audit.RecordRequest(r, "share_revoke", "", "id="+r.PathValue("id"))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"ok": "true"})
+	}))
 
 	// General image upload (no PTY injection — just saves the file)
-	mux.HandleFunc("POST /api/upload", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("POST /api/upload", mutating(func(w http.ResponseWriter, r *http.Request) {
 		// Reuse the upload handler with a nil PTY (skips prompt injection)
-		NewUploadHandler(cfg, nil, logger)(w, r)
-	})
+		NewUploadHandler(cfg, nil, audit, logger)(w, r)
+	}))
 
-	// File browser endpoints
-	mux.HandleFunc("GET /api/files", NewFilesHandler(logger))
-	mux.HandleFunc("GET /api/files/raw", NewFileContentHandler(logger))
-	mux.HandleFunc("PUT /api/files/raw", NewFileSaveHandler(logger))
+	// File browser endpoints, confined to the sandbox roots
+	sandbox := NewFileSandbox(cfg.AllowedRoots, cfg.DeniedPaths, logger)
+	mux.HandleFunc("GET /api/files", NewFilesHandler(sandbox, logger))
+	mux.HandleFunc("GET /api/files/raw", NewFileContentHandler(sandbox, logger))
+	mux.HandleFunc("PUT /api/files/raw", mutating(NewFileSaveHandler(sandbox, audit, logger)))
 
 	// File search endpoint
 	mux.HandleFunc("GET /api/search", func(w http.ResponseWriter, r *http.Request) {
@@ -41,7 +110,7 @@ func NewServer(cfg *Config, sm *SessionManager, indexer *FileIndexer, logger *sl
 	})
 
 	// Rename tmux window
-	mux.HandleFunc("POST /api/rename", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("POST /api/rename", mutating(func(w http.ResponseWriter, r *http.Request) {
 		var body struct {
 			Target string `json:"target"`
 			Name   string `json:"name"`
@@ -54,12 +123,13 @@ func NewServer(cfg *Config, sm *SessionManager, indexer *FileIndexer, logger *sl
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		audit.RecordRequest(r, "rename", body.Target, body.Name)
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{"ok": "true"})
-	})
+	}))
 
 	// Kill tmux window
-	mux.HandleFunc("POST /api/kill-window", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("POST /api/kill-window", mutating(func(w http.ResponseWriter, r *http.Request) {
 		var body struct {
 			Target string `json:"target"`
 		}
@@ -71,27 +141,247 @@ func NewServer(cfg *Config, sm *SessionManager, indexer *FileIndexer, logger *sl
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		audit.RecordRequest(r, "kill_window", body.Target, "")
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{"ok": "true"})
-	})
+	}))
 
-	// Create new tmux session
-	mux.HandleFunc("POST /api/new-session", func(w http.ResponseWriter, r *http.Request) {
+	// The endpoints below reply with the target the action resulted in, for
+	// the client to open next.
+
+	// Create new tmux session, empty or from a session template
+	mux.HandleFunc("POST /api/new-session", mutating(func(w http.ResponseWriter, r *http.Request) {
 		var body struct {
-			Name string `json:"name"`
+			Name     string `json:"name"`
+			Template string `json:"template"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Name == "" {
 			http.Error(w, "missing name", http.StatusBadRequest)
 			return
 		}
-		if err := CreateSession(body.Name); err != nil {
+		if err := CheckSessionName(body.Name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var target string
+		var err error
+		if body.Template != "" {
+			tmpl, ok := cfg.SessionTemplates[body.Template]
+			if !ok {
+				http.Error(w, "unknown template", http.StatusBadRequest)
+				return
+			}
+			target, err = tmpl.Build(body.Name)
+		} else {
+			target, err = CreateSession(body.Name, "", SpawnOptions{})
+		}
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		audit.RecordRequest(r, "new_session", body.Name, body.Template)
+		writeTarget(w, target)
+	}))
+
+	// Session template names, for the new session form
+	mux.HandleFunc("GET /api/session-templates", func(w http.ResponseWriter, r *http.Request) {
+		names := make([]string, 0, len(cfg.SessionTemplates))
+		for name := range cfg.SessionTemplates {
+			names = append(names, name)
+		}
+		sort.Strings(names)
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"ok": "true"})
+		json.NewEncoder(w).Encode(map[string]any{"templates": names})
 	})
 
+	// Open a window in a session
+	mux.HandleFunc("POST /api/new-window", mutating(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Session string `json:"session"`
+			Name    string `json:"name"`
+			SpawnOptions
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Session == "" {
+			http.Error(w, "missing session", http.StatusBadRequest)
+			return
+		}
+		if err := CheckSessionName(body.Session); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		target, err := NewWindow(body.Session, body.Name, body.SpawnOptions)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		audit.RecordRequest(r, "new_window", target, body.Command)
+		writeTarget(w, target)
+	}))
+
+	// Split a pane
+	mux.HandleFunc("POST /api/split-pane", mutating(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Target string `json:"target"`
+			Split  string `json:"split"` // "horizontal" (side by side) or "vertical" (default)
+			SpawnOptions
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Target == "" {
+			http.Error(w, "missing target", http.StatusBadRequest)
+			return
+		}
+		if body.Split != "" && body.Split != SplitHorizontal && body.Split != SplitVertical {
+			http.Error(w, "split must be horizontal or vertical", http.StatusBadRequest)
+			return
+		}
+		target, err := SplitPane(body.Target, body.Split, body.SpawnOptions)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		audit.RecordRequest(r, "split_pane", target, body.Command)
+		writeTarget(w, target)
+	}))
+
+	// Make a window current in its session
+	mux.HandleFunc("POST /api/select-window", mutating(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Target string `json:"target"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Target == "" {
+			http.Error(w, "missing target", http.StatusBadRequest)
+			return
+		}
+		target, err := SelectWindow(body.Target)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		audit.RecordRequest(r, "select_window", body.Target, "")
+		writeTarget(w, target)
+	}))
+
+	// Kill a pane; replies with the window's remaining active pane, if any
+	mux.HandleFunc("POST /api/kill-pane", mutating(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Target string `json:"target"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Target == "" {
+			http.Error(w, "missing target", http.StatusBadRequest)
+			return
+		}
+		target, err := KillPane(body.Target)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		audit.RecordRequest(r, "kill_pane", body.Target, "")
+		writeTarget(w, target)
+	}))
+
+	// Kill a session; there is no resulting target
+	mux.HandleFunc("POST /api/kill-session", mutating(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Name == "" {
+			http.Error(w, "missing name", http.StatusBadRequest)
+			return
+		}
+		if err := CheckSessionName(body.Name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := KillSession(body.Name); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		audit.RecordRequest(r, "kill_session", body.Name, "")
+		writeTarget(w, "")
+	}))
+
+	// Rename the session containing a target
+	mux.HandleFunc("POST /api/rename-session", mutating(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Target string `json:"target"`
+			Name   string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Target == "" || body.Name == "" {
+			http.Error(w, "missing target or name", http.StatusBadRequest)
+			return
+		}
+		if err := CheckSessionName(body.Name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		target, err := RenameSession(body.Target, body.Name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		audit.RecordRequest(r, "rename_session", body.Target, body.Name)
+		writeTarget(w, target)
+	}))
+
+	// Move a window to another index or session
+	mux.HandleFunc("POST /api/move-window", mutating(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Target string `json:"target"`
+			Dest   string `json:"dest"` // "session:" for the next free index, or "session:index"
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Target == "" || body.Dest == "" {
+			http.Error(w, "missing target or dest", http.StatusBadRequest)
+			return
+		}
+		target, err := MoveWindow(body.Target, body.Dest)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		audit.RecordRequest(r, "move_window", body.Target, body.Dest)
+		writeTarget(w, target)
+	}))
+
+	// Swap two windows
+	mux.HandleFunc("POST /api/swap-window", mutating(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Target string `json:"target"`
+			Dest   string `json:"dest"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Target == "" || body.Dest == "" {
+			http.Error(w, "missing target or dest", http.StatusBadRequest)
+			return
+		}
+		target, err := SwapWindow(body.Target, body.Dest)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		audit.RecordRequest(r, "swap_window", body.Target, body.Dest)
+		writeTarget(w, target)
+	}))
+
+	// Restart what a pane runs, optionally with a new command
+	mux.HandleFunc("POST /api/respawn-pane", mutating(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Target string `json:"target"`
+			SpawnOptions
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Target == "" {
+			http.Error(w, "missing target", http.StatusBadRequest)
+			return
+		}
+		target, err := RespawnPane(body.Target, body.SpawnOptions)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		audit.RecordRequest(r, "respawn_pane", target, body.Command)
+		writeTarget(w, target)
+	}))
+
+	// Audit log query: /api/audit?since=&until=&target=&limit=
+	mux.HandleFunc("GET /api/audit", RequireControl(audit.HandleQuery))
+
 	// Session list endpoint
 	mux.HandleFunc("GET /api/sessions", func(w http.ResponseWriter, r *http.Request) {
 		sessions, err := ListSessions()
@@ -105,45 +395,127 @@ func NewServer(cfg *Config, sm *SessionManager, indexer *FileIndexer, logger *sl
 		})
 	})
 
-	// Per-session WebSocket: /s/{target}/ws
-	// Target can contain colons and dots, e.g., "6:0.0"
-	mux.HandleFunc("GET /s/{target}/ws", func(w http.ResponseWriter, r *http.Request) {
+	// Live session, window and pane changes, shared by every client
+	events := NewEventWatcher(sm.control, sm.TmuxSessions, eventPoll, logger)
+	mux.HandleFunc("GET /api/events", func(w http.ResponseWriter, r *http.Request) {
+		conn, stats, err := acceptWS(w, r, cfg, origins.Patterns())
+		if err != nil {
+			logger.Error("websocket accept failed", "error", err, "path", r.URL.Path)
+			return
+		}
+		events.Serve(r.Context(), conn, stats)
+	})
+
+	// Per-client WebSocket byte counters, before and after compression. They
+	// name each client's identity and address, so viewers may not see them.
+	mux.HandleFunc("GET /api/metrics", RequireControl(func(w http.ResponseWriter, r *http.Request) {
+		clients := map[string][]ClientStats{}
+		output := map[string]OutputStats{}
+		for _, s := range sm.List() {
+			clients[s.Target] = s.Hub.Stats()
+			output[s.Target] = s.Output.Stats()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"compression": cfg.WSCompression,
+			"clients":     clients,
+			"output":      output,
+		})
+	}))
+
+	// serveWS upgrades a per-session WebSocket. Viewer connections (either a
+	// view-only principal or the /view route) never forward input.
+	serveWS := func(w http.ResponseWriter, r *http.Request, forceView bool) {
 		target := r.PathValue("target")
 		if target == "" {
 			http.Error(w, "missing target", http.StatusBadRequest)
 			return
 		}
 
+		role := RoleControl
+		p, _ := PrincipalFrom(r.Context())
+		if p.Role != "" && !p.CanControl() {
+			role = RoleView
+		}
+		if forceView {
+			role = RoleView
+		}
+
 		sess := sm.Get(target)
 
-		conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
-			InsecureSkipVerify: true,
-		})
+		// Accept verifies that the Origin header matches the request host or
+		// one of the configured patterns, rejecting cross-site hijacking.
+		conn, stats, err := acceptWS(w, r, cfg, origins.Patterns())
 		if err != nil {
 			logger.Error("websocket accept failed", "error", err, "target", target)
 			return
 		}
 
-		client := NewClient(conn, sess.Hub, sess.PTY, sess.Ring, cfg, logger)
+		// Connections opened through a share link are cut off as soon as
+		// the share is revoked or expires.
+		if p.ShareID != "" {
+			untrack := shares.Track(p.ShareID, func() {
+				conn.Close(websocket.StatusPolicyViolation, "share revoked or expired")
+			})
+			defer untrack()
+		}
+
+		info := ClientInfo{Role: role, Identity: p.Name, RemoteAddr: r.RemoteAddr}
+		client := NewClient(conn, stats, sess.Hub, sess.PTY, sess.Ring, sess.VT, sess.Sizer, info, audit, cfg, logger)
 		client.Run(r.Context())
+	}
+
+	// Per-session WebSocket: /s/{target}/ws
+	// Target can contain colons and dots, e.g., "6:0.0"
+	mux.HandleFunc("GET /s/{target}/ws", func(w http.ResponseWriter, r *http.Request) {
+		serveWS(w, r, false)
+	})
+
+	// Read-only per-session WebSocket: /s/{target}/view
+	mux.HandleFunc("GET /s/{target}/view", func(w http.ResponseWriter, r *http.Request) {
+		serveWS(w, r, true)
+	})
+
+	// Per-window WebSocket multiplexing every pane: /w/{window}/ws, where
+	// window is "session:window", e.g. "claude:0".
+	mux.HandleFunc("GET /w/{window}/ws", func(w http.ResponseWriter, r *http.Request) {
+		window := r.PathValue("window")
+		if !strings.Contains(window, ":") {
+			http.Error(w, "window must be session:window", http.StatusBadRequest)
+			return
+		}
+
+		role := RoleControl
+		p, _ := PrincipalFrom(r.Context())
+		if p.Role != "" && !p.CanControl() {
+			role = RoleView
+		}
+
+		conn, stats, err := acceptWS(w, r, cfg, origins.Patterns())
+		if err != nil {
+			logger.Error("websocket accept failed", "error", err, "window", window)
+			return
+		}
+		info := ClientInfo{Role: role, Identity: p.Name, RemoteAddr: r.RemoteAddr}
+		NewWindowConn(window, conn, stats, sm, info, audit, cfg, logger).Run(r.Context())
 	})
 
 	// Per-session upload: /s/{target}/upload
-	mux.HandleFunc("POST /s/{target}/upload", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("POST /s/{target}/upload", mutating(func(w http.ResponseWriter, r *http.Request) {
 		target := r.PathValue("target")
 		if target == "" {
 			http.Error(w, "missing target", http.StatusBadRequest)
 			return
 		}
 		sess := sm.Get(target)
-		NewUploadHandler(cfg, sess.PTY, logger)(w, r)
-	})
+		NewUploadHandler(cfg, sess.PTY, audit, logger)(w, r)
+	}))
 
 	// Serve embedded frontend
 	distFS, err := fs.Sub(frontendFS, "frontend/dist")
 	if err != nil {
 		logger.Error("failed to create sub filesystem", "error", err)
-		return mux
+		return auth.Middleware(mux)
 	}
 
 	fileServer := http.FileServer(http.FS(distFS))
@@ -164,5 +536,12 @@ func NewServer(cfg *Config, sm *SessionManager, indexer *FileIndexer, logger *sl
 		fileServer.ServeHTTP(w, r)
 	})
 
-	return mux
+	return auth.Middleware(mux)
+}
+
+// writeTarget replies to a tmux management request with the target it
+// resulted in.
+func writeTarget(w http.ResponseWriter, target string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"ok": "true", "target": target})
 }
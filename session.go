@@ -3,24 +3,34 @@ package main
 import (
 	"context"
 	"log/slog"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// Session holds the per-target PTY pipeline: monitor, pty manager, ring buffer, and hub.
+// Session holds the per-target PTY pipeline: monitor, pty manager, ring buffer,
+// terminal emulator, and hub.
 type Session struct {
 	Target  string
 	Ring    *RingBuffer
+	VT      *VTerm
 	Hub     *Hub
-	PTY     *PTYManager
+	Output  *OutputBatcher
+	PTY     PaneIO
 	Monitor *PaneMonitor
+	Sizer   *Sizer // nil under the pane resize policy
 	cancel  context.CancelFunc
+
+	tty atomic.Value // string: PTY the pane I/O is attached to, "" while missing
 }
 
 // SessionManager creates and caches sessions by tmux target.
 type SessionManager struct {
 	mu       sync.Mutex
 	sessions map[string]*Session
+	control  *ControlMode
 	cfg      *Config
 	logger   *slog.Logger
 }
@@ -28,6 +38,7 @@ type SessionManager struct {
 func NewSessionManager(cfg *Config, logger *slog.Logger) *SessionManager {
 	return &SessionManager{
 		sessions: make(map[string]*Session),
+		control:  NewControlMode(logger),
 		cfg:      cfg,
 		logger:   logger,
 	}
@@ -50,14 +61,44 @@ func (sm *SessionManager) Get(target string) *Session {
 func (sm *SessionManager) createLocked(target string) *Session {
 	logger := sm.logger.With("target", target)
 
-	ring := NewRingBuffer(sm.cfg.RingBufferSize)
-	hub := NewHub(logger)
-	ptyMgr := NewPTYManager(target, ring, logger)
-	ptyMgr.onOutput = func(data []byte) { hub.Broadcast(data) }
+	ring := sm.newRing(target, logger)
+	vt := NewVTerm(80, 24, snapshotScrollback)
+	vt.Seed(ring.WritePos(), 80, 24, nil)
+	hub := NewHub(sm.cfg.SlowClientPolicy, logger)
+	checkpoints := newCheckpointer(vt, ring, sm.cfg.CheckpointInterval, sm.cfg.CheckpointBytes)
+	output := NewOutputBatcher(sm.cfg.OutputBatchWindow, sm.cfg.OutputBatchBytes, hub.Broadcast)
+	onOutput := func(offset int64, data []byte) {
+		vt.Write(offset, data)
+		checkpoints.observe(offset + int64(len(data)))
+		output.Add(offset, data)
+	}
+	var ptyMgr PaneIO
+	if sm.cfg.TmuxBackend == TmuxBackendControl {
+		p := NewControlPane(sm.control, target, ring, logger)
+		p.onOutput = onOutput
+		ptyMgr = p
+	} else {
+		p := NewPTYManager(target, sm.cfg.PipePaneConflict, ring, logger)
+		p.onOutput = onOutput
+		ptyMgr = p
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 
-	monitor := NewPaneMonitor(target, 5*time.Second, logger)
+	monitor := NewPaneMonitor(target, 5*time.Second, sm.control, logger)
+	sizer := NewSizer(sm.cfg.ResizePolicy, target, monitor.ForceCheck, logger)
+	s := &Session{
+		Target:  target,
+		Ring:    ring,
+		VT:      vt,
+		Hub:     hub,
+		Output:  output,
+		PTY:     ptyMgr,
+		Monitor: monitor,
+		Sizer:   sizer,
+		cancel:  cancel,
+	}
+	s.tty.Store("")
 	go monitor.Run(ctx)
 
 	go func() {
@@ -70,36 +111,112 @@ func (sm *SessionManager) createLocked(target string) *Session {
 				case PaneStateConnected:
 					if ev.NewTTY {
 						logger.Info("attaching to PTY", "tty", ev.TTY)
+						if err := seedTerminal(vt, ring, monitor.Target()); err != nil {
+							logger.Warn("failed to seed terminal state", "error", err)
+						}
 						if err := ptyMgr.Reattach(ev.TTY); err != nil {
 							logger.Error("failed to attach PTY", "tty", ev.TTY, "error", err)
 						}
-						hub.BroadcastStatus("connected", ptyMgr.Epoch())
+						s.tty.Store(ev.TTY)
+						hub.BroadcastStatus("connected", ptyMgr.Epoch(), ev.Cols, ev.Rows)
+					} else if ev.Resized {
+						if err := seedTerminal(vt, ring, monitor.Target()); err != nil {
+							logger.Warn("failed to re-seed terminal state", "error", err)
+							vt.Resize(ev.Cols, ev.Rows)
+						}
+						hub.BroadcastStatus("connected", ptyMgr.Epoch(), ev.Cols, ev.Rows)
 					}
 				case PaneStateMissing:
 					logger.Warn("pane missing, closing PTY")
 					ptyMgr.Close()
-					hub.BroadcastStatus("missing", ptyMgr.Epoch())
+					s.tty.Store("")
+					hub.BroadcastStatus("missing", ptyMgr.Epoch(), 0, 0)
 				}
 			}
 		}
 	}()
 
 	logger.Info("session created", "target", target)
+	return s
+}
 
-	return &Session{
-		Target:  target,
-		Ring:    ring,
-		Hub:     hub,
-		PTY:     ptyMgr,
-		Monitor: monitor,
-		cancel:  cancel,
+// TTY returns the PTY the session's pane I/O is attached to, or "" while the
+// pane is missing or not yet found.
+func (s *Session) TTY() string {
+	return s.tty.Load().(string)
+}
+
+// snapshotScrollback is the number of scrollback lines kept by the terminal
+// emulator and sent in reconnect snapshots.
+const snapshotScrollback = 2000
+
+// seedTerminal loads a pane's current screen and modes from tmux into vt.
+// The capture is retried if output arrives meanwhile, so the state matches the
+// ring offset it is recorded at; bytes after that offset are applied on top.
+func seedTerminal(vt *VTerm, ring *RingBuffer, target string) error {
+	for attempt := 1; ; attempt++ {
+		offset := ring.WritePos()
+		state, cols, rows, err := CapturePaneState(target, snapshotScrollback)
+		if err != nil {
+			return err
+		}
+		if ring.WritePos() == offset || attempt == 3 {
+			vt.Seed(offset, cols, rows, state)
+			return nil
+		}
 	}
 }
 
+// newRing returns the session's ring buffer, reloaded from disk when
+// persistence is enabled. A store that cannot be opened falls back to memory.
+func (sm *SessionManager) newRing(target string, logger *slog.Logger) *RingBuffer {
+	if sm.cfg.RingPersistDir == "" {
+		return NewRingBuffer(sm.cfg.RingBufferSize)
+	}
+	store, err := OpenRingStore(ringStoreDir(sm.cfg.RingPersistDir, target), sm.cfg.RingPersistMax, logger)
+	if err != nil {
+		logger.Error("ring store unavailable, scrollback will not persist", "error", err)
+		return NewRingBuffer(sm.cfg.RingBufferSize)
+	}
+	ring := NewPersistentRingBuffer(sm.cfg.RingBufferSize, store)
+	logger.Info("scrollback restored", "write_pos", ring.WritePos())
+	return ring
+}
+
 // Close shuts down a session.
 func (s *Session) Close() {
 	s.cancel()
+	s.Sizer.Close()
 	s.PTY.Close()
+	s.Output.Flush()
+	s.Ring.Close()
+}
+
+// List returns the open sessions sorted by target.
+func (sm *SessionManager) List() []*Session {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	result := make([]*Session, 0, len(sm.sessions))
+	for _, s := range sm.sessions {
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Target < result[j].Target })
+	return result
+}
+
+// TmuxSessions returns the names of the tmux sessions holding the panes
+// being served.
+func (sm *SessionManager) TmuxSessions() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, s := range sm.List() {
+		name, _, _ := strings.Cut(s.PTY.Target(), ":")
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
 }
 
 // CloseAll shuts down all sessions.
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	shareCookieName = "c3_share"
+	defaultShareTTL = time.Hour
+	maxShareTTL     = 7 * 24 * time.Hour
+)
+
+// Share grants access to exactly one tmux target with one role until it
+// expires or is revoked.
+type Share struct {
+	ID        string    `json:"id"`
+	Target    string    `json:"target"`
+	Role      Role      `json:"role"`
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// ShareManager mints and verifies signed share links and tracks the
+// connections opened with them so they can be cut off on revocation.
+//
+// Shares live in memory only: restarting c3 revokes every outstanding link.
+type ShareManager struct {
+	key    []byte
+	logger *slog.Logger
+
+	mu     sync.Mutex
+	shares map[string]*Share
+	conns  map[string]map[int64]func() // share ID -> connection closers
+	nextID int64
+}
+
+func NewShareManager(logger *slog.Logger) *ShareManager {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return &ShareManager{
+		key:    key,
+		logger: logger,
+		shares: make(map[string]*Share),
+		conns:  make(map[string]map[int64]func()),
+	}
+}
+
+// Create mints a new share and returns it with its signed token.
+func (m *ShareManager) Create(target string, role Role, ttl time.Duration, createdBy string) (*Share, string) {
+	idBytes := make([]byte, 16)
+	rand.Read(idBytes)
+
+	now := time.Now()
+	s := &Share{
+		ID:        hex.EncodeToString(idBytes),
+		Target:    target,
+		Role:      role,
+		CreatedBy: createdBy,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	m.mu.Lock()
+	m.shares[s.ID] = s
+	m.mu.Unlock()
+
+	m.logger.Info("share created", "share_id", s.ID, "target", target, "role", role, "created_by", createdBy, "expires", s.ExpiresAt)
+	return s, m.token(s)
+}
+
+// token returns id.signature, where the signature covers every field that
+// scopes the share.
+func (m *ShareManager) token(s *Share) string {
+	mac := hmac.New(sha256.New, m.key)
+	fmt.Fprintf(mac, "%s\x00%s\x00%s\x00%d", s.ID, s.Target, s.Role, s.ExpiresAt.Unix())
+	return s.ID + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify returns the share for a token if its signature is valid and the
+// share has neither expired nor been revoked.
+func (m *ShareManager) Verify(token string) (*Share, bool) {
+	id, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.shares[id]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(s.ExpiresAt) {
+		delete(m.shares, id)
+		return nil, false
+	}
+	if !hmac.Equal([]byte(token), []byte(m.token(s))) {
+		return nil, false
+	}
+	return s, true
+}
+
+// List returns all active shares, newest first.
+func (m *ShareManager) List() []Share {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	result := []Share{}
+	for id, s := range m.shares {
+		if now.After(s.ExpiresAt) {
+			delete(m.shares, id)
+			continue
+		}
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+	return result
+}
+
+// Revoke invalidates a share and disconnects everyone connected with it.
+func (m *ShareManager) Revoke(id string) bool {
+	m.mu.Lock()
+	_, ok := m.shares[id]
+	delete(m.shares, id)
+	closers := m.conns[id]
+	delete(m.conns, id)
+	m.mu.Unlock()
+
+	for _, closeFn := range closers {
+		go closeFn()
+	}
+	if ok {
+		m.logger.Info("share revoked", "share_id", id, "disconnected", len(closers))
+	}
+	return ok
+}
+
+// Track registers a connection opened with a share. closeFn is called when
+// the share is revoked or expires. The returned function unregisters it.
+func (m *ShareManager) Track(id string, closeFn func()) (untrack func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.shares[id]
+	if !ok {
+		go closeFn()
+		return func() {}
+	}
+
+	m.nextID++
+	connID := m.nextID
+	if m.conns[id] == nil {
+		m.conns[id] = make(map[int64]func())
+	}
+	m.conns[id][connID] = closeFn
+
+	timer := time.AfterFunc(time.Until(s.ExpiresAt), func() {
+		m.logger.Info("share expired, disconnecting", "share_id", id)
+		closeFn()
+	})
+
+	return func() {
+		timer.Stop()
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if conns := m.conns[id]; conns != nil {
+			delete(conns, connID)
+			if len(conns) == 0 {
+				delete(m.conns, id)
+			}
+		}
+	}
+}
+
+// Allows reports whether a share principal may access r. Share holders only
+// reach the frontend assets and the routes of their own target; window
+// routes span other panes and are refused.
+func (p Principal) Allows(r *http.Request) bool {
+	if p.Target == "" {
+		return true
+	}
+	if strings.HasPrefix(r.URL.Path, "/api/") || strings.HasPrefix(r.URL.Path, "/w/") {
+		return false
+	}
+	rest, ok := strings.CutPrefix(r.URL.Path, "/s/")
+	if !ok {
+		return r.Method == http.MethodGet
+	}
+	target, _, _ := strings.Cut(rest, "/")
+	return target == p.Target
+}
+
+// HandleRedeem validates a share link, stores it in a cookie, and redirects
+// to the shared target.
+func (m *ShareManager) HandleRedeem(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	s, ok := m.Verify(token)
+	if !ok {
+		http.Error(w, "share link expired or revoked", http.StatusNotFound)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     shareCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  s.ExpiresAt,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	m.logger.Info("share redeemed", "share_id", s.ID, "remote_addr", r.RemoteAddr)
+	http.Redirect(w, r, "/s/"+url.PathEscape(s.Target)+"/", http.StatusSeeOther)
+}
+
+// parseShareTTL accepts a Go duration ("30m") or a number of seconds.
+func parseShareTTL(v string) (time.Duration, error) {
+	if v == "" {
+		return defaultShareTTL, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		secs, serr := strconv.Atoi(v)
+		if serr != nil {
+			return 0, fmt.Errorf("invalid ttl %q", v)
+		}
+		d = time.Duration(secs) * time.Second
+	}
+	if d <= 0 || d > maxShareTTL {
+		return 0, fmt.Errorf("ttl must be between 1s and %s", maxShareTTL)
+	}
+	return d, nil
+}
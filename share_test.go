@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+func TestShareManagerVerify(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	m := NewShareManager(logger)
+
+	s, token := m.Create("claude:0.0", RoleView, time.Hour, "alice")
+	if got, ok := m.Verify(token); !ok || got.ID != s.ID {
+		t.Fatalf("expected token to verify, got %v %v", got, ok)
+	}
+	if _, ok := m.Verify(s.ID + ".forged"); ok {
+		t.Fatal("expected forged signature to be rejected")
+	}
+	if _, ok := m.Verify("garbage"); ok {
+		t.Fatal("expected garbage token to be rejected")
+	}
+	if len(m.List()) != 1 {
+		t.Fatalf("expected 1 share, got %d", len(m.List()))
+	}
+
+	closed := make(chan struct{})
+	untrack := m.Track(s.ID, func() { close(closed) })
+	defer untrack()
+
+	if !m.Revoke(s.ID) {
+		t.Fatal("expected revoke to succeed")
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("tracked connection was not closed on revoke")
+	}
+	if _, ok := m.Verify(token); ok {
+		t.Fatal("expected revoked token to be rejected")
+	}
+
+	// Expired shares stop verifying.
+	_, token = m.Create("claude:0.0", RoleView, time.Millisecond, "alice")
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := m.Verify(token); ok {
+		t.Fatal("expected expired token to be rejected")
+	}
+}
+
+func TestPrincipalAllows(t *testing.T) {
+	p := Principal{Name: "share:x", Role: RoleView, Target: "claude:0.0", ShareID: "x"}
+	tests := []struct {
+		method, path string
+		want         bool
+	}{
+		{"GET", "/s/claude:0.0/", true},
+		{"GET", "/s/claude:0.0/ws", true},
+		{"GET", "/assets/index.js", true},
+		{"GET", "/s/other:0.0/ws", false},
+		{"GET", "/api/sessions", false},
+		{"GET", "/api/files/raw", false},
+		{"POST", "/api/kill-window", false},
+		{"GET", "/w/claude:0/ws", false},
+	}
+	for _, tt := range tests {
+		if got := p.Allows(httptest.NewRequest(tt.method, tt.path, nil)); got != tt.want {
+			t.Errorf("%s %s: got %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestShareLinkEndToEnd(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	cfg := &Config{
+		RingBufferSize:  1024,
+		UploadDir:       t.TempDir(),
+		TailReplaySize:  256,
+		ClientQueueSize: 16,
+		AuthSecret:      "s3cret",
+	}
+	sm := NewSessionManager(cfg, logger)
+	defer sm.CloseAll()
+	srv := httptest.NewServer(NewServer(cfg, sm, NewFileIndexer(nil, time.Hour, logger), nil, logger))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Mint a view share for one target.
+	req, _ := http.NewRequest("POST", srv.URL+"/api/shares", strings.NewReader(`{"target":"c3-share-missing:0.0","role":"view","ttl":"10m"}`))
+	req.Header.Set("Authorization", "Bearer s3cret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var created struct {
+		Share Share  `json:"share"`
+		URL   string `json:"url"`
+	}
+	json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || created.URL == "" {
+		t.Fatalf("create share failed: %d %+v", resp.StatusCode, created)
+	}
+
+	// Redeem the link; the cookie jar keeps the share cookie.
+	jar, _ := cookiejar.New(nil)
+	holder := &http.Client{Jar: jar}
+	resp, err = holder.Get(created.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// The holder cannot reach other APIs.
+	resp, _ = holder.Get(srv.URL + "/api/sessions")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 for share holder on /api/sessions, got %d", resp.StatusCode)
+	}
+
+	// The holder can open the shared target's WebSocket...
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/s/c3-share-missing:0.0/ws"
+	conn, _, err := websocket.Dial(ctx, wsURL, &websocket.DialOptions{HTTPClient: holder})
+	if err != nil {
+		t.Fatalf("share holder ws dial failed: %v", err)
+	}
+	defer conn.CloseNow()
+	hello, _ := json.Marshal(HelloMsg{Type: "hello", ReplayMode: "tail"})
+	conn.Write(ctx, websocket.MessageText, hello)
+	if _, _, err := conn.Read(ctx); err != nil {
+		t.Fatalf("expected status message, got %v", err)
+	}
+
+	// ...but not another one.
+	otherURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/s/other:0.0/ws"
+	if _, _, err := websocket.Dial(ctx, otherURL, &websocket.DialOptions{HTTPClient: holder}); err == nil {
+		t.Fatal("expected share holder to be refused another target")
+	}
+
+	// Revoking the share disconnects the holder.
+	req, _ = http.NewRequest("DELETE", srv.URL+"/api/shares/"+created.Share.ID, nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("revoke failed: %v %v", err, resp.StatusCode)
+	}
+	resp.Body.Close()
+
+	for {
+		if _, _, err := conn.Read(ctx); err != nil {
+			if websocket.CloseStatus(err) != websocket.StatusPolicyViolation {
+				t.Fatalf("expected policy violation close, got %v", err)
+			}
+			break
+		}
+	}
+}
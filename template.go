@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SessionTemplate describes the windows and panes of a session that
+// /api/new-session can create by name. Templates are read from the JSON
+// file given with --session-templates, an object keyed by template name.
+type SessionTemplate struct {
+	Cwd     string            `json:"cwd,omitempty"` // default for every pane
+	Env     map[string]string `json:"env,omitempty"` // set in every pane; panes can override
+	Windows []TemplateWindow  `json:"windows"`
+}
+
+// TemplateWindow is one window of a SessionTemplate. A window without panes
+// gets a single default one.
+type TemplateWindow struct {
+	Name   string         `json:"name,omitempty"`
+	Cwd    string         `json:"cwd,omitempty"`    // default for the window's panes
+	Layout string         `json:"layout,omitempty"` // tmux layout applied once the panes exist, e.g. "main-vertical"
+	Panes  []TemplatePane `json:"panes,omitempty"`
+}
+
+// TemplatePane is one pane of a TemplateWindow. Every pane after the first
+// is split from the one before it.
+type TemplatePane struct {
+	SpawnOptions
+	Split   string `json:"split,omitempty"`   // SplitHorizontal or SplitVertical (default)
+	Primary bool   `json:"primary,omitempty"` // the pane to open; defaults to the first
+}
+
+// LoadSessionTemplates reads and validates a session templates file.
+// Working directories starting with "~/" are resolved against $HOME.
+func LoadSessionTemplates(path string) (map[string]SessionTemplate, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open session templates: %w", err)
+	}
+	defer f.Close()
+	var templates map[string]SessionTemplate
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&templates); err != nil {
+		return nil, fmt.Errorf("parse session templates %s: %w", path, err)
+	}
+	home, _ := os.UserHomeDir()
+	for name, t := range templates {
+		if err := t.validate(); err != nil {
+			return nil, fmt.Errorf("session template %q: %w", name, err)
+		}
+		t.Cwd = expandHome(t.Cwd, home)
+		for i := range t.Windows {
+			w := &t.Windows[i]
+			w.Cwd = expandHome(w.Cwd, home)
+			for j := range w.Panes {
+				w.Panes[j].Cwd = expandHome(w.Panes[j].Cwd, home)
+			}
+		}
+		templates[name] = t
+	}
+	return templates, nil
+}
+
+func (t SessionTemplate) validate() error {
+	if len(t.Windows) == 0 {
+		return fmt.Errorf("no windows")
+	}
+	primaries := 0
+	for i, w := range t.Windows {
+		for j, p := range w.Panes {
+			if p.Split != "" && p.Split != SplitHorizontal && p.Split != SplitVertical {
+				return fmt.Errorf("window %d pane %d: split must be horizontal or vertical", i, j)
+			}
+			if p.Primary {
+				primaries++
+			}
+		}
+	}
+	if primaries > 1 {
+		return fmt.Errorf("more than one primary pane")
+	}
+	return nil
+}
+
+// expandHome resolves a leading "~/" against home.
+func expandHome(path, home string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok && home != "" {
+		return filepath.Join(home, rest)
+	}
+	if path == "~" && home != "" {
+		return home
+	}
+	return path
+}
+
+// Build creates session name from the template and returns the target of
+// its primary pane. If a later step fails, the half-built session is
+// killed.
+func (t SessionTemplate) Build(name string) (string, error) {
+	if err := CheckSessionName(name); err != nil {
+		return "", err
+	}
+	// Once created, the session is addressed by the name tmux reports for
+	// it, which NewWindow and KillSession match exactly.
+	var session, primary string
+	for i, w := range t.Windows {
+		panes := w.Panes
+		if len(panes) == 0 {
+			panes = []TemplatePane{{}}
+		}
+		var target string
+		for j, p := range panes {
+			var err error
+			opts := t.spawnOptions(w, p)
+			switch {
+			case i == 0 && j == 0:
+				if target, err = CreateSession(name, w.Name, opts); err == nil {
+					session, _, _ = strings.Cut(target, ":")
+				}
+			case j == 0:
+				target, err = NewWindow(session, w.Name, opts)
+			default:
+				target, err = SplitPane(target, p.Split, opts)
+			}
+			if err != nil {
+				if session != "" {
+					KillSession(session)
+				}
+				return "", err
+			}
+			if primary == "" || p.Primary {
+				primary = target
+			}
+		}
+		if w.Layout != "" {
+			if err := SelectLayout(target, w.Layout); err != nil {
+				KillSession(session)
+				return "", err
+			}
+		}
+	}
+	return primary, nil
+}
+
+// spawnOptions returns how a pane of window w starts, filling in the
+// defaults of the window and template.
+func (t SessionTemplate) spawnOptions(w TemplateWindow, p TemplatePane) SpawnOptions {
+	opts := p.SpawnOptions
+	if opts.Cwd == "" {
+		opts.Cwd = w.Cwd
+	}
+	if opts.Cwd == "" {
+		opts.Cwd = t.Cwd
+	}
+	if len(t.Env) > 0 {
+		env := maps.Clone(t.Env)
+		maps.Copy(env, p.Env)
+		opts.Env = env
+	}
+	return opts
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadSessionTemplates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "templates.json")
+	os.WriteFile(path, []byte(`{
+		"claude": {
+			"cwd": "~/src/app",
+			"env": {"EDITOR": "vim", "MODE": "dev"},
+			"windows": [
+				{"name": "main", "panes": [
+					{"command": "claude --resume", "primary": true},
+					{"command": "npm test -- --watch", "split": "horizontal", "env": {"MODE": "test"}}
+				]},
+				{"name": "shell", "cwd": "/tmp"}
+			]
+		}
+	}`), 0o644)
+
+	templates, err := LoadSessionTemplates(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl, ok := templates["claude"]
+	if !ok {
+		t.Fatalf("templates %v", templates)
+	}
+	home, _ := os.UserHomeDir()
+	if want := filepath.Join(home, "src/app"); tmpl.Cwd != want {
+		t.Fatalf("cwd %q, want %q", tmpl.Cwd, want)
+	}
+
+	main := tmpl.Windows[0]
+	got := tmpl.spawnOptions(main, main.Panes[1])
+	want := SpawnOptions{
+		Cwd:     tmpl.Cwd,
+		Command: "npm test -- --watch",
+		Env:     map[string]string{"EDITOR": "vim", "MODE": "test"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("pane options %+v, want %+v", got, want)
+	}
+	if got := tmpl.spawnOptions(tmpl.Windows[1], TemplatePane{}); got.Cwd != "/tmp" {
+		t.Fatalf("window cwd not applied: %+v", got)
+	}
+}
+
+func TestLoadSessionTemplatesInvalid(t *testing.T) {
+	tests := map[string]string{
+		"no windows":     `{"a": {"windows": []}}`,
+		"split":          `{"a": {"windows": [{"panes": [{}, {"split": "diagonal"}]}]}}`,
+		"primary":        `{"a": {"windows": [{"panes": [{"primary": true}, {"primary": true}]}]}}`,
+		"unknown field":  `{"a": {"windows": [{"pane": []}]}}`,
+		"not an object":  `[]`,
+		"malformed JSON": `{"a": `,
+	}
+	for name, content := range tests {
+		path := filepath.Join(t.TempDir(), "templates.json")
+		os.WriteFile(path, []byte(content), 0o644)
+		if _, err := LoadSessionTemplates(path); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if _, err := LoadSessionTemplates(filepath.Join(t.TempDir(), "missing.json")); err == nil || !strings.Contains(err.Error(), "open") {
+		t.Errorf("missing file: %v", err)
+	}
+}
+
+func TestBuildRejectsInvalidNames(t *testing.T) {
+	tmpl := SessionTemplate{Windows: []TemplateWindow{{Name: "main"}}}
+	for _, name := range []string{"", "a.b", "a:b"} {
+		if _, err := tmpl.Build(name); err == nil {
+			t.Errorf("%q: expected an error", name)
+		}
+	}
+}
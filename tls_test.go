@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"log/slog"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestEnsureSelfSignedCert(t *testing.T) {
+	dir := t.TempDir()
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	certFile, keyFile, err := EnsureSelfSignedCert(dir, logger)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("load generated pair: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf.IsCA || leaf.KeyUsage&x509.KeyUsageCertSign != 0 {
+		t.Error("self-signed certificate must not be able to sign other certificates")
+	}
+	if err := leaf.VerifyHostname("localhost"); err != nil {
+		t.Errorf("certificate does not cover localhost: %v", err)
+	}
+	if err := leaf.VerifyHostname("127.0.0.1"); err != nil {
+		t.Errorf("certificate does not cover 127.0.0.1: %v", err)
+	}
+
+	if info, err := os.Stat(keyFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected key file mode 0600, got %v", info.Mode().Perm())
+	}
+
+	// A second start reuses the persisted certificate.
+	first, _ := os.ReadFile(certFile)
+	if _, _, err := EnsureSelfSignedCert(dir, logger); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	second, _ := os.ReadFile(certFile)
+	if !bytes.Equal(first, second) {
+		t.Error("expected certificate to be reused across restarts")
+	}
+
+	// A CA certificate left by an earlier version is replaced.
+	caPEM, caKeyPEM := generateCACert(t)
+	os.WriteFile(certFile, caPEM, 0644)
+	os.WriteFile(keyFile, caKeyPEM, 0600)
+	if _, _, err := EnsureSelfSignedCert(dir, logger); err != nil {
+		t.Fatalf("regenerate: %v", err)
+	}
+	if replaced, _ := os.ReadFile(certFile); bytes.Equal(replaced, caPEM) {
+		t.Error("expected a CA certificate to be regenerated")
+	}
+}
+
+// generateCACert returns a self-signed CA certificate and key in PEM.
+func generateCACert(t *testing.T) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
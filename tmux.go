@@ -1,10 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os/exec"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -12,19 +16,30 @@ import (
 
 // ResolvePaneTTY queries tmux for the PTY device path of a given pane target.
 func ResolvePaneTTY(target string) (string, error) {
-	cmd := exec.Command("tmux", "display-message", "-p", "-t", target, "#{pane_tty}")
+	tty, _, _, err := ResolvePane(target)
+	return tty, err
+}
+
+// ResolvePane queries tmux for the PTY device path and size of a pane.
+func ResolvePane(target string) (tty string, cols, rows int, err error) {
+	cmd := exec.Command("tmux", "display-message", "-p", "-t", target, "#{pane_tty} #{pane_width} #{pane_height}")
 	out, err := cmd.Output()
 	if err != nil {
-		return "", fmt.Errorf("tmux query failed: %w", err)
+		return "", 0, 0, fmt.Errorf("tmux query failed: %w", err)
 	}
-	tty := strings.TrimSpace(string(out))
-	if tty == "" {
-		return "", fmt.Errorf("empty pane_tty for target %q", target)
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return "", 0, 0, fmt.Errorf("empty pane_tty for target %q", target)
 	}
+	tty = fields[0]
 	if !strings.HasPrefix(tty, "/dev/") {
-		return "", fmt.Errorf("unexpected pane_tty value: %q", tty)
+		return "", 0, 0, fmt.Errorf("unexpected pane_tty value: %q", tty)
+	}
+	if len(fields) == 3 {
+		cols, _ = strconv.Atoi(fields[1])
+		rows, _ = strconv.Atoi(fields[2])
 	}
-	return tty, nil
+	return tty, cols, rows, nil
 }
 
 // RenameWindow renames the tmux window containing the given pane target.
@@ -45,15 +60,237 @@ func KillWindow(target string) error {
 	return nil
 }
 
-// CreateSession creates a new detached tmux session with the given name.
-func CreateSession(name string) error {
-	cmd := exec.Command("tmux", "new-session", "-d", "-s", name)
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("tmux new-session failed: %w", err)
+// paneTargetFormat prints a pane's "session:window.pane" target.
+const paneTargetFormat = "#{session_name}:#{window_index}.#{pane_index}"
+
+// CheckSessionName rejects a session name tmux would not keep as given. tmux
+// replaces "." and ":" with "_", since targets use them to separate the
+// window and pane.
+func CheckSessionName(name string) error {
+	if name == "" {
+		return errors.New("empty session name")
+	}
+	if strings.ContainsAny(name, ".:") {
+		return fmt.Errorf("session name %q contains \".\" or \":\"", name)
+	}
+	return nil
+}
+
+// CreateSession creates a new detached tmux session with the given name,
+// its first window started with opts and named window if that is set, and
+// returns the target of its pane.
+func CreateSession(name, window string, opts SpawnOptions) (string, error) {
+	args := []string{"new-session", "-d", "-P", "-F", paneTargetFormat, "-s", name}
+	if window != "" {
+		args = append(args, "-n", window)
+	}
+	out, err := exec.Command("tmux", append(args, opts.args()...)...).Output()
+	if err != nil {
+		return "", fmt.Errorf("tmux new-session failed: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// SpawnOptions is how a new or respawned pane starts. Empty fields take
+// tmux's defaults: the session's directory, the default shell and the
+// session's environment.
+type SpawnOptions struct {
+	Cwd     string            `json:"cwd,omitempty"`
+	Command string            `json:"command,omitempty"` // run by the shell instead of it
+	Env     map[string]string `json:"env,omitempty"`
+}
+
+// args returns the tmux flags and trailing command for o.
+func (o SpawnOptions) args() []string {
+	var args []string
+	if o.Cwd != "" {
+		args = append(args, "-c", o.Cwd)
+	}
+	keys := make([]string, 0, len(o.Env))
+	for k := range o.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		args = append(args, "-e", k+"="+o.Env[k])
+	}
+	if o.Command != "" {
+		args = append(args, o.Command)
+	}
+	return args
+}
+
+// PaneTarget returns the "session:window.pane" target of a pane, or of the
+// active pane of a window or session.
+func PaneTarget(target string) (string, error) {
+	out, err := exec.Command("tmux", "display-message", "-p", "-t", target, paneTargetFormat).Output()
+	if err != nil {
+		return "", fmt.Errorf("tmux query failed: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// paneID returns the stable ID ("%N") of a pane, which keeps naming it while
+// its session is renamed or its window moves.
+func paneID(target string) (string, error) {
+	out, err := exec.Command("tmux", "display-message", "-p", "-t", target, "#{pane_id}").Output()
+	if err != nil {
+		return "", fmt.Errorf("tmux query failed: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// NewWindow opens a window at the next free index of the session named
+// exactly session without switching to it, and returns the target of its
+// pane.
+func NewWindow(session, name string, opts SpawnOptions) (string, error) {
+	if err := CheckSessionName(session); err != nil {
+		return "", err
+	}
+	args := []string{"new-window", "-d", "-P", "-F", paneTargetFormat, "-t", "=" + session + ":"}
+	if name != "" {
+		args = append(args, "-n", name)
+	}
+	out, err := exec.Command("tmux", append(args, opts.args()...)...).Output()
+	if err != nil {
+		return "", fmt.Errorf("tmux new-window failed: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// SplitPane splits a pane in two, placing the new pane beside it for
+// SplitHorizontal or below it for SplitVertical, and returns the new pane's
+// target. The split pane stays active.
+func SplitPane(target, split string, opts SpawnOptions) (string, error) {
+	dir := "-v"
+	if split == SplitHorizontal {
+		dir = "-h"
+	}
+	args := []string{"split-window", "-d", "-P", "-F", paneTargetFormat, "-t", target, dir}
+	out, err := exec.Command("tmux", append(args, opts.args()...)...).Output()
+	if err != nil {
+		return "", fmt.Errorf("tmux split-window failed: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// SelectLayout arranges the panes of the window containing target in one of
+// tmux's layouts, such as "tiled" or "main-vertical".
+func SelectLayout(target, layout string) error {
+	if err := exec.Command("tmux", "select-layout", "-t", target, layout).Run(); err != nil {
+		return fmt.Errorf("tmux select-layout failed: %w", err)
+	}
+	return nil
+}
+
+// SelectWindow makes a window current in its session and returns the target
+// of its active pane.
+func SelectWindow(target string) (string, error) {
+	if err := exec.Command("tmux", "select-window", "-t", target).Run(); err != nil {
+		return "", fmt.Errorf("tmux select-window failed: %w", err)
+	}
+	return PaneTarget(target)
+}
+
+// KillPane kills a pane and returns the target of the pane that is active in
+// its window afterwards, or "" if it was the window's last pane.
+func KillPane(target string) (string, error) {
+	out, err := exec.Command("tmux", "display-message", "-p", "-t", target, "#{window_id}").Output()
+	if err != nil {
+		return "", fmt.Errorf("tmux query failed: %w", err)
+	}
+	window := strings.TrimSpace(string(out))
+	if err := exec.Command("tmux", "kill-pane", "-t", target).Run(); err != nil {
+		return "", fmt.Errorf("tmux kill-pane failed: %w", err)
+	}
+	next, err := PaneTarget(window)
+	if err != nil {
+		return "", nil
+	}
+	return next, nil
+}
+
+// KillSession kills the tmux session named exactly name and every window in
+// it. Without the "=", tmux would fall back to the first session whose name
+// starts with or matches name as a pattern.
+func KillSession(name string) error {
+	if err := CheckSessionName(name); err != nil {
+		return err
+	}
+	if err := exec.Command("tmux", "kill-session", "-t", "="+name).Run(); err != nil {
+		return fmt.Errorf("tmux kill-session failed: %w", err)
 	}
 	return nil
 }
 
+// RenameSession renames the session containing target and returns target's
+// pane under the new name.
+func RenameSession(target, name string) (string, error) {
+	if err := CheckSessionName(name); err != nil {
+		return "", err
+	}
+	id, err := paneID(target)
+	if err != nil {
+		return "", err
+	}
+	if err := exec.Command("tmux", "rename-session", "-t", target, name).Run(); err != nil {
+		return "", fmt.Errorf("tmux rename-session failed: %w", err)
+	}
+	return PaneTarget(id)
+}
+
+// MoveWindow moves the window containing src to dst, a "session:" (next free
+// index) or "session:index" target, and returns src's pane target there. The
+// session in dst is matched exactly.
+func MoveWindow(src, dst string) (string, error) {
+	id, err := paneID(src)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(dst, "=") && !strings.HasPrefix(dst, "$") {
+		dst = "=" + dst
+	}
+	if err := exec.Command("tmux", "move-window", "-d", "-s", src, "-t", dst).Run(); err != nil {
+		return "", fmt.Errorf("tmux move-window failed: %w", err)
+	}
+	return PaneTarget(id)
+}
+
+// SwapWindow swaps the windows containing src and dst, and returns src's
+// pane target after the swap.
+func SwapWindow(src, dst string) (string, error) {
+	id, err := paneID(src)
+	if err != nil {
+		return "", err
+	}
+	if err := exec.Command("tmux", "swap-window", "-d", "-s", src, "-t", dst).Run(); err != nil {
+		return "", fmt.Errorf("tmux swap-window failed: %w", err)
+	}
+	return PaneTarget(id)
+}
+
+// RespawnPane kills whatever a pane is running and starts it again, with
+// opts or, if opts is empty, the command it was started with.
+func RespawnPane(target string, opts SpawnOptions) (string, error) {
+	args := append([]string{"respawn-pane", "-k", "-t", target}, opts.args()...)
+	if err := exec.Command("tmux", args...).Run(); err != nil {
+		return "", fmt.Errorf("tmux respawn-pane failed: %w", err)
+	}
+	return PaneTarget(target)
+}
+
+// PanePipe reports whether a pane already has a pipe-pane command running,
+// and the command the user declared for it in the @c3-user-pipe pane option.
+// tmux itself only exposes whether a pipe exists, not its command.
+func PanePipe(target string) (piped bool, userCmd string, err error) {
+	out, err := exec.Command("tmux", "display-message", "-p", "-t", target, "#{pane_pipe}\t#{@c3-user-pipe}").Output()
+	if err != nil {
+		return false, "", fmt.Errorf("tmux query failed: %w", err)
+	}
+	flag, userCmd, _ := strings.Cut(strings.TrimSuffix(string(out), "\n"), "\t")
+	return flag == "1", userCmd, nil
+}
+
 // PaneDimensions returns the current cols and rows of a tmux pane.
 func PaneDimensions(target string) (cols, rows int, err error) {
 	cmd := exec.Command("tmux", "display-message", "-p", "-t", target, "#{pane_width} #{pane_height}")
@@ -65,32 +302,222 @@ func PaneDimensions(target string) (cols, rows int, err error) {
 	return
 }
 
-// CursorPosition returns the cursor position (0-indexed col, row) of a tmux pane.
-func CursorPosition(target string) (col, row int, err error) {
-	cmd := exec.Command("tmux", "display-message", "-p", "-t", target, "#{cursor_x} #{cursor_y}")
-	out, err := cmd.Output()
+// PaneSize records a pane's size, its window's size and the window's own
+// window-size option ("" when inherited), so a resize can be undone.
+type PaneSize struct {
+	Cols, Rows             int
+	WindowCols, WindowRows int
+	WindowPanes            int
+	WindowSizeOption       string
+}
+
+// GetPaneSize returns the current size of a pane and its window.
+func GetPaneSize(target string) (PaneSize, error) {
+	var ps PaneSize
+	out, err := exec.Command("tmux", "display-message", "-p", "-t", target,
+		"#{pane_width} #{pane_height} #{window_width} #{window_height} #{window_panes}").Output()
 	if err != nil {
-		return 0, 0, err
+		return ps, fmt.Errorf("tmux display-message failed: %w", err)
 	}
-	_, err = fmt.Sscanf(strings.TrimSpace(string(out)), "%d %d", &col, &row)
-	return
+	if _, err := fmt.Sscanf(strings.TrimSpace(string(out)), "%d %d %d %d %d",
+		&ps.Cols, &ps.Rows, &ps.WindowCols, &ps.WindowRows, &ps.WindowPanes); err != nil {
+		return ps, fmt.Errorf("unexpected pane size %q: %w", strings.TrimSpace(string(out)), err)
+	}
+	out, err = exec.Command("tmux", "show-options", "-w", "-v", "-t", target, "window-size").Output()
+	if err != nil {
+		return ps, fmt.Errorf("tmux show-options failed: %w", err)
+	}
+	ps.WindowSizeOption = strings.TrimSpace(string(out))
+	return ps, nil
 }
 
-// CapturePane returns the visible content plus scrollback history of a tmux
-// pane with ANSI escape sequences intact. The scrollbackLines parameter
-// controls how many lines of history before the visible area to include.
-func CapturePane(target string, scrollbackLines int) ([]byte, error) {
-	// -e: include escape sequences (colors, etc.)
-	// -p: output to stdout
-	// -t: target pane
-	// -S: start line (negative = lines before visible area)
-	startLine := fmt.Sprintf("-%d", scrollbackLines)
-	cmd := exec.Command("tmux", "capture-pane", "-e", "-p", "-t", target, "-S", startLine)
-	out, err := cmd.Output()
+// ResizePane sets a pane's size. A pane alone in its window is resized by
+// resizing the window, which also sets the window's window-size option to
+// manual so tmux stops following its clients' sizes; otherwise the pane is
+// resized within the window.
+func ResizePane(target string, cols, rows int) error {
+	ps, err := GetPaneSize(target)
 	if err != nil {
-		return nil, fmt.Errorf("tmux capture-pane failed: %w", err)
+		return err
+	}
+	verb := "resize-pane"
+	if ps.WindowPanes == 1 {
+		verb = "resize-window"
 	}
-	return out, nil
+	if err := exec.Command("tmux", verb, "-t", target, "-x", strconv.Itoa(cols), "-y", strconv.Itoa(rows)).Run(); err != nil {
+		return fmt.Errorf("tmux %s failed: %w", verb, err)
+	}
+	return nil
+}
+
+// RestorePaneSize undoes ResizePane: it resizes the window and pane back to
+// orig and restores the window's window-size option.
+func RestorePaneSize(target string, orig PaneSize) error {
+	cmds := [][]string{{"resize-window", "-t", target, "-x", strconv.Itoa(orig.WindowCols), "-y", strconv.Itoa(orig.WindowRows)}}
+	if orig.WindowPanes > 1 {
+		cmds = append(cmds, []string{"resize-pane", "-t", target, "-x", strconv.Itoa(orig.Cols), "-y", strconv.Itoa(orig.Rows)})
+	}
+	if orig.WindowSizeOption == "" {
+		cmds = append(cmds, []string{"set-option", "-w", "-u", "-t", target, "window-size"})
+	} else {
+		cmds = append(cmds, []string{"set-option", "-w", "-t", target, "window-size", orig.WindowSizeOption})
+	}
+	for _, args := range cmds {
+		if err := exec.Command("tmux", args...).Run(); err != nil {
+			return fmt.Errorf("tmux %s failed: %w", args[0], err)
+		}
+	}
+	return nil
+}
+
+// WindowPane is one pane of a window, as listed in a LayoutMsg.
+type WindowPane struct {
+	ID     string `json:"id"`     // tmux pane ID, e.g. "%3"
+	Target string `json:"target"` // "session:window.pane"
+	Active bool   `json:"active"`
+}
+
+// WindowInfo describes a tmux window's layout and panes.
+type WindowInfo struct {
+	ID      string // tmux window ID, e.g. "@1"
+	Session string
+	Layout  string // #{window_layout}
+	Panes   []WindowPane
+}
+
+// ListWindowPanes returns the layout and panes of a tmux window such as
+// "claude:0".
+func ListWindowPanes(window string) (WindowInfo, error) {
+	out, err := exec.Command("tmux", "list-panes", "-t", window, "-F",
+		"#{window_id}\t#{session_name}\t#{window_index}\t#{window_layout}\t#{pane_id}\t#{pane_index}\t#{pane_active}").Output()
+	if err != nil {
+		return WindowInfo{}, fmt.Errorf("tmux list-panes failed: %w", err)
+	}
+	var info WindowInfo
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		parts := strings.Split(line, "\t")
+		if len(parts) != 7 {
+			continue
+		}
+		info.ID, info.Session, info.Layout = parts[0], parts[1], parts[3]
+		info.Panes = append(info.Panes, WindowPane{
+			ID:     parts[4],
+			Target: fmt.Sprintf("%s:%s.%s", parts[1], parts[2], parts[5]),
+			Active: parts[6] == "1",
+		})
+	}
+	if len(info.Panes) == 0 {
+		return WindowInfo{}, fmt.Errorf("no panes in window %q", window)
+	}
+	return info, nil
+}
+
+// paneStateFormat lists the pane attributes CapturePaneState reads, in the
+// order they are parsed.
+const paneStateFormat = "#{pane_width} #{pane_height} #{cursor_x} #{cursor_y} " +
+	"#{alternate_on} #{alternate_saved_x} #{alternate_saved_y} " +
+	"#{scroll_region_upper} #{scroll_region_lower} #{origin_flag} #{wrap_flag} " +
+	"#{insert_flag} #{cursor_flag} #{keypad_cursor_flag} #{keypad_flag} " +
+	"#{mouse_standard_flag} #{mouse_button_flag} #{mouse_any_flag} " +
+	"#{mouse_utf8_flag} #{mouse_sgr_flag}"
+
+// CapturePaneState returns a byte stream that reproduces a pane's screen,
+// scrollback, cursor and terminal modes when written to a fresh terminal of
+// the returned size. It is used to seed the server-side terminal emulator.
+// While the alternate screen is active tmux keeps no history for it, and the
+// primary screen is captured without scrollback.
+func CapturePaneState(target string, scrollbackLines int) (data []byte, cols, rows int, err error) {
+	out, err := exec.Command("tmux", "display-message", "-p", "-t", target, paneStateFormat).Output()
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("tmux display-message failed: %w", err)
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) != strings.Count(paneStateFormat, "#{") {
+		return nil, 0, 0, fmt.Errorf("unexpected pane state %q", strings.TrimSpace(string(out)))
+	}
+	v := make([]int, len(fields))
+	for i, f := range fields {
+		n, _ := strconv.ParseInt(f, 10, 64)
+		v[i] = int(n)
+	}
+	cols, rows = v[0], v[1]
+	cursorX, cursorY := v[2], v[3]
+	altOn, savedX, savedY := v[4] == 1, v[5], v[6]
+	upper, lower := v[7], v[8]
+
+	capture := func(args ...string) ([][]byte, error) {
+		args = append([]string{"capture-pane", "-e", "-p", "-t", target}, args...)
+		out, err := exec.Command("tmux", args...).Output()
+		if err != nil {
+			return nil, fmt.Errorf("tmux capture-pane failed: %w", err)
+		}
+		return bytes.Split(bytes.TrimSuffix(out, []byte("\n")), []byte("\n")), nil
+	}
+
+	var buf bytes.Buffer
+	var primary [][]byte
+	if altOn {
+		primary, err = capture("-a", "-q")
+	} else {
+		primary, err = capture("-S", fmt.Sprintf("-%d", scrollbackLines))
+	}
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	for i, line := range primary {
+		if i > 0 {
+			buf.WriteString("\x1b[m\r\n")
+		}
+		buf.Write(line)
+	}
+	buf.WriteString("\x1b[m")
+
+	if altOn {
+		alt, err := capture()
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		if savedX >= 0 && savedX < cols && savedY >= 0 && savedY < rows {
+			fmt.Fprintf(&buf, "\x1b[%d;%dH", savedY+1, savedX+1)
+		}
+		buf.WriteString("\x1b[?1049h")
+		for i, line := range alt {
+			fmt.Fprintf(&buf, "\x1b[%d;1H", i+1)
+			buf.Write(line)
+			buf.WriteString("\x1b[m")
+		}
+	}
+
+	if upper != 0 || lower != rows-1 {
+		fmt.Fprintf(&buf, "\x1b[%d;%dr", upper+1, lower+1)
+	}
+	row := cursorY + 1
+	if v[9] == 1 {
+		buf.WriteString("\x1b[?6h")
+		row -= upper
+	}
+	fmt.Fprintf(&buf, "\x1b[%d;%dH", row, cursorX+1)
+	modes := []struct {
+		on  bool
+		seq string
+	}{
+		{v[10] == 0, "\x1b[?7l"},
+		{v[11] == 1, "\x1b[4h"},
+		{v[12] == 0, "\x1b[?25l"},
+		{v[13] == 1, "\x1b[?1h"},
+		{v[14] == 1, "\x1b="},
+		{v[15] == 1, "\x1b[?1000h"},
+		{v[16] == 1, "\x1b[?1002h"},
+		{v[17] == 1, "\x1b[?1003h"},
+		{v[18] == 1, "\x1b[?1005h"},
+		{v[19] == 1, "\x1b[?1006h"},
+	}
+	for _, m := range modes {
+		if m.on {
+			buf.WriteString(m.seq)
+		}
+	}
+	return buf.Bytes(), cols, rows, nil
 }
 
 // TmuxSession represents a tmux session with its windows and panes.
@@ -196,6 +623,60 @@ func ListSessions() ([]TmuxSession, error) {
 	return result, nil
 }
 
+// PaneRecord is one pane listed by ListAllPanes, with the IDs tmux keeps for
+// its session, window and pane across renames and moves.
+type PaneRecord struct {
+	SessionID   string // "$N"
+	SessionName string
+	WindowID    string // "@N"
+	WindowIndex string
+	WindowName  string
+	PaneID      string // "%N"
+	PaneIndex   string
+	CurrentCmd  string
+	ClaudeState string
+	CurrentPath string
+}
+
+// ListAllPanes lists every pane on the tmux server, in session, window and
+// pane order. A server that is not running has no panes.
+func ListAllPanes() ([]PaneRecord, error) {
+	out, err := exec.Command("tmux", "list-panes", "-a", "-F",
+		"#{session_id}\t#{session_name}\t#{window_id}\t#{window_index}\t#{window_name}\t#{pane_id}\t#{pane_index}\t#{pane_current_command}\t#{@claude-state}\t#{pane_current_path}").Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && noTmuxServer(string(exitErr.Stderr)) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("tmux list-panes failed: %w", err)
+	}
+	var records []PaneRecord
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		parts := strings.SplitN(line, "\t", 10)
+		if len(parts) != 10 {
+			continue
+		}
+		records = append(records, PaneRecord{
+			SessionID:   parts[0],
+			SessionName: parts[1],
+			WindowID:    parts[2],
+			WindowIndex: parts[3],
+			WindowName:  parts[4],
+			PaneID:      parts[5],
+			PaneIndex:   parts[6],
+			CurrentCmd:  parts[7],
+			ClaudeState: parts[8],
+			CurrentPath: parts[9],
+		})
+	}
+	return records, nil
+}
+
+// noTmuxServer reports whether tmux's stderr says no server is running.
+func noTmuxServer(stderr string) bool {
+	return strings.Contains(stderr, "no server running") || strings.Contains(stderr, "error connecting to")
+}
+
 type PaneState int
 
 const (
@@ -205,28 +686,46 @@ const (
 
 // PaneEvent is emitted by PaneMonitor when pane state changes.
 type PaneEvent struct {
-	State  PaneState
-	TTY    string // non-empty when State == PaneStateConnected
-	NewTTY bool   // true if the TTY path changed from the previous known path
+	State   PaneState
+	TTY     string // non-empty when State == PaneStateConnected
+	NewTTY  bool   // true if the TTY path changed from the previous known path
+	Cols    int    // pane size, when State == PaneStateConnected
+	Rows    int
+	Resized bool // true if only the pane's size changed
 }
 
-// PaneMonitor periodically checks for the configured tmux pane.
+// paneEventFallback is how often a PaneMonitor that receives tmux events
+// still polls, in case one was missed.
+const paneEventFallback = 30 * time.Second
+
+// PaneMonitor watches the configured tmux pane. With a ControlMode it checks
+// the pane as soon as tmux reports a change to its session, and polls only
+// every paneEventFallback; without one, or while no control connection can
+// be made, it polls every interval.
 type PaneMonitor struct {
 	target   string
 	interval time.Duration
+	control  *ControlMode // nil to poll only
 	logger   *slog.Logger
-
-	mu       sync.Mutex
-	state    PaneState
-	lastTTY  string
-	eventsCh chan PaneEvent
+	kick     chan struct{}
+
+	mu        sync.Mutex
+	state     PaneState
+	lastTTY   string
+	lastCols  int
+	lastRows  int
+	lastCheck time.Time
+	watching  string // tmux session whose events are received
+	eventsCh  chan PaneEvent
 }
 
-func NewPaneMonitor(target string, interval time.Duration, logger *slog.Logger) *PaneMonitor {
+func NewPaneMonitor(target string, interval time.Duration, control *ControlMode, logger *slog.Logger) *PaneMonitor {
 	return &PaneMonitor{
 		target:   target,
 		interval: interval,
+		control:  control,
 		logger:   logger,
+		kick:     make(chan struct{}, 1),
 		state:    PaneStateMissing,
 		eventsCh: make(chan PaneEvent, 8),
 	}
@@ -275,43 +774,134 @@ func (m *PaneMonitor) ForceCheck() {
 
 // Run starts the monitor loop. It blocks until ctx is cancelled.
 func (m *PaneMonitor) Run(ctx context.Context) {
+	var conn *ControlConn // connection events are received on
+	if m.control != nil {
+		defer m.control.Listen(m.onEvent)()
+		defer func() {
+			if conn != nil {
+				m.control.Release(conn)
+			}
+		}()
+	}
+
 	// Do an immediate check before entering the ticker loop.
 	m.check()
 
 	ticker := time.NewTicker(m.interval)
 	defer ticker.Stop()
 
+	rewatch := false
+
 	for {
+		if m.control != nil && (conn == nil || rewatch) {
+			conn = m.watch(conn)
+		}
+		var connDone <-chan struct{}
+		if conn != nil {
+			connDone = conn.Done()
+		}
+
+		rewatch = false
 		select {
 		case <-ctx.Done():
 			return
-		case <-ticker.C:
+		case <-m.kick:
+			rewatch = m.check()
+		case <-connDone:
 			m.check()
+			rewatch = true
+		case <-ticker.C:
+			m.mu.Lock()
+			due := conn == nil || time.Since(m.lastCheck) >= paneEventFallback
+			m.mu.Unlock()
+			if due {
+				rewatch = m.check()
+			}
+		}
+	}
+}
+
+// watch returns a control connection to receive events on, replacing current
+// if it has ended or is attached elsewhere: the pane's session, or while the
+// pane is missing any session, which still reports sessions being created.
+func (m *PaneMonitor) watch(current *ControlConn) *ControlConn {
+	out, err := exec.Command("tmux", "display-message", "-p", "-t", m.Target(), "#{session_name}").Output()
+	if err != nil || len(bytes.TrimSpace(out)) == 0 {
+		out, err = exec.Command("tmux", "list-sessions", "-F", "#{session_name}").Output()
+	}
+	session, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	if current != nil {
+		m.mu.Lock()
+		same := session == m.watching
+		m.mu.Unlock()
+		select {
+		case <-current.Done():
+		default:
+			if same || err != nil {
+				return current
+			}
+		}
+		m.control.Release(current)
+	}
+	if err != nil || session == "" {
+		return nil // no tmux server; keep polling
+	}
+	conn, err := m.control.Acquire(session)
+	if err != nil {
+		m.logger.Debug("tmux events unavailable, polling", "error", err)
+		return nil
+	}
+	m.mu.Lock()
+	m.watching = session
+	m.mu.Unlock()
+	return conn
+}
+
+// onEvent triggers a check on notifications that can affect which pane the
+// target names or its PTY.
+func (m *PaneMonitor) onEvent(ev ControlEvent) {
+	switch ev.Name {
+	case "sessions-changed", "session-renamed":
+	case "layout-change", "window-add", "window-close", "unlinked-window-close", "subscription-changed":
+		m.mu.Lock()
+		watching := m.watching
+		m.mu.Unlock()
+		if ev.Session != watching {
+			return
 		}
+	default:
+		return
+	}
+	select {
+	case m.kick <- struct{}{}:
+	default:
 	}
 }
 
-func (m *PaneMonitor) check() {
+// check looks the pane up and reports whether its state or PTY changed.
+func (m *PaneMonitor) check() bool {
 	m.mu.Lock()
 	target := m.target
 	m.mu.Unlock()
 
 	if target == "" {
-		return
+		return false
 	}
 
-	tty, err := ResolvePaneTTY(target)
+	tty, cols, rows, err := ResolvePane(target)
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	m.lastCheck = time.Now()
 
 	if err != nil {
 		if m.state != PaneStateMissing {
 			m.logger.Warn("tmux pane lost", "target", m.target, "error", err)
 			m.state = PaneStateMissing
 			m.emit(PaneEvent{State: PaneStateMissing})
+			return true
 		}
-		return
+		return false
 	}
 
 	// Pane exists.
@@ -322,16 +912,28 @@ func (m *PaneMonitor) check() {
 		m.logger.Info("tmux pane found", "target", m.target, "tty", tty)
 		m.state = PaneStateConnected
 		m.lastTTY = tty
-		m.emit(PaneEvent{State: PaneStateConnected, TTY: tty, NewTTY: true})
-		return
+		m.lastCols, m.lastRows = cols, rows
+		m.emit(PaneEvent{State: PaneStateConnected, TTY: tty, NewTTY: true, Cols: cols, Rows: rows})
+		return true
 	}
 
 	// Already connected — check if TTY changed.
 	if tty != m.lastTTY {
 		m.logger.Info("tmux pane TTY changed", "target", m.target, "old", m.lastTTY, "new", tty)
 		m.lastTTY = tty
-		m.emit(PaneEvent{State: PaneStateConnected, TTY: tty, NewTTY: true})
+		m.lastCols, m.lastRows = cols, rows
+		m.emit(PaneEvent{State: PaneStateConnected, TTY: tty, NewTTY: true, Cols: cols, Rows: rows})
+		return true
+	}
+
+	// Same PTY — check if the pane was resized.
+	if cols != m.lastCols || rows != m.lastRows {
+		m.logger.Info("tmux pane resized", "target", m.target, "cols", cols, "rows", rows)
+		m.lastCols, m.lastRows = cols, rows
+		m.emit(PaneEvent{State: PaneStateConnected, TTY: tty, Cols: cols, Rows: rows, Resized: true})
+		return true
 	}
+	return false
 }
 
 func (m *PaneMonitor) emit(ev PaneEvent) {
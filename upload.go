@@ -20,7 +20,7 @@ var allowedExts = map[string]bool{
 	".webp": true,
 }
 
-func NewUploadHandler(cfg *Config, ptyMgr *PTYManager, logger *slog.Logger) http.HandlerFunc {
+func NewUploadHandler(cfg *Config, ptyMgr PaneIO, audit *AuditLog, logger *slog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		r.Body = http.MaxBytesReader(w, r.Body, cfg.MaxUploadSize)
 
@@ -78,6 +78,12 @@ func NewUploadHandler(cfg *Config, ptyMgr *PTYManager, logger *slog.Logger) http
 			logger.Info("image upload deduplicated", "path", absPath, "hash", hexHash)
 		}
 
+		target := ""
+		if ptyMgr != nil {
+			target = ptyMgr.Target()
+		}
+		audit.RecordRequest(r, "upload", target, absPath)
+
 		// Inject prompt into PTY (if connected to a session)
 		if ptyMgr != nil {
 			prompt := fmt.Sprintf("Analyze this image: %s\n", absPath)
@@ -0,0 +1,1653 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// VTerm is a VT100/xterm screen state machine fed with the same bytes as the
+// session's RingBuffer. It tracks both screens, the cursor, scroll region,
+// SGR pen, character sets and terminal modes, and serializes them with
+// Snapshot so a reconnecting client can be brought to the exact current state
+// without asking the program to redraw.
+type VTerm struct {
+	mu sync.Mutex
+
+	cols, rows    int
+	primary       []vtLine
+	alt           []vtLine
+	altActive     bool
+	scrollback    []vtLine // primary lines scrolled off the top, oldest first
+	maxScrollback int
+
+	x, y        int
+	wrapPending bool
+	pen         vtAttr
+	top, bottom int // scroll region, inclusive
+
+	autowrap, origin, cursorVisible bool
+	insert, newline                 bool
+	appCursor, appKeypad            bool
+	private                         map[int]bool // pass-through DEC modes (mouse, focus, paste)
+	cursorStyle                     int
+
+	charsets [2]byte // G0, G1 designations: 'B' (ASCII) or '0' (DEC graphics)
+	gl       int     // 0 or 1: which of G0/G1 is shifted in
+
+	savedPrimary, savedAlt *vtSaved // DECSC state per screen
+	tabs                   []bool
+	title                  string
+	lastChar               string
+	lastX                  int // column lastChar was printed at
+
+	// parser
+	state   int
+	prefix  byte
+	params  []byte
+	inter   []byte
+	osc     []byte
+	utf8buf []byte
+
+	offset int64 // ring offset of the next byte to be consumed
+}
+
+// vtAttr is the SGR state of a cell or the pen.
+type vtAttr struct {
+	fg, bg vtColor
+	flags  uint16
+}
+
+// vtColor packs a color kind in the top byte (0 default, 1 indexed, 2 RGB)
+// and its value in the low 24 bits.
+type vtColor uint32
+
+const (
+	vtColorDefault vtColor = 0
+	vtColorIndexed vtColor = 1 << 24
+	vtColorRGB     vtColor = 2 << 24
+)
+
+const (
+	vtBold uint16 = 1 << iota
+	vtDim
+	vtItalic
+	vtUnderline
+	vtBlink
+	vtInverse
+	vtHidden
+	vtStrike
+)
+
+type vtCell struct {
+	ch   string // "" is blank; may carry combining marks
+	attr vtAttr
+	cont bool // right half of a wide character
+}
+
+type vtLine struct {
+	cells   []vtCell
+	wrapped bool // the line continues on the next one (soft wrap)
+}
+
+type vtSaved struct {
+	x, y        int
+	pen         vtAttr
+	origin      bool
+	wrapPending bool
+	charsets    [2]byte
+	gl          int
+}
+
+// Passed-through DEC private modes, serialized in this order.
+var vtPassthroughModes = []int{1000, 1002, 1003, 1004, 1005, 1006, 1015, 2004}
+
+const (
+	vtGround = iota
+	vtEscape
+	vtEscapeInter
+	vtCSI
+	vtOSC
+	vtOSCEsc
+	vtString // DCS, SOS, PM, APC: consumed and ignored
+	vtStringEsc
+)
+
+// NewVTerm returns a reset terminal that keeps up to maxScrollback lines of
+// primary screen history.
+func NewVTerm(cols, rows, maxScrollback int) *VTerm {
+	t := &VTerm{maxScrollback: maxScrollback}
+	t.cols, t.rows = max(cols, 1), max(rows, 1)
+	t.reset()
+	return t
+}
+
+// reset performs a full terminal reset (RIS), keeping the size.
+func (t *VTerm) reset() {
+	t.primary = newVTLines(t.cols, t.rows, vtAttr{})
+	t.alt = newVTLines(t.cols, t.rows, vtAttr{})
+	t.altActive = false
+	t.scrollback = nil
+	t.x, t.y, t.wrapPending = 0, 0, false
+	t.pen = vtAttr{}
+	t.top, t.bottom = 0, t.rows-1
+	t.autowrap, t.origin, t.cursorVisible = true, false, true
+	t.insert, t.newline = false, false
+	t.appCursor, t.appKeypad = false, false
+	t.private = map[int]bool{}
+	t.cursorStyle = 0
+	t.charsets = [2]byte{'B', 'B'}
+	t.gl = 0
+	t.savedPrimary, t.savedAlt = nil, nil
+	t.resetTabs()
+	t.title = ""
+	t.lastChar = ""
+	t.state = vtGround
+	t.utf8buf = t.utf8buf[:0]
+}
+
+func newVTLines(cols, rows int, attr vtAttr) []vtLine {
+	lines := make([]vtLine, rows)
+	for i := range lines {
+		lines[i] = newVTLine(cols, attr)
+	}
+	return lines
+}
+
+func newVTLine(cols int, attr vtAttr) vtLine {
+	cells := make([]vtCell, cols)
+	if attr.bg != vtColorDefault {
+		for i := range cells {
+			cells[i].attr.bg = attr.bg
+		}
+	}
+	return vtLine{cells: cells}
+}
+
+func (t *VTerm) resetTabs() {
+	t.tabs = make([]bool, t.cols)
+	for i := 8; i < t.cols; i += 8 {
+		t.tabs[i] = true
+	}
+}
+
+// Size returns the emulated terminal dimensions.
+func (t *VTerm) Size() (int, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cols, t.rows
+}
+
+// Offset returns the ring offset of the next byte the emulator expects.
+func (t *VTerm) Offset() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.offset
+}
+
+// Write consumes output that was written to the ring at offset.
+func (t *VTerm) Write(offset int64, data []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, b := range data {
+		t.feed(b)
+	}
+	t.offset = offset + int64(len(data))
+}
+
+// Seed resets the emulator and replays data, which reconstructs the screen
+// as it was at ring offset. Used when attaching to a pane with existing content.
+func (t *VTerm) Seed(offset int64, cols, rows int, data []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cols, t.rows = max(cols, 1), max(rows, 1)
+	t.reset()
+	for _, b := range data {
+		t.feed(b)
+	}
+	t.offset = offset
+}
+
+// Resize changes the terminal dimensions without reflowing. Lines pushed off
+// the top of the primary screen go to scrollback, as in xterm.
+func (t *VTerm) Resize(cols, rows int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cols, rows = max(cols, 1), max(rows, 1)
+	if cols == t.cols && rows == t.rows {
+		return
+	}
+
+	resize := func(lines []vtLine, keepBottom bool, scroll bool) []vtLine {
+		for len(lines) > rows {
+			if keepBottom {
+				if scroll {
+					t.pushScrollback(lines[0])
+				}
+				lines = lines[1:]
+			} else {
+				lines = lines[:len(lines)-1]
+			}
+		}
+		for len(lines) < rows {
+			lines = append(lines, newVTLine(cols, vtAttr{}))
+		}
+		for i := range lines {
+			lines[i] = resizeVTLine(lines[i], cols)
+		}
+		lines[rows-1].wrapped = false
+		return lines
+	}
+
+	// Keep the cursor row on screen by dropping lines from the top when
+	// shrinking below it; any other excess rows are dropped from the bottom.
+	shift := max(0, t.y-(rows-1))
+	keep := func(lines []vtLine, scroll bool) []vtLine {
+		for i := 0; i < shift && len(lines) > rows; i++ {
+			if scroll {
+				t.pushScrollback(lines[0])
+			}
+			lines = lines[1:]
+		}
+		return resize(lines, false, scroll)
+	}
+	if t.altActive {
+		t.alt = keep(t.alt, false)
+		t.primary = resize(t.primary, true, true)
+	} else {
+		t.primary = keep(t.primary, true)
+		t.alt = resize(t.alt, false, false)
+	}
+	t.y -= shift
+
+	t.cols, t.rows = cols, rows
+	t.top, t.bottom = 0, rows-1
+	t.x = min(t.x, cols-1)
+	t.y = min(max(t.y, 0), rows-1)
+	t.wrapPending = false
+	t.lastChar = ""
+	for _, saved := range []*vtSaved{t.savedPrimary, t.savedAlt} {
+		if saved != nil {
+			saved.x, saved.y = min(saved.x, cols-1), min(saved.y, rows-1)
+			saved.wrapPending = false
+		}
+	}
+	oldTabs := t.tabs
+	t.resetTabs()
+	copy(t.tabs, oldTabs)
+}
+
+func resizeVTLine(l vtLine, cols int) vtLine {
+	if len(l.cells) > cols {
+		l.cells = l.cells[:cols]
+		if n := len(l.cells); n > 0 && !l.cells[n-1].cont && vtStringWidth(l.cells[n-1].ch) == 2 {
+			l.cells[n-1] = vtCell{attr: l.cells[n-1].attr}
+		}
+		l.wrapped = false
+	}
+	for len(l.cells) < cols {
+		l.cells = append(l.cells, vtCell{})
+	}
+	return l
+}
+
+func (t *VTerm) screen() []vtLine {
+	if t.altActive {
+		return t.alt
+	}
+	return t.primary
+}
+
+// pushScrollback appends l to the scrollback and returns the line that no
+// longer fits, or l itself when scrollback is disabled, for reuse.
+func (t *VTerm) pushScrollback(l vtLine) vtLine {
+	if t.maxScrollback <= 0 {
+		return l
+	}
+	var evicted vtLine
+	if len(t.scrollback) >= t.maxScrollback {
+		// Reslicing keeps the cost per line constant; append moves the
+		// live lines to a new array whenever the old one fills up.
+		evicted = t.scrollback[0]
+		t.scrollback[0] = vtLine{}
+		t.scrollback = t.scrollback[len(t.scrollback)-t.maxScrollback+1:]
+	}
+	t.scrollback = append(t.scrollback, l)
+	return evicted
+}
+
+// blankLine returns a blank line in the current pen's background, reusing
+// old's cells when they are the right width.
+func (t *VTerm) blankLine(old vtLine) vtLine {
+	if len(old.cells) != t.cols {
+		return newVTLine(t.cols, t.pen)
+	}
+	clear(old.cells)
+	if t.pen.bg != vtColorDefault {
+		for i := range old.cells {
+			old.cells[i].attr.bg = t.pen.bg
+		}
+	}
+	return vtLine{cells: old.cells}
+}
+
+// ---------------------------------------------------------------------------
+// Parser
+// ---------------------------------------------------------------------------
+
+func (t *VTerm) feed(b byte) {
+	switch t.state {
+	case vtOSC:
+		switch b {
+		case 0x07:
+			t.dispatchOSC()
+			t.state = vtGround
+		case 0x1b:
+			t.state = vtOSCEsc
+		default:
+			if len(t.osc) < 4096 {
+				t.osc = append(t.osc, b)
+			}
+		}
+		return
+	case vtOSCEsc:
+		t.dispatchOSC()
+		t.state = vtGround
+		if b != '\\' {
+			t.state = vtEscape
+			t.feed(b)
+		}
+		return
+	case vtString:
+		if b == 0x1b {
+			t.state = vtStringEsc
+		}
+		return
+	case vtStringEsc:
+		t.state = vtGround
+		if b != '\\' {
+			t.state = vtEscape
+			t.feed(b)
+		}
+		return
+	}
+
+	// Finish or abandon a pending UTF-8 sequence.
+	if len(t.utf8buf) > 0 {
+		if b&0xC0 == 0x80 {
+			t.utf8buf = append(t.utf8buf, b)
+			if utf8.FullRune(t.utf8buf) {
+				r, _ := utf8.DecodeRune(t.utf8buf)
+				t.utf8buf = t.utf8buf[:0]
+				t.print(r)
+			}
+			return
+		}
+		t.utf8buf = t.utf8buf[:0]
+		t.print(utf8.RuneError)
+	}
+
+	if b < 0x20 || b == 0x7f {
+		switch b {
+		case 0x1b:
+			t.state = vtEscape
+			t.inter = t.inter[:0]
+		case 0x18, 0x1a: // CAN, SUB
+			t.state = vtGround
+		case 0x7f:
+		default:
+			t.execute(b)
+		}
+		return
+	}
+
+	switch t.state {
+	case vtGround:
+		switch {
+		case b < 0x80:
+			t.print(rune(b))
+		case b >= 0xC0:
+			t.utf8buf = append(t.utf8buf, b)
+		default:
+			t.print(utf8.RuneError)
+		}
+	case vtEscape:
+		switch {
+		case b == '[':
+			t.state = vtCSI
+			t.prefix = 0
+			t.params = t.params[:0]
+			t.inter = t.inter[:0]
+		case b == ']':
+			t.state = vtOSC
+			t.osc = t.osc[:0]
+		case b == 'P' || b == 'X' || b == '^' || b == '_':
+			t.state = vtString
+		case b >= 0x20 && b <= 0x2f:
+			t.inter = append(t.inter, b)
+			t.state = vtEscapeInter
+		default:
+			t.state = vtGround
+			t.dispatchESC(b)
+		}
+	case vtEscapeInter:
+		if b >= 0x20 && b <= 0x2f {
+			t.inter = append(t.inter, b)
+			return
+		}
+		t.state = vtGround
+		t.dispatchESC(b)
+	case vtCSI:
+		switch {
+		case b >= '<' && b <= '?' && len(t.params) == 0 && t.prefix == 0:
+			t.prefix = b
+		case b >= '0' && b <= ';':
+			t.params = append(t.params, b)
+		case b >= 0x20 && b <= 0x2f:
+			t.inter = append(t.inter, b)
+		case b >= 0x40 && b <= 0x7e:
+			t.state = vtGround
+			t.dispatchCSI(b)
+		default:
+			t.state = vtGround
+		}
+	}
+}
+
+func (t *VTerm) execute(b byte) {
+	t.lastChar = ""
+	switch b {
+	case '\b':
+		if t.x > 0 {
+			t.x--
+		}
+		t.wrapPending = false
+	case '\t':
+		t.tab(1)
+	case '\n', '\v', '\f':
+		t.lineFeed()
+		if t.newline {
+			t.x = 0
+		}
+	case '\r':
+		t.x = 0
+		t.wrapPending = false
+	case 0x0e: // SO
+		t.gl = 1
+	case 0x0f: // SI
+		t.gl = 0
+	}
+}
+
+func (t *VTerm) dispatchESC(b byte) {
+	t.lastChar = ""
+	if len(t.inter) > 0 {
+		switch t.inter[0] {
+		case '(', ')':
+			if b == '0' || b == 'B' || b == 'A' || b == 'U' {
+				cs := b
+				if cs != '0' {
+					cs = 'B'
+				}
+				t.charsets[t.inter[0]-'('] = cs
+			}
+		case '#':
+			if b == '8' { // DECALN
+				for y := range t.rows {
+					l := &t.screen()[y]
+					for x := range l.cells {
+						l.cells[x] = vtCell{ch: "E"}
+					}
+					l.wrapped = false
+				}
+				t.top, t.bottom = 0, t.rows-1
+				t.x, t.y, t.wrapPending = 0, 0, false
+			}
+		}
+		return
+	}
+	switch b {
+	case '7':
+		t.saveCursor()
+	case '8':
+		t.restoreCursor()
+	case 'D':
+		t.index()
+	case 'E':
+		t.x = 0
+		t.index()
+	case 'M':
+		t.reverseIndex()
+	case 'H':
+		t.tabs[t.x] = true
+	case 'c':
+		t.reset()
+	case '=':
+		t.appKeypad = true
+	case '>':
+		t.appKeypad = false
+	}
+}
+
+func (t *VTerm) dispatchOSC() {
+	cmd, text, ok := strings.Cut(string(t.osc), ";")
+	if !ok {
+		return
+	}
+	if cmd == "0" || cmd == "2" {
+		t.title = text
+	}
+}
+
+// param returns the first subparameter of param i, or def if it is absent.
+func (t *VTerm) param(i, def int) int {
+	fields := strings.Split(string(t.params), ";")
+	if i >= len(fields) {
+		return def
+	}
+	v, _, _ := strings.Cut(fields[i], ":")
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+// count returns param i as a repeat count: absent or zero means 1.
+func (t *VTerm) count(i int) int {
+	return max(1, t.param(i, 1))
+}
+
+func (t *VTerm) dispatchCSI(final byte) {
+	// REP only repeats a character printed immediately before it.
+	last := t.lastChar
+	t.lastChar = ""
+	if len(t.inter) > 0 {
+		switch {
+		case t.inter[0] == ' ' && final == 'q': // DECSCUSR
+			t.cursorStyle = t.param(0, 0)
+		case t.inter[0] == '!' && final == 'p': // DECSTR
+			t.softReset()
+		}
+		return
+	}
+	if t.prefix == '?' {
+		switch final {
+		case 'h', 'l':
+			for _, f := range strings.Split(string(t.params), ";") {
+				if n, err := strconv.Atoi(f); err == nil {
+					t.setPrivateMode(n, final == 'h')
+				}
+			}
+		}
+		return
+	}
+	if t.prefix != 0 {
+		return
+	}
+
+	screen := t.screen()
+	switch final {
+	case '@': // ICH
+		t.insertChars(t.count(0))
+	case 'A':
+		t.moveCursor(t.x, max(t.y-t.count(0), t.upperLimit()))
+	case 'B':
+		t.moveCursor(t.x, min(t.y+t.count(0), t.lowerLimit()))
+	case 'C', 'a':
+		t.moveCursor(min(t.x+t.count(0), t.cols-1), t.y)
+	case 'D':
+		t.moveCursor(max(t.x-t.count(0), 0), t.y)
+	case 'E':
+		t.moveCursor(0, min(t.y+t.count(0), t.lowerLimit()))
+	case 'F':
+		t.moveCursor(0, max(t.y-t.count(0), t.upperLimit()))
+	case 'G', '`':
+		t.moveCursor(min(t.count(0), t.cols)-1, t.y)
+	case 'H', 'f':
+		t.cursorPosition(t.count(0), t.count(1))
+	case 'I':
+		t.tab(t.count(0))
+	case 'J':
+		t.eraseDisplay(t.param(0, 0))
+	case 'K':
+		t.eraseLine(t.param(0, 0))
+	case 'L':
+		if t.y >= t.top && t.y <= t.bottom {
+			t.scrollDownRegion(t.y, t.bottom, t.count(0))
+			t.x, t.wrapPending = 0, false
+		}
+	case 'M':
+		if t.y >= t.top && t.y <= t.bottom {
+			t.scrollUpRegion(t.y, t.bottom, t.count(0), false)
+			t.x, t.wrapPending = 0, false
+		}
+	case 'P': // DCH
+		l := &screen[t.y]
+		n := min(t.count(0), t.cols-t.x)
+		copy(l.cells[t.x:], l.cells[t.x+n:])
+		for i := t.cols - n; i < t.cols; i++ {
+			l.cells[i] = t.blank()
+		}
+		l.fixWide()
+		t.wrapPending = false
+	case 'S':
+		t.scrollUpRegion(t.top, t.bottom, t.count(0), true)
+	case 'T':
+		if len(strings.Split(string(t.params), ";")) <= 1 {
+			t.scrollDownRegion(t.top, t.bottom, t.count(0))
+		}
+	case 'X': // ECH
+		l := &screen[t.y]
+		for i := t.x; i < min(t.x+t.count(0), t.cols); i++ {
+			l.cells[i] = t.blank()
+		}
+		l.fixWide()
+		t.wrapPending = false
+	case 'Z':
+		for n := t.count(0); n > 0 && t.x > 0; n-- {
+			t.x--
+			for t.x > 0 && !t.tabs[t.x] {
+				t.x--
+			}
+		}
+		t.wrapPending = false
+	case 'b': // REP
+		if last != "" {
+			r, _ := utf8.DecodeRuneInString(last)
+			for range min(t.count(0), t.cols*t.rows) {
+				t.print(r)
+			}
+		}
+	case 'd':
+		t.cursorPosition(t.count(0), t.x+1)
+		if t.origin {
+			t.moveCursor(t.x, min(t.top+t.count(0)-1, t.bottom))
+		}
+	case 'e':
+		t.moveCursor(t.x, min(t.y+t.count(0), t.rows-1))
+	case 'g':
+		switch t.param(0, 0) {
+		case 0:
+			t.tabs[t.x] = false
+		case 3:
+			clear(t.tabs)
+		}
+	case 'h', 'l':
+		for _, f := range strings.Split(string(t.params), ";") {
+			switch f {
+			case "4":
+				t.insert = final == 'h'
+			case "20":
+				t.newline = final == 'h'
+			}
+		}
+	case 'm':
+		t.sgr()
+	case 'r':
+		top, bottom := t.count(0), t.param(1, t.rows)
+		if bottom == 0 || bottom > t.rows {
+			bottom = t.rows
+		}
+		if top < bottom {
+			t.top, t.bottom = top-1, bottom-1
+			t.cursorPosition(1, 1)
+		}
+	case 's':
+		t.saveCursor()
+	case 'u':
+		t.restoreCursor()
+	}
+}
+
+func (t *VTerm) setPrivateMode(n int, on bool) {
+	switch n {
+	case 1:
+		t.appCursor = on
+	case 6:
+		t.origin = on
+		t.cursorPosition(1, 1)
+	case 7:
+		t.autowrap = on
+		if !on {
+			t.wrapPending = false
+		}
+	case 25:
+		t.cursorVisible = on
+	case 47, 1047:
+		if on {
+			t.enterAlt(n == 1047)
+		} else {
+			t.exitAlt(n == 1047)
+		}
+	case 1048:
+		if on {
+			t.saveCursor()
+		} else {
+			t.restoreCursor()
+		}
+	case 1049:
+		if on {
+			t.saveCursor()
+			t.enterAlt(true)
+		} else {
+			t.exitAlt(true)
+			t.restoreCursor()
+		}
+	default:
+		for _, m := range vtPassthroughModes {
+			if m == n {
+				t.private[n] = on
+			}
+		}
+	}
+}
+
+func (t *VTerm) enterAlt(clearAlt bool) {
+	if t.altActive {
+		return
+	}
+	if clearAlt {
+		t.alt = newVTLines(t.cols, t.rows, t.pen)
+	}
+	t.altActive = true
+	t.wrapPending = false
+}
+
+func (t *VTerm) exitAlt(clearAlt bool) {
+	if !t.altActive {
+		return
+	}
+	if clearAlt {
+		t.alt = newVTLines(t.cols, t.rows, vtAttr{})
+	}
+	t.altActive = false
+	t.wrapPending = false
+}
+
+func (t *VTerm) softReset() {
+	t.cursorVisible = true
+	t.origin = false
+	t.autowrap = true
+	t.insert = false
+	t.appCursor, t.appKeypad = false, false
+	t.top, t.bottom = 0, t.rows-1
+	t.pen = vtAttr{}
+	t.charsets = [2]byte{'B', 'B'}
+	t.gl = 0
+	if t.altActive {
+		t.savedAlt = nil
+	} else {
+		t.savedPrimary = nil
+	}
+}
+
+func (t *VTerm) saveCursor() {
+	s := &vtSaved{x: t.x, y: t.y, pen: t.pen, origin: t.origin, wrapPending: t.wrapPending, charsets: t.charsets, gl: t.gl}
+	if t.altActive {
+		t.savedAlt = s
+	} else {
+		t.savedPrimary = s
+	}
+}
+
+func (t *VTerm) restoreCursor() {
+	s := t.savedPrimary
+	if t.altActive {
+		s = t.savedAlt
+	}
+	if s == nil {
+		t.x, t.y, t.wrapPending = 0, 0, false
+		t.pen = vtAttr{}
+		t.origin = false
+		t.charsets, t.gl = [2]byte{'B', 'B'}, 0
+		return
+	}
+	t.x, t.y = min(s.x, t.cols-1), min(s.y, t.rows-1)
+	t.pen, t.origin, t.wrapPending = s.pen, s.origin, s.wrapPending && t.autowrap && s.x >= t.cols-1
+	t.charsets, t.gl = s.charsets, s.gl
+}
+
+// ---------------------------------------------------------------------------
+// Screen operations
+// ---------------------------------------------------------------------------
+
+func (t *VTerm) blank() vtCell {
+	return vtCell{attr: vtAttr{bg: t.pen.bg}}
+}
+
+func (t *VTerm) upperLimit() int {
+	if t.y >= t.top {
+		return t.top
+	}
+	return 0
+}
+
+func (t *VTerm) lowerLimit() int {
+	if t.y <= t.bottom {
+		return t.bottom
+	}
+	return t.rows - 1
+}
+
+func (t *VTerm) moveCursor(x, y int) {
+	t.x, t.y = min(max(x, 0), t.cols-1), min(max(y, 0), t.rows-1)
+	t.wrapPending = false
+}
+
+// cursorPosition moves to a 1-based row and column, relative to the scroll
+// region in origin mode.
+func (t *VTerm) cursorPosition(row, col int) {
+	y := row - 1
+	if t.origin {
+		y = min(t.top+y, t.bottom)
+	}
+	t.moveCursor(col-1, y)
+}
+
+func (t *VTerm) tab(n int) {
+	for ; n > 0 && t.x < t.cols-1; n-- {
+		t.x++
+		for t.x < t.cols-1 && !t.tabs[t.x] {
+			t.x++
+		}
+	}
+	t.wrapPending = false
+}
+
+func (t *VTerm) lineFeed() {
+	t.wrapPending = false
+	t.index()
+}
+
+// wrapLine continues output on the next line. Below the scroll region on the
+// last row there is no next line and the row is overwritten instead.
+func (t *VTerm) wrapLine() {
+	if t.y == t.bottom || t.y < t.rows-1 {
+		t.screen()[t.y].wrapped = true
+	}
+	t.x = 0
+	t.index()
+}
+
+func (t *VTerm) index() {
+	t.wrapPending = false
+	if t.y == t.bottom {
+		t.scrollUpRegion(t.top, t.bottom, 1, true)
+	} else if t.y < t.rows-1 {
+		t.y++
+	}
+}
+
+func (t *VTerm) reverseIndex() {
+	t.wrapPending = false
+	if t.y == t.top {
+		t.scrollDownRegion(t.top, t.bottom, 1)
+	} else if t.y > 0 {
+		t.y--
+	}
+}
+
+// scrollUpRegion scrolls lines top..bottom up by n. Lines leaving the top of
+// the full primary screen are kept as scrollback when toScrollback is set.
+func (t *VTerm) scrollUpRegion(top, bottom, n int, toScrollback bool) {
+	screen := t.screen()
+	n = min(n, bottom-top+1)
+	spare := make([]vtLine, n)
+	copy(spare, screen[top:top+n])
+	if toScrollback && top == 0 && !t.altActive {
+		for i := range spare {
+			spare[i] = t.pushScrollback(spare[i])
+		}
+	}
+	copy(screen[top:], screen[top+n:bottom+1])
+	for i := range spare {
+		screen[bottom-n+1+i] = t.blankLine(spare[i])
+	}
+}
+
+func (t *VTerm) scrollDownRegion(top, bottom, n int) {
+	screen := t.screen()
+	n = min(n, bottom-top+1)
+	copy(screen[top+n:bottom+1], screen[top:bottom+1-n])
+	for i := top; i < top+n; i++ {
+		screen[i] = newVTLine(t.cols, t.pen)
+	}
+	// The continuation of the region's last line was pushed out.
+	screen[bottom].wrapped = false
+}
+
+func (t *VTerm) eraseDisplay(mode int) {
+	screen := t.screen()
+	switch mode {
+	case 0:
+		t.eraseLine(0)
+		for y := t.y + 1; y < t.rows; y++ {
+			screen[y] = newVTLine(t.cols, t.pen)
+		}
+	case 1:
+		t.eraseLine(1)
+		for y := 0; y < t.y; y++ {
+			screen[y] = newVTLine(t.cols, t.pen)
+		}
+	case 2:
+		for y := range screen {
+			screen[y] = newVTLine(t.cols, t.pen)
+		}
+	case 3:
+		t.scrollback = nil
+	}
+	t.wrapPending = false
+}
+
+func (t *VTerm) eraseLine(mode int) {
+	l := &t.screen()[t.y]
+	from, to := 0, t.cols
+	switch mode {
+	case 0:
+		from = t.x
+		l.wrapped = false
+	case 1:
+		to = t.x + 1
+	case 2:
+		l.wrapped = false
+	}
+	for i := from; i < to; i++ {
+		l.cells[i] = t.blank()
+	}
+	l.fixWide()
+	t.wrapPending = false
+}
+
+// fixWide blanks halves of wide characters whose other half was erased or
+// shifted away.
+func (l *vtLine) fixWide() {
+	for x := range l.cells {
+		c := &l.cells[x]
+		switch {
+		case c.cont && (x == 0 || l.cells[x-1].cont || vtStringWidth(l.cells[x-1].ch) != 2):
+			*c = vtCell{attr: c.attr}
+		case !c.cont && vtStringWidth(c.ch) == 2 && (x == len(l.cells)-1 || !l.cells[x+1].cont):
+			*c = vtCell{attr: c.attr}
+		}
+	}
+}
+
+func (t *VTerm) insertChars(n int) {
+	l := &t.screen()[t.y]
+	n = min(n, t.cols-t.x)
+	copy(l.cells[t.x+n:], l.cells[t.x:t.cols-n])
+	for i := t.x; i < t.x+n; i++ {
+		l.cells[i] = t.blank()
+	}
+	l.fixWide()
+	t.wrapPending = false
+}
+
+// vtDECGraphics maps DEC special graphics to Unicode line drawing.
+var vtDECGraphics = map[rune]rune{
+	'`': '◆', 'a': '▒', 'f': '°', 'g': '±', 'j': '┘', 'k': '┐', 'l': '┌',
+	'm': '└', 'n': '┼', 'o': '⎺', 'p': '⎻', 'q': '─', 'r': '⎼', 's': '⎽',
+	't': '├', 'u': '┤', 'v': '┴', 'w': '┬', 'x': '│', 'y': '≤', 'z': '≥',
+	'{': 'π', '|': '≠', '}': '£', '~': '·',
+}
+
+func (t *VTerm) print(r rune) {
+	if t.charsets[t.gl] == '0' {
+		if g, ok := vtDECGraphics[r]; ok {
+			r = g
+		}
+	}
+	screen := t.screen()
+	w := vtRuneWidth(r)
+
+	if w == 0 {
+		// Combining mark: attach to the previous cell, or to the character
+		// just printed, which may be under the cursor at the last column.
+		x, y := t.x-1, t.y
+		if t.wrapPending {
+			x = t.x
+		}
+		if t.lastChar != "" {
+			x = t.lastX
+		}
+		if x >= 0 && x < len(screen[y].cells) {
+			c := &screen[y].cells[x]
+			if c.cont && x > 0 {
+				c = &screen[y].cells[x-1]
+			}
+			if c.ch != "" {
+				c.ch += string(r)
+			}
+		}
+		return
+	}
+
+	if w > t.cols {
+		// A wide character cannot fit on a one-column screen at all.
+		r, w = ' ', 1
+	}
+	if t.wrapPending && t.autowrap {
+		t.wrapLine()
+	}
+	if w == 2 && t.x == t.cols-1 {
+		if !t.autowrap {
+			return
+		}
+		screen[t.y].cells[t.x] = t.blank()
+		screen[t.y].fixWide()
+		t.wrapLine()
+	}
+
+	l := &screen[t.y]
+	if t.insert {
+		copy(l.cells[t.x+w:], l.cells[t.x:t.cols-w])
+		l.fixWide()
+	}
+	// Overwriting half of a wide character blanks the other half.
+	if l.cells[t.x].cont && t.x > 0 {
+		l.cells[t.x-1] = vtCell{attr: l.cells[t.x-1].attr}
+	}
+	if end := t.x + w; end < t.cols && l.cells[end].cont {
+		l.cells[end] = vtCell{attr: l.cells[end].attr}
+	}
+
+	ch := string(r)
+	l.cells[t.x] = vtCell{ch: ch, attr: t.pen}
+	if r == ' ' {
+		l.cells[t.x].ch = "" // spaces are stored as blanks
+	}
+	if w == 2 {
+		l.cells[t.x+1] = vtCell{attr: t.pen, cont: true}
+	}
+	t.lastChar, t.lastX = ch, t.x
+
+	if t.x+w >= t.cols {
+		t.x = t.cols - 1
+		t.wrapPending = t.autowrap
+	} else {
+		t.x += w
+	}
+}
+
+func (t *VTerm) sgr() {
+	if len(t.params) == 0 {
+		t.pen = vtAttr{}
+		return
+	}
+	fields := strings.Split(string(t.params), ";")
+	num := func(s string) int {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return 0
+		}
+		return n
+	}
+	for i := 0; i < len(fields); i++ {
+		sub := strings.Split(fields[i], ":")
+		switch n := num(sub[0]); {
+		case n == 0:
+			t.pen = vtAttr{}
+		case n == 1:
+			t.pen.flags |= vtBold
+		case n == 2:
+			t.pen.flags |= vtDim
+		case n == 3:
+			t.pen.flags |= vtItalic
+		case n == 4:
+			if len(sub) > 1 && num(sub[1]) == 0 {
+				t.pen.flags &^= vtUnderline
+			} else {
+				t.pen.flags |= vtUnderline
+			}
+		case n == 5 || n == 6:
+			t.pen.flags |= vtBlink
+		case n == 7:
+			t.pen.flags |= vtInverse
+		case n == 8:
+			t.pen.flags |= vtHidden
+		case n == 9:
+			t.pen.flags |= vtStrike
+		case n == 21:
+			t.pen.flags |= vtUnderline
+		case n == 22:
+			t.pen.flags &^= vtBold | vtDim
+		case n == 23:
+			t.pen.flags &^= vtItalic
+		case n == 24:
+			t.pen.flags &^= vtUnderline
+		case n == 25:
+			t.pen.flags &^= vtBlink
+		case n == 27:
+			t.pen.flags &^= vtInverse
+		case n == 28:
+			t.pen.flags &^= vtHidden
+		case n == 29:
+			t.pen.flags &^= vtStrike
+		case n >= 30 && n <= 37:
+			t.pen.fg = vtColorIndexed | vtColor(n-30)
+		case n == 38 || n == 48:
+			var c vtColor
+			var ok bool
+			if len(sub) > 1 {
+				c, ok = vtExtendedColor(sub[1:], num)
+			} else {
+				var used int
+				c, ok, used = vtExtendedColorArgs(fields[i+1:], num)
+				i += used
+			}
+			if ok {
+				if n == 38 {
+					t.pen.fg = c
+				} else {
+					t.pen.bg = c
+				}
+			}
+		case n == 39:
+			t.pen.fg = vtColorDefault
+		case n >= 40 && n <= 47:
+			t.pen.bg = vtColorIndexed | vtColor(n-40)
+		case n == 49:
+			t.pen.bg = vtColorDefault
+		case n >= 90 && n <= 97:
+			t.pen.fg = vtColorIndexed | vtColor(n-90+8)
+		case n >= 100 && n <= 107:
+			t.pen.bg = vtColorIndexed | vtColor(n-100+8)
+		}
+	}
+}
+
+// vtExtendedColor parses the colon form: 5:n, 2:r:g:b or 2:cs:r:g:b.
+func vtExtendedColor(sub []string, num func(string) int) (vtColor, bool) {
+	switch {
+	case len(sub) >= 2 && num(sub[0]) == 5:
+		return vtColorIndexed | vtColor(num(sub[1])&0xff), true
+	case len(sub) >= 4 && num(sub[0]) == 2:
+		rgb := sub[1:4]
+		if len(sub) >= 5 {
+			rgb = sub[2:5]
+		}
+		return vtColorRGB | vtColor((num(rgb[0])&0xff)<<16|(num(rgb[1])&0xff)<<8|num(rgb[2])&0xff), true
+	}
+	return 0, false
+}
+
+// vtExtendedColorArgs parses the semicolon form, returning how many of the
+// following fields it consumed.
+func vtExtendedColorArgs(rest []string, num func(string) int) (vtColor, bool, int) {
+	if len(rest) >= 2 && num(rest[0]) == 5 {
+		return vtColorIndexed | vtColor(num(rest[1])&0xff), true, 2
+	}
+	if len(rest) >= 4 && num(rest[0]) == 2 {
+		return vtColorRGB | vtColor((num(rest[1])&0xff)<<16|(num(rest[2])&0xff)<<8|num(rest[3])&0xff), true, 4
+	}
+	return 0, false, len(rest)
+}
+
+// vtRuneWidth returns the number of columns r occupies: 0 for combining
+// marks, 2 for East Asian wide characters and emoji, 1 otherwise.
+func vtRuneWidth(r rune) int {
+	switch {
+	case r == 0x200d || unicode.In(r, unicode.Mn, unicode.Me) || (r >= 0xfe00 && r <= 0xfe0f):
+		return 0
+	case r >= 0x1100 && r <= 0x115f,
+		r >= 0x2e80 && r <= 0x303e,
+		r >= 0x3041 && r <= 0x33ff,
+		r >= 0x3400 && r <= 0x4dbf,
+		r >= 0x4e00 && r <= 0x9fff,
+		r >= 0xa000 && r <= 0xa4cf,
+		r >= 0xac00 && r <= 0xd7a3,
+		r >= 0xf900 && r <= 0xfaff,
+		r >= 0xfe30 && r <= 0xfe4f,
+		r >= 0xff00 && r <= 0xff60,
+		r >= 0xffe0 && r <= 0xffe6,
+		r >= 0x1f300 && r <= 0x1f64f,
+		r >= 0x1f900 && r <= 0x1f9ff,
+		r >= 0x1f680 && r <= 0x1f6ff,
+		r >= 0x20000 && r <= 0x3fffd:
+		return 2
+	}
+	return 1
+}
+
+func vtStringWidth(s string) int {
+	r, _ := utf8.DecodeRuneInString(s)
+	if s == "" {
+		return 1
+	}
+	return vtRuneWidth(r)
+}
+
+// ---------------------------------------------------------------------------
+// Snapshot
+// ---------------------------------------------------------------------------
+
+// Snapshot serializes the terminal as a byte stream that, written to a fresh
+// terminal of the same size, reproduces the scrollback, both screens, the
+// cursor, pen, scroll region and modes. It returns the ring offset the
+// snapshot corresponds to; live output from that offset applies on top.
+func (t *VTerm) Snapshot() ([]byte, int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.snapshotLocked(true), t.offset
+}
+
+func (t *VTerm) snapshotLocked(withScrollback bool) []byte {
+	s := &vtSerializer{charsets: [2]byte{'B', 'B'}}
+	s.WriteString("\x1bc")
+
+	// Primary screen, preceded by its scrollback. Writing the lines one after
+	// another pushes the older ones into the client's own scrollback.
+	lines := append([]vtLine(nil), t.primary...)
+	if withScrollback {
+		lines = append(append([]vtLine(nil), t.scrollback...), t.primary...)
+	}
+	for i, l := range lines {
+		// Scrollback keeps the width it was written at.
+		if len(l.cells) != t.cols {
+			lines[i] = resizeVTLine(vtLine{cells: append([]vtCell(nil), l.cells...), wrapped: l.wrapped}, t.cols)
+		}
+	}
+	for i, l := range lines {
+		s.line(l, l.wrapped && i < len(lines)-1, i > 0 && lines[i-1].wrapped)
+		if i < len(lines)-1 && !l.wrapped {
+			s.sgr(vtAttr{})
+			s.WriteString("\r\n")
+		}
+	}
+
+	if t.altActive {
+		// ?1049h saves the cursor, so put the primary screen's saved cursor
+		// in place before switching.
+		s.cursorState(t.savedPrimary, t.primary)
+		s.WriteString("\x1b[?1049h\x1b[?6l")
+		s.sgr(vtAttr{})
+		s.setCharsets([2]byte{'B', 'B'}, 0)
+		s.WriteString("\x1b[2J")
+		s.screen(t.alt)
+		s.saveCursor(t.savedAlt, t.alt)
+	} else if !vtBlank(t.alt) || t.savedAlt != nil {
+		// Mode 47 switches to the alternate screen without clearing it, so
+		// its contents and saved cursor survive while it is inactive.
+		s.WriteString("\x1b[?47h")
+		s.screen(t.alt)
+		s.saveCursor(t.savedAlt, t.alt)
+		s.sgr(vtAttr{})
+		s.WriteString("\x1b[?47l")
+		s.saveCursor(t.savedPrimary, t.primary)
+	} else {
+		s.saveCursor(t.savedPrimary, t.primary)
+	}
+
+	if !vtDefaultTabs(t.tabs) {
+		s.WriteString("\x1b[3g")
+		for x, set := range t.tabs {
+			if set {
+				fmt.Fprintf(s, "\x1b[%dG\x1bH", x+1)
+			}
+		}
+	}
+	if t.top != 0 || t.bottom != t.rows-1 {
+		fmt.Fprintf(s, "\x1b[%d;%dr", t.top+1, t.bottom+1)
+	}
+	row := t.y + 1
+	if t.origin {
+		s.WriteString("\x1b[?6h")
+		row = t.y - t.top + 1
+	}
+
+	s.setCharsets([2]byte{'B', 'B'}, 0)
+	s.position(row, t.x, t.wrapPending, t.screen()[t.y:])
+
+	if !t.autowrap {
+		s.WriteString("\x1b[?7l")
+	}
+	if t.insert {
+		s.WriteString("\x1b[4h")
+	}
+	if t.newline {
+		s.WriteString("\x1b[20h")
+	}
+	if t.appCursor {
+		s.WriteString("\x1b[?1h")
+	}
+	if t.appKeypad {
+		s.WriteString("\x1b=")
+	}
+	for _, m := range vtPassthroughModes {
+		if t.private[m] {
+			fmt.Fprintf(s, "\x1b[?%dh", m)
+		}
+	}
+	if !t.cursorVisible {
+		s.WriteString("\x1b[?25l")
+	}
+	if t.cursorStyle != 0 {
+		fmt.Fprintf(s, "\x1b[%d q", t.cursorStyle)
+	}
+	if t.title != "" {
+		fmt.Fprintf(s, "\x1b]2;%s\x07", t.title)
+	}
+	s.sgr(t.pen)
+	s.setCharsets(t.charsets, t.gl)
+
+	return s.Bytes()
+}
+
+// ---------------------------------------------------------------------------
+// Screen updates
+// ---------------------------------------------------------------------------
+
+// vtView is what a client in screen-update mode was last sent: the rows of
+// both screens, the cursor and pen, and the state a row update does not touch.
+type vtView struct {
+	lines       []vtLine
+	hidden      []vtLine // the screen not shown, which ?47l can still write to
+	x, y        int
+	wrapPending bool
+	pen         vtAttr
+	charsets    [2]byte
+	gl          int
+	modes       vtModes
+}
+
+// vtModes is the state that, when it changes, makes ScreenUpdate fall back
+// to a full redraw.
+type vtModes struct {
+	cols, rows, top, bottom, cursorStyle   int
+	alt, autowrap, origin, insert, newline bool
+	appCursor, appKeypad, cursorVisible    bool
+	savedPrimary, savedAlt                 vtSaved // nil as the power-on default
+	title, private, tabs                   string
+}
+
+func (t *VTerm) modesLocked() vtModes {
+	m := vtModes{
+		cols: t.cols, rows: t.rows, top: t.top, bottom: t.bottom, cursorStyle: t.cursorStyle,
+		alt: t.altActive, autowrap: t.autowrap, origin: t.origin, insert: t.insert, newline: t.newline,
+		appCursor: t.appCursor, appKeypad: t.appKeypad, cursorVisible: t.cursorVisible,
+		title: t.title,
+	}
+	m.savedPrimary, m.savedAlt = vtSavedOrDefault(t.savedPrimary), vtSavedOrDefault(t.savedAlt)
+	var b strings.Builder
+	for _, mode := range vtPassthroughModes {
+		if t.private[mode] {
+			fmt.Fprintf(&b, "%d;", mode)
+		}
+	}
+	m.private = b.String()
+	b.Reset()
+	for x, set := range t.tabs {
+		if set {
+			fmt.Fprintf(&b, "%d;", x)
+		}
+	}
+	m.tabs = b.String()
+	return m
+}
+
+func vtCopyLines(lines []vtLine) []vtLine {
+	cp := make([]vtLine, len(lines))
+	for i, l := range lines {
+		cp[i] = vtLine{cells: slices.Clone(l.cells), wrapped: l.wrapped}
+	}
+	return cp
+}
+
+func vtSameCells(a, b vtLine) bool {
+	return slices.Equal(a.cells, b.cells)
+}
+
+func vtSavedOrDefault(s *vtSaved) vtSaved {
+	if s == nil {
+		return vtSaved{charsets: [2]byte{'B', 'B'}}
+	}
+	return *s
+}
+
+// ScreenUpdate brings a client that was sent prev up to date by redrawing
+// only the rows that changed, for clients that receive periodic screens
+// instead of the raw stream. With a nil prev, after a change of size, screen
+// or modes, or after a write to the screen not shown, it sends a full
+// snapshot without scrollback instead. It returns nil data if nothing
+// changed, the view to pass next time, and the ring offset the update
+// corresponds to.
+func (t *VTerm) ScreenUpdate(prev *vtView) ([]byte, *vtView, int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	screen, hidden := t.primary, t.alt
+	if t.altActive {
+		screen, hidden = t.alt, t.primary
+	}
+	view := &vtView{
+		lines: vtCopyLines(screen), hidden: vtCopyLines(hidden),
+		x: t.x, y: t.y, wrapPending: t.wrapPending,
+		pen: t.pen, charsets: t.charsets, gl: t.gl,
+		modes: t.modesLocked(),
+	}
+	if prev == nil || prev.modes != view.modes || !slices.EqualFunc(hidden, prev.hidden, vtSameCells) {
+		return t.snapshotLocked(false), view, t.offset
+	}
+
+	var changed []int
+	for y, l := range screen {
+		if !vtSameCells(l, prev.lines[y]) {
+			changed = append(changed, y)
+		}
+	}
+	if len(changed) == 0 && view.x == prev.x && view.y == prev.y && view.wrapPending == prev.wrapPending &&
+		view.pen == prev.pen && view.charsets == prev.charsets && view.gl == prev.gl {
+		return nil, view, t.offset
+	}
+
+	// Rows are drawn at absolute positions, replacing what is there.
+	s := &vtSerializer{pen: prev.pen, charsets: prev.charsets, gl: prev.gl}
+	if t.origin {
+		s.WriteString("\x1b[?6l")
+	}
+	if t.insert {
+		s.WriteString("\x1b[4l")
+	}
+	s.setCharsets([2]byte{'B', 'B'}, 0)
+	for _, y := range changed {
+		l := screen[y]
+		fmt.Fprintf(s, "\x1b[%d;1H", y+1)
+		s.line(l, false, false)
+		// A line drawn to the last column leaves the cursor there, where
+		// EL would erase the last cell.
+		if l.cells[len(l.cells)-1] == (vtCell{}) {
+			s.sgr(vtAttr{})
+			s.WriteString("\x1b[K")
+		}
+	}
+
+	row := t.y + 1
+	if t.origin {
+		s.WriteString("\x1b[?6h")
+		row = t.y - t.top + 1
+	}
+	s.position(row, t.x, t.wrapPending, screen[t.y:])
+	if t.insert {
+		s.WriteString("\x1b[4h")
+	}
+	s.sgr(t.pen)
+	s.setCharsets(t.charsets, t.gl)
+	return s.Bytes(), view, t.offset
+}
+
+// vtSerializer writes screen contents while tracking the SGR and character
+// set state of the receiving terminal, which starts out reset.
+type vtSerializer struct {
+	bytes.Buffer
+	pen      vtAttr
+	charsets [2]byte
+	gl       int
+}
+
+// screen draws every row of a screen at its position.
+func (s *vtSerializer) screen(lines []vtLine) {
+	for y, l := range lines {
+		continued := y > 0 && lines[y-1].wrapped
+		if !continued && !l.wrapped && vtBlank(lines[y:y+1]) {
+			continue
+		}
+		if !continued {
+			fmt.Fprintf(s, "\x1b[%d;1H", y+1)
+		}
+		s.line(l, l.wrapped && y < len(lines)-1, continued)
+	}
+}
+
+// line writes a line's cells. Trailing default blanks are skipped unless
+// full is set, which a soft-wrapped line needs to wrap onto the next one.
+// The continuation of a wrapped line writes at least one cell so the wrap
+// actually happens.
+func (s *vtSerializer) line(l vtLine, full, continued bool) {
+	end := len(l.cells)
+	if !full {
+		for end > 0 && l.cells[end-1] == (vtCell{}) {
+			end--
+		}
+	}
+	if continued {
+		end = max(end, 1)
+	}
+	for _, c := range l.cells[:end] {
+		if !c.cont {
+			s.cell(c)
+		}
+	}
+	// Scrolling to make room for a wrap fills the new line with the pen's
+	// background; clear what the cells above did not overwrite.
+	if continued && end < len(l.cells) {
+		s.sgr(vtAttr{})
+		s.WriteString("\x1b[K")
+	}
+}
+
+func (s *vtSerializer) cell(c vtCell) {
+	s.sgr(c.attr)
+	if c.ch == "" {
+		s.WriteByte(' ')
+	} else {
+		s.WriteString(c.ch)
+	}
+}
+
+// cursorState moves to a saved cursor on lines and restores its pen, origin
+// mode, pending wrap and character sets. A nil state is the power-on default.
+// The scroll region is still the full screen, so the position is absolute
+// either way.
+func (s *vtSerializer) cursorState(saved *vtSaved, lines []vtLine) {
+	if saved == nil {
+		saved = &vtSaved{charsets: [2]byte{'B', 'B'}}
+	}
+	if saved.origin {
+		s.WriteString("\x1b[?6h")
+	}
+	y := min(saved.y, len(lines)-1)
+	s.position(y+1, saved.x, saved.wrapPending, lines[y:])
+	s.sgr(saved.pen)
+	s.setCharsets(saved.charsets, saved.gl)
+}
+
+// saveCursor recreates the current screen's DECSC state, if any.
+func (s *vtSerializer) saveCursor(saved *vtSaved, lines []vtLine) {
+	if saved == nil {
+		return
+	}
+	s.cursorState(saved, lines)
+	s.WriteString("\x1b7\x1b[?6l")
+	s.setCharsets([2]byte{'B', 'B'}, 0)
+}
+
+// position moves the cursor to row and column x. A pending wrap can only be
+// recreated by printing the last cell of lines[0] again, which needs the
+// character sets in their default state.
+func (s *vtSerializer) position(row, x int, wrapPending bool, lines []vtLine) {
+	if !wrapPending || len(lines) == 0 || x != len(lines[0].cells)-1 {
+		fmt.Fprintf(s, "\x1b[%d;%dH", row, x+1)
+		return
+	}
+	l := lines[0]
+	x = len(l.cells) - 1
+	if l.cells[x].cont && x > 0 {
+		x--
+	}
+	fmt.Fprintf(s, "\x1b[%d;%dH", row, x+1)
+	s.cell(l.cells[x])
+}
+
+func (s *vtSerializer) setCharsets(cs [2]byte, gl int) {
+	for i, c := range cs {
+		if c != s.charsets[i] {
+			fmt.Fprintf(s, "\x1b%c%c", "()"[i], c)
+		}
+	}
+	switch {
+	case gl == 1 && s.gl != 1:
+		s.WriteByte(0x0e) // SO
+	case gl == 0 && s.gl != 0:
+		s.WriteByte(0x0f) // SI
+	}
+	s.charsets, s.gl = cs, gl
+}
+
+func (s *vtSerializer) sgr(a vtAttr) {
+	if a == s.pen {
+		return
+	}
+	s.pen = a
+	s.WriteString("\x1b[0")
+	for i, code := range []int{1, 2, 3, 4, 5, 7, 8, 9} {
+		if a.flags&(1<<i) != 0 {
+			fmt.Fprintf(s, ";%d", code)
+		}
+	}
+	s.color(a.fg, 30, 90, 38)
+	s.color(a.bg, 40, 100, 48)
+	s.WriteByte('m')
+}
+
+func (s *vtSerializer) color(c vtColor, base, bright, ext int) {
+	v := int(c & 0xffffff)
+	switch c &^ 0xffffff {
+	case vtColorIndexed:
+		switch {
+		case v < 8:
+			fmt.Fprintf(s, ";%d", base+v)
+		case v < 16:
+			fmt.Fprintf(s, ";%d", bright+v-8)
+		default:
+			fmt.Fprintf(s, ";%d;5;%d", ext, v)
+		}
+	case vtColorRGB:
+		fmt.Fprintf(s, ";%d;2;%d;%d;%d", ext, v>>16, v>>8&0xff, v&0xff)
+	}
+}
+
+func vtBlank(lines []vtLine) bool {
+	for _, l := range lines {
+		for _, c := range l.cells {
+			if c != (vtCell{}) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func vtDefaultTabs(tabs []bool) bool {
+	for x, set := range tabs {
+		if set != (x > 0 && x%8 == 0) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// vtText renders the active screen as trimmed lines.
+func vtText(vt *VTerm) []string {
+	var out []string
+	for _, l := range vt.screen() {
+		var b strings.Builder
+		for _, c := range l.cells {
+			switch {
+			case c.cont:
+			case c.ch == "":
+				b.WriteByte(' ')
+			default:
+				b.WriteString(c.ch)
+			}
+		}
+		out = append(out, strings.TrimRight(b.String(), " "))
+	}
+	return out
+}
+
+func vtFeed(vt *VTerm, s string) {
+	vt.Write(vt.Offset(), []byte(s))
+}
+
+func TestVTermCursorAndErase(t *testing.T) {
+	vt := NewVTerm(10, 4, 100)
+	vtFeed(vt, "hello\r\nworld\x1b[1;3H\x1b[K")
+	got := vtText(vt)
+	if got[0] != "he" || got[1] != "world" {
+		t.Fatalf("unexpected screen %q", got)
+	}
+	if vt.x != 2 || vt.y != 0 {
+		t.Fatalf("cursor at %d,%d, want 2,0", vt.x, vt.y)
+	}
+	vtFeed(vt, "\x1b[2J")
+	if got := vtText(vt); got[1] != "" {
+		t.Fatalf("screen not cleared: %q", got)
+	}
+	if vt.Offset() != int64(len("hello\r\nworld\x1b[1;3H\x1b[K\x1b[2J")) {
+		t.Fatalf("unexpected offset %d", vt.Offset())
+	}
+}
+
+func TestVTermWrapAndScrollback(t *testing.T) {
+	vt := NewVTerm(5, 2, 100)
+	vtFeed(vt, "abcdefg\r\nxyz")
+	if got := vtText(vt); got[0] != "fg" || got[1] != "xyz" {
+		t.Fatalf("unexpected screen %q", got)
+	}
+	if len(vt.scrollback) != 1 || !vt.scrollback[0].wrapped {
+		t.Fatalf("expected one wrapped scrollback line, got %d", len(vt.scrollback))
+	}
+}
+
+func TestVTermScrollRegion(t *testing.T) {
+	vt := NewVTerm(5, 4, 100)
+	vtFeed(vt, "1\r\n2\r\n3\r\n4\x1b[2;3r\x1b[3;1H\nX")
+	got := vtText(vt)
+	want := []string{"1", "3", "X", "4"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("line %d: got %q, want %q (screen %q)", i, got[i], want[i], got)
+		}
+	}
+	if len(vt.scrollback) != 0 {
+		t.Fatalf("region scroll should not reach scrollback")
+	}
+}
+
+func TestVTermAltScreen(t *testing.T) {
+	vt := NewVTerm(10, 3, 100)
+	vtFeed(vt, "shell\x1b[?1049h\x1b[Hvim")
+	if got := vtText(vt); got[0] != "vim" {
+		t.Fatalf("alt screen: %q", got)
+	}
+	vtFeed(vt, "\x1b[?1049l")
+	if got := vtText(vt); got[0] != "shell" {
+		t.Fatalf("primary screen not restored: %q", got)
+	}
+	if vt.x != 5 || vt.y != 0 {
+		t.Fatalf("cursor at %d,%d, want 5,0", vt.x, vt.y)
+	}
+}
+
+func TestVTermWideAndCombining(t *testing.T) {
+	vt := NewVTerm(5, 2, 100)
+	vtFeed(vt, "a漢e\u0301")
+	l := vt.screen()[0]
+	if l.cells[1].ch != "漢" || !l.cells[2].cont || l.cells[3].ch != "e\u0301" {
+		t.Fatalf("unexpected cells %+v", l.cells)
+	}
+	vtFeed(vt, "漢e\u0301")
+	if got := vtText(vt); got[0] != "a漢e\u0301" || got[1] != "漢e\u0301" {
+		t.Fatalf("wide char should wrap: %q", got)
+	}
+
+	// A wide character on a one-column screen is stored as a blank.
+	vt = NewVTerm(1, 2, 0)
+	vtFeed(vt, "漢\x1b[4h漢x")
+	if got := vtText(vt); got[0] != "" || got[1] != "x" {
+		t.Fatalf("wide char on one column: %q", got)
+	}
+
+	// Without autowrap the cursor stays on the last character printed.
+	vt = NewVTerm(3, 1, 0)
+	vtFeed(vt, "\x1b[?7labce\u0301")
+	if got := vtText(vt); got[0] != "abe\u0301" {
+		t.Fatalf("combining mark at the margin: %q", got)
+	}
+}
+
+func TestVTermSGR(t *testing.T) {
+	vt := NewVTerm(10, 1, 0)
+	vtFeed(vt, "\x1b[1;31;48;5;200mA\x1b[38:2::1:2:3;4mB\x1b[22;24;39;49mC")
+	cells := vt.screen()[0].cells
+	if a := cells[0].attr; a.flags != vtBold || a.fg != vtColorIndexed|1 || a.bg != vtColorIndexed|200 {
+		t.Fatalf("A: %+v", a)
+	}
+	if a := cells[1].attr; a.flags != vtBold|vtUnderline || a.fg != vtColorRGB|0x010203 {
+		t.Fatalf("B: %+v", a)
+	}
+	if a := cells[2].attr; a != (vtAttr{}) {
+		t.Fatalf("C: %+v", a)
+	}
+}
+
+func TestVTermResize(t *testing.T) {
+	vt := NewVTerm(10, 4, 100)
+	vtFeed(vt, "1\r\n2\r\n3\r\n4")
+	vt.Resize(5, 2)
+	if got := vtText(vt); got[0] != "3" || got[1] != "4" {
+		t.Fatalf("unexpected screen %q", got)
+	}
+	if len(vt.scrollback) != 2 || vt.y != 1 {
+		t.Fatalf("scrollback %d, cursor row %d", len(vt.scrollback), vt.y)
+	}
+
+	// A combining mark after shrinking past the last character printed
+	// attaches to the cell before the cursor.
+	vt = NewVTerm(10, 2, 0)
+	vtFeed(vt, "abcdefghi")
+	vt.Resize(4, 2)
+	vtFeed(vt, "\u0301")
+	if got := vtText(vt); got[0] != "abc\u0301d" {
+		t.Fatalf("combining mark after resize: %q", got)
+	}
+}
+
+// TestVTermSnapshotRoundTrip feeds a snapshot into a fresh emulator and
+// checks it reproduces the original state.
+func TestVTermSnapshotRoundTrip(t *testing.T) {
+	cases := map[string]string{
+		"plain":      "line one\r\nline two\r\n$ ",
+		"scrollback": strings.Repeat("row\r\n", 30) + "last",
+		"wrapped":    "0123456789abcdefghijklmnop\r\n",
+		"pendingwrap": "0123456789abcdefghij" +
+			"\x1b[4;1H0123456789abcdefghij",
+		"colors":  "\x1b[1;33;44mwarn\x1b[0m ok \x1b[38;2;10;20;30mrgb\x1b[7m",
+		"region":  "\x1b[2;5r\x1b[?6h\x1b[3;2Hx\x1b[?7l\x1b[4h",
+		"modes":   "\x1b[?1h\x1b=\x1b[?1000h\x1b[?1006h\x1b[?2004h\x1b[?25l\x1b[5 q\x1b]2;title\x07",
+		"charset": "\x1b(0lqk\x1b(B\x1b)0\x0e",
+		"saved":   "\x1b[3;4H\x1b[32m\x1b7\x1b[m\x1b[H",
+		"tabs":    "\x1b[3g\x1b[5G\x1bH\x1b[1G\tT",
+		"alt": "prompt$ vim\x1b[2;3H\x1b[35m\x1b[?1049h\x1b[m\x1b[H" +
+			"alt screen\x1b[5;1H~\x1b[2;2H\x1b7\x1b[4;4H",
+		"wide": "漢字テスト漢字テスト漢字\r\nx́",
+	}
+	for name, input := range cases {
+		t.Run(name, func(t *testing.T) {
+			src := NewVTerm(20, 6, 100)
+			vtFeed(src, input)
+			snap, offset := src.Snapshot()
+			if offset != int64(len(input)) {
+				t.Fatalf("offset %d, want %d", offset, len(input))
+			}
+
+			dst := NewVTerm(20, 6, 100)
+			dst.Write(0, snap)
+			vtAssertEqual(t, src, dst)
+
+			// Further output must behave identically on both.
+			more := "\x1b8more\nnext\x1b[?1049lafter"
+			vtFeed(src, more)
+			vtFeed(dst, more)
+			vtAssertEqual(t, src, dst)
+		})
+	}
+}
+
+// TestVTermScreenUpdate applies output in steps and checks that a terminal
+// fed only screen updates keeps up with it.
+func TestVTermScreenUpdate(t *testing.T) {
+	steps := []string{
+		"$ ls\r\nfile1  file2\r\n$ ",
+		"\x1b[1;32mgreen\x1b[m and plain",
+		strings.Repeat("scroll\r\n", 10) + "0123456789abcdefghij",
+		"\x1b[2;5r\x1b[?6h\x1b[4h\x1b[2;1Hins",
+		"\x1b[?1049h\x1b[Halt screen\x1b(0lqk",
+		"\x1b[3;3Hx\x1b(B",
+		"\x1b[?1049l",
+	}
+	src := NewVTerm(20, 6, 100)
+	dst := NewVTerm(20, 6, 100)
+	var view *vtView
+	for i, step := range steps {
+		vtFeed(src, step)
+		data, next, offset := src.ScreenUpdate(view)
+		if offset != src.Offset() {
+			t.Fatalf("step %d: offset %d, want %d", i, offset, src.Offset())
+		}
+		dst.Write(0, data)
+		vtAssertScreen(t, src, dst)
+		view = next
+	}
+
+	if data, _, _ := src.ScreenUpdate(view); data != nil {
+		t.Fatalf("expected no update without output, got %q", data)
+	}
+
+	// Only the changed row is redrawn. Origin and insert mode are still on,
+	// so this inserts at the start of the region's last row.
+	vtFeed(src, "\x1b[4;1Hbottom")
+	data, _, _ := src.ScreenUpdate(view)
+	if strings.Count(string(data), ";1H") != 1 || !strings.Contains(string(data), "\x1b[5;1Hbottom") {
+		t.Fatalf("unexpected update %q", data)
+	}
+}
+
+func vtAssertEqual(t *testing.T, want, got *VTerm) {
+	t.Helper()
+	vtAssertScreen(t, want, got)
+	for y, l := range want.screen() {
+		if got.screen()[y].wrapped != l.wrapped {
+			t.Fatalf("line %d wrapped=%v, want %v", y, got.screen()[y].wrapped, l.wrapped)
+		}
+	}
+	if len(got.scrollback) != len(want.scrollback) {
+		t.Fatalf("scrollback %d lines, want %d", len(got.scrollback), len(want.scrollback))
+	}
+}
+
+// vtAssertScreen compares the visible screen and terminal state, but not
+// scrollback or soft wraps.
+func vtAssertScreen(t *testing.T, want, got *VTerm) {
+	t.Helper()
+	if w, g := vtText(want), vtText(got); strings.Join(w, "\n") != strings.Join(g, "\n") {
+		t.Fatalf("screen mismatch:\nwant %q\ngot  %q", w, g)
+	}
+	for y, l := range want.screen() {
+		for x, c := range l.cells {
+			if gc := got.screen()[y].cells[x]; gc.attr != c.attr || gc.cont != c.cont {
+				t.Fatalf("cell %d,%d: got %+v, want %+v", x, y, gc, c)
+			}
+		}
+	}
+	type state struct {
+		X, Y, Top, Bottom                  int
+		WrapPending, Alt, Autowrap, Origin bool
+		Visible, Insert, AppCursor, Keypad bool
+		Pen                                vtAttr
+		Charsets                           [2]byte
+		GL, Style                          int
+		Title                              string
+		Private                            string
+		Tabs                               string
+	}
+	snap := func(vt *VTerm) state {
+		var priv, tabs []string
+		for _, m := range vtPassthroughModes {
+			if vt.private[m] {
+				priv = append(priv, string(rune('0'+m%10)))
+			}
+		}
+		for x, set := range vt.tabs {
+			if set {
+				tabs = append(tabs, string(rune('0'+x%10)))
+			}
+		}
+		return state{vt.x, vt.y, vt.top, vt.bottom, vt.wrapPending, vt.altActive, vt.autowrap, vt.origin,
+			vt.cursorVisible, vt.insert, vt.appCursor, vt.appKeypad, vt.pen, vt.charsets, vt.gl,
+			vt.cursorStyle, vt.title, strings.Join(priv, ","), strings.Join(tabs, ",")}
+	}
+	if w, g := snap(want), snap(got); w != g {
+		t.Fatalf("state mismatch:\nwant %+v\ngot  %+v", w, g)
+	}
+}
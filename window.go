@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// windowRefresh is how often a window connection re-reads the window's
+// layout when no tmux event has prompted it to.
+const windowRefresh = 5 * time.Second
+
+// WindowConn is a WebSocket connection to every pane of a tmux window. It
+// sends the window's layout, then for each pane the same stream a
+// single-pane connection would receive, wrapped in PaneMsg envelopes or pane
+// frames. Each pane's stream comes from the Session a /s/ connection to that
+// pane uses, so the pane is read only once however it is viewed.
+type WindowConn struct {
+	window  string // "session:window"
+	conn    *websocket.Conn
+	stats   *ConnStats
+	sm      *SessionManager
+	info    ClientInfo
+	audit   *AuditLog
+	cfg     *Config
+	logger  *slog.Logger
+	kick    chan struct{}
+	last    []byte // layout message last sent
+	missing bool   // the window could not be listed at the last refresh
+
+	mu      sync.Mutex
+	session string                 // tmux session the window belongs to
+	panes   map[string]*windowPane // by pane ID
+}
+
+// windowPane is the stream of one pane of a window connection.
+type windowPane struct {
+	id     string
+	target string
+	sess   *Session
+	client *Client
+	ctx    context.Context // ends with the stream
+	cancel context.CancelFunc
+}
+
+func NewWindowConn(window string, conn *websocket.Conn, stats *ConnStats, sm *SessionManager, info ClientInfo, audit *AuditLog, cfg *Config, logger *slog.Logger) *WindowConn {
+	return &WindowConn{
+		window: window,
+		conn:   conn,
+		stats:  stats,
+		sm:     sm,
+		info:   info,
+		audit:  audit,
+		cfg:    cfg,
+		logger: logger.With("window", window, "role", info.Role, "identity", info.Identity),
+		kick:   make(chan struct{}, 1),
+		panes:  make(map[string]*windowPane),
+	}
+}
+
+// Run reads the client's hello, starts following the window and forwards
+// input to its panes. Blocks until the client disconnects.
+func (w *WindowConn) Run(ctx context.Context) {
+	w.logger.Info("window client connected")
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer w.conn.CloseNow()
+
+	_, raw, err := w.conn.Read(ctx)
+	if err != nil {
+		w.logger.Error("read hello failed", "error", err)
+		return
+	}
+	msg, err := ParseClientMessage(raw)
+	if err != nil {
+		w.sendJSON(ctx, ErrorMsg{Type: "error", Message: fmt.Sprintf("invalid hello: %v", err)})
+		return
+	}
+	hello, ok := msg.(*HelloMsg)
+	if !ok {
+		w.sendJSON(ctx, ErrorMsg{Type: "error", Message: "first message must be hello"})
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.follow(ctx, hello)
+	}()
+	defer func() {
+		cancel()
+		<-done
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		for id, p := range w.panes {
+			w.stopPane(id, p)
+		}
+	}()
+
+	for {
+		typ, raw, err := w.conn.Read(ctx)
+		if err != nil {
+			w.logger.Info("window client disconnected", "error", err, "bytes_raw", w.stats.Raw(), "bytes_wire", w.stats.Wire())
+			return
+		}
+
+		if typ == websocket.MessageBinary {
+			pane, inner, err := DecodePaneFrame(raw)
+			if err != nil {
+				w.logger.Warn("invalid binary frame", "error", err)
+				continue
+			}
+			data, err := DecodeInputFrame(inner)
+			if err != nil {
+				w.logger.Warn("invalid binary frame", "pane", pane, "error", err)
+				continue
+			}
+			w.handleInput(ctx, pane, data)
+			continue
+		}
+
+		msg, err := ParseClientMessage(raw)
+		if err != nil {
+			w.logger.Warn("invalid message", "error", err)
+			continue
+		}
+		pm, ok := msg.(*PaneMsg)
+		if !ok {
+			w.logger.Warn("unexpected message type in window read loop", "msg", msg)
+			continue
+		}
+		inner, err := ParseClientMessage(pm.Msg)
+		if err != nil {
+			w.logger.Warn("invalid message", "pane", pm.Pane, "error", err)
+			continue
+		}
+		switch m := inner.(type) {
+		case *InputMsg:
+			data, err := base64.StdEncoding.DecodeString(m.Data)
+			if err != nil {
+				w.logger.Warn("invalid base64 input", "pane", pm.Pane, "error", err)
+				continue
+			}
+			w.handleInput(ctx, pm.Pane, data)
+		case *ResizeMsg:
+			w.handleResize(pm.Pane, m.Cols, m.Rows)
+		default:
+			w.logger.Warn("unexpected pane message type", "pane", pm.Pane, "msg", inner)
+		}
+	}
+}
+
+// handleInput forwards keystrokes to one of the window's panes.
+func (w *WindowConn) handleInput(ctx context.Context, pane string, data []byte) {
+	if w.info.Role != RoleControl {
+		w.sendJSON(ctx, ErrorMsg{Type: "error", Message: "read-only connection: input rejected"})
+		return
+	}
+	w.mu.Lock()
+	p := w.panes[pane]
+	w.mu.Unlock()
+	if p == nil {
+		w.logger.Warn("input for unknown pane", "pane", pane)
+		return
+	}
+	p.client.handleInput(data)
+}
+
+// handleResize offers a size for one of the window's panes to its Sizer.
+func (w *WindowConn) handleResize(pane string, cols, rows int) {
+	w.mu.Lock()
+	p := w.panes[pane]
+	w.mu.Unlock()
+	if p != nil {
+		p.client.handleResize(cols, rows)
+	}
+}
+
+// follow keeps the client's panes in step with the window, re-reading it
+// when tmux reports a change to its session and every windowRefresh.
+func (w *WindowConn) follow(ctx context.Context, hello *HelloMsg) {
+	defer w.sm.control.Listen(w.onEvent)()
+	ticker := time.NewTicker(windowRefresh)
+	defer ticker.Stop()
+	for {
+		w.refresh(ctx, hello)
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.kick:
+		case <-ticker.C:
+		}
+	}
+}
+
+// onEvent triggers a refresh on notifications that can change the window's
+// layout, its panes or their targets.
+func (w *WindowConn) onEvent(ev ControlEvent) {
+	switch ev.Name {
+	case "sessions-changed", "session-renamed":
+	case "layout-change", "window-pane-changed", "window-close", "unlinked-window-close":
+		w.mu.Lock()
+		session := w.session
+		w.mu.Unlock()
+		if ev.Session != session {
+			return
+		}
+	default:
+		return
+	}
+	select {
+	case w.kick <- struct{}{}:
+	default:
+	}
+}
+
+// refresh reads the window's layout and, if it changed, stops the streams
+// of panes that left the window or changed target, sends the new layout and
+// starts streams for the panes that are new to the client.
+func (w *WindowConn) refresh(ctx context.Context, hello *HelloMsg) {
+	info, err := ListWindowPanes(w.window)
+	if err != nil {
+		if !w.missing {
+			w.logger.Warn("window missing", "error", err)
+			w.missing, w.last = true, nil
+			w.mu.Lock()
+			for id, p := range w.panes {
+				w.stopPane(id, p)
+			}
+			w.mu.Unlock()
+			w.sendJSON(ctx, StatusMsg{Type: "status", PaneState: "missing"})
+		}
+		return
+	}
+	w.missing = false
+	root, err := ParseWindowLayout(info.Layout)
+	if err != nil {
+		w.logger.Warn("failed to parse window layout", "error", err)
+		return
+	}
+	raw, _ := json.Marshal(LayoutMsg{Type: "layout", Window: w.window, Layout: root, Panes: info.Panes})
+	if bytes.Equal(raw, w.last) {
+		return
+	}
+	w.last = raw
+
+	current := make(map[string]string, len(info.Panes))
+	for _, p := range info.Panes {
+		current[p.ID] = p.Target
+	}
+	w.mu.Lock()
+	w.session = info.Session
+	for id, p := range w.panes {
+		if current[id] != p.target {
+			w.stopPane(id, p)
+		}
+	}
+	w.mu.Unlock()
+
+	// New panes are routed to before the client sees them in the layout,
+	// so input typed straight away is not lost.
+	var added []*windowPane
+	for _, pane := range info.Panes {
+		w.mu.Lock()
+		_, ok := w.panes[pane.ID]
+		w.mu.Unlock()
+		if !ok {
+			added = append(added, w.addPane(ctx, pane))
+		}
+	}
+	if err := w.write(ctx, websocket.MessageText, raw); err != nil {
+		return
+	}
+	for _, p := range added {
+		w.startPane(p, hello)
+	}
+}
+
+// addPane sets up a pane's stream from the pane's Session.
+func (w *WindowConn) addPane(ctx context.Context, pane WindowPane) *windowPane {
+	sess := w.sm.Get(pane.Target)
+	waitAttached(ctx, sess, pane.Target)
+
+	ctx, cancel := context.WithCancel(ctx)
+	client := NewClient(w.conn, w.stats, sess.Hub, sess.PTY, sess.Ring, sess.VT, sess.Sizer, w.info, w.audit, w.cfg, w.logger.With("pane", pane.ID))
+	client.pane = pane.ID
+	p := &windowPane{id: pane.ID, target: pane.Target, sess: sess, client: client, ctx: ctx, cancel: cancel}
+	w.mu.Lock()
+	w.panes[pane.ID] = p
+	w.mu.Unlock()
+	return p
+}
+
+// startPane sends a new pane's replay and registers it for live output.
+func (w *WindowConn) startPane(p *windowPane, hello *HelloMsg) {
+	go p.client.writePump(p.ctx)
+	if err := p.client.start(p.ctx, paneHello(hello, p.id)); err != nil {
+		if p.ctx.Err() == nil {
+			p.client.logger.Error("pane stream start failed", "error", err)
+		}
+		w.mu.Lock()
+		if w.panes[p.id] == p {
+			w.stopPane(p.id, p)
+		}
+		w.mu.Unlock()
+		return
+	}
+	p.client.logger.Info("pane stream started", "target", p.target)
+}
+
+// stopPane ends a pane's stream. The caller must hold w.mu.
+func (w *WindowConn) stopPane(id string, p *windowPane) {
+	p.cancel()
+	p.sess.Hub.Unregister(p.client)
+	p.sess.Sizer.Leave(p.client.id)
+	delete(w.panes, id)
+}
+
+// waitAttached gives a pane's Session a moment to follow its target. After
+// a pane is killed the panes behind it move up an index, and the Session
+// for a target may still be attached to the pane that had it before.
+func waitAttached(ctx context.Context, sess *Session, target string) {
+	tty, err := ResolvePaneTTY(target)
+	if err != nil {
+		return
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for sess.TTY() != tty && time.Now().Before(deadline) {
+		sess.Monitor.ForceCheck()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// paneHello is the hello a pane's stream starts from: the window's, with
+// the pane's own resume point if the client sent one.
+func paneHello(hello *HelloMsg, pane string) *HelloMsg {
+	h := *hello
+	h.Panes = nil
+	if h.ReplayMode == "resume" {
+		p, ok := hello.Panes[pane]
+		if !ok {
+			h.ReplayMode = "tail"
+		}
+		h.Offset, h.Epoch = p.Offset, p.Epoch
+	}
+	return &h
+}
+
+// wrapPane wraps a message of the single-pane protocol for one pane of a
+// window connection.
+func wrapPane(pane string, typ websocket.MessageType, data []byte) (websocket.MessageType, []byte) {
+	if typ == websocket.MessageBinary {
+		return typ, EncodePaneFrame(pane, data)
+	}
+	raw, _ := json.Marshal(PaneMsg{Type: "pane", Pane: pane, Msg: data})
+	return typ, raw
+}
+
+func (w *WindowConn) write(ctx context.Context, typ websocket.MessageType, data []byte) error {
+	w.stats.raw.Add(int64(len(data)))
+	return w.conn.Write(ctx, typ, data)
+}
+
+func (w *WindowConn) sendJSON(ctx context.Context, msg any) error {
+	raw, _ := json.Marshal(msg)
+	return w.write(ctx, websocket.MessageText, raw)
+}
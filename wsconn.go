@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/coder/websocket"
+)
+
+// ConnStats counts the bytes sent on one WebSocket connection, before and
+// after permessage-deflate.
+type ConnStats struct {
+	raw  atomic.Int64 // message payloads handed to the WebSocket
+	wire atomic.Int64 // bytes written to the socket, after compression and framing
+}
+
+// Raw returns the uncompressed message bytes sent so far.
+func (s *ConnStats) Raw() int64 { return s.raw.Load() }
+
+// Wire returns the bytes actually written to the socket so far.
+func (s *ConnStats) Wire() int64 { return s.wire.Load() }
+
+// parseCompressionMode maps the --ws-compression flag to a websocket mode.
+func parseCompressionMode(v string) (websocket.CompressionMode, error) {
+	switch v {
+	case "disabled", "off", "":
+		return websocket.CompressionDisabled, nil
+	case "context-takeover":
+		return websocket.CompressionContextTakeover, nil
+	case "no-context-takeover":
+		return websocket.CompressionNoContextTakeover, nil
+	}
+	return 0, fmt.Errorf("invalid ws compression mode %q (want disabled, context-takeover or no-context-takeover)", v)
+}
+
+// acceptWS upgrades a request with the configured compression and returns
+// the connection with its byte counters.
+func acceptWS(w http.ResponseWriter, r *http.Request, cfg *Config, originPatterns []string) (*websocket.Conn, *ConnStats, error) {
+	mode, err := parseCompressionMode(cfg.WSCompression)
+	if err != nil {
+		return nil, nil, err
+	}
+	stats := &ConnStats{}
+	conn, err := websocket.Accept(&countingResponseWriter{ResponseWriter: w, stats: stats}, r, &websocket.AcceptOptions{
+		OriginPatterns:       originPatterns,
+		CompressionMode:      mode,
+		CompressionThreshold: cfg.WSCompressionThreshold,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, stats, nil
+}
+
+// countingResponseWriter hands the WebSocket library a hijacked connection
+// that counts the bytes written to it.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	stats *ConnStats
+}
+
+func (w *countingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *countingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := brw.Writer.Flush(); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	counted := &countingConn{Conn: conn, stats: w.stats}
+	return counted, bufio.NewReadWriter(brw.Reader, bufio.NewWriterSize(counted, brw.Writer.Size())), nil
+}
+
+type countingConn struct {
+	net.Conn
+	stats *ConnStats
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.stats.wire.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+func TestAcceptWSCompressionStats(t *testing.T) {
+	payload := bytes.Repeat([]byte("\x1b[32mclaude>\x1b[0m compiling main.go ...\r\n"), 2000)
+
+	for _, tc := range []struct {
+		mode       string
+		compressed bool
+	}{
+		{"disabled", false},
+		{"context-takeover", true},
+		{"no-context-takeover", true},
+	} {
+		t.Run(tc.mode, func(t *testing.T) {
+			cfg := &Config{WSCompression: tc.mode, WSCompressionThreshold: 512}
+			statsCh := make(chan *ConnStats, 1)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				conn, stats, err := acceptWS(w, r, cfg, nil)
+				if err != nil {
+					t.Errorf("accept: %v", err)
+					return
+				}
+				defer conn.CloseNow()
+				conn.Write(r.Context(), websocket.MessageBinary, payload)
+				statsCh <- stats
+				conn.Read(r.Context())
+			}))
+			defer srv.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http"), &websocket.DialOptions{
+				CompressionMode: websocket.CompressionContextTakeover,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.CloseNow()
+			conn.SetReadLimit(int64(len(payload)) + 1024)
+			_, got, err := conn.Read(ctx)
+			if err != nil || !bytes.Equal(got, payload) {
+				t.Fatalf("payload mismatch: %d bytes, %v", len(got), err)
+			}
+
+			// The wire counter sees the handshake and frames as they hit the socket.
+			wire := (<-statsCh).Wire()
+			raw := int64(len(payload))
+			if tc.compressed && wire > raw/10 {
+				t.Fatalf("expected compression, raw %d wire %d", raw, wire)
+			}
+			if !tc.compressed && wire < raw {
+				t.Fatalf("expected no compression, raw %d wire %d", raw, wire)
+			}
+		})
+	}
+
+	if _, err := parseCompressionMode("gzip"); err == nil {
+		t.Error("expected unknown mode to be rejected")
+	}
+}